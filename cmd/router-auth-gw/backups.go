@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mazzz1y/router-auth-gw/internal/backup"
+	"github.com/urfave/cli/v2"
+)
+
+func backupStore(c *cli.Context) (backup.Store, error) {
+	cfg, err := loadConfig(c)
+	if err != nil {
+		return nil, err
+	}
+	if !cfg.Backups.Enabled() {
+		return nil, fmt.Errorf("backups are not configured")
+	}
+	return backup.NewStore(cfg.Backups), nil
+}
+
+func backupsListAction(c *cli.Context) error {
+	store, err := backupStore(c)
+	if err != nil {
+		return err
+	}
+
+	versions, err := store.Versions(c.String("device"))
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tTIME")
+	for _, v := range versions {
+		fmt.Fprintf(w, "%s\t%s\n", v.ID, v.Time.Local().Format(time.DateTime))
+	}
+	return w.Flush()
+}
+
+// backupsDiffAction compares two versions, by default the latest one with
+// the previous one, or FROM with the latest one.
+func backupsDiffAction(c *cli.Context) error {
+	if c.NArg() > 2 {
+		return fmt.Errorf("expected at most FROM and TO arguments")
+	}
+
+	store, err := backupStore(c)
+	if err != nil {
+		return err
+	}
+
+	dev := c.String("device")
+	versions, err := store.Versions(dev)
+	if err != nil {
+		return err
+	}
+
+	from, to := c.Args().Get(0), c.Args().Get(1)
+	if to == "" {
+		to = versions[len(versions)-1].ID
+	}
+	if from == "" {
+		if len(versions) < 2 {
+			return fmt.Errorf("%q has only one version", dev)
+		}
+		from = versions[len(versions)-2].ID
+	}
+
+	fromData, err := store.Read(dev, from)
+	if err != nil {
+		return err
+	}
+	toData, err := store.Read(dev, to)
+	if err != nil {
+		return err
+	}
+
+	diff, err := backup.Diff(fromData, toData, dev+"@"+from, dev+"@"+to)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Print(diff)
+	return err
+}
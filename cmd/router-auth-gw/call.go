@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/mazzz1y/router-auth-gw/internal/device"
+	"github.com/urfave/cli/v2"
+)
+
+func callAction(c *cli.Context) error {
+	if c.NArg() != 2 {
+		return fmt.Errorf("expected METHOD and PATH arguments")
+	}
+	method, path := strings.ToUpper(c.Args().Get(0)), c.Args().Get(1)
+
+	client, err := deviceClient(c)
+	if err != nil {
+		return err
+	}
+
+	body, err := requestBody(c, method)
+	if err != nil {
+		return err
+	}
+
+	res, err := client.Request(c.Context, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if c.Bool("include") {
+		fmt.Printf("%s %s\n", res.Proto, res.Status)
+		res.Header.Write(os.Stdout)
+		fmt.Println()
+	}
+
+	if c.Bool("pretty") {
+		var buf bytes.Buffer
+		if json.Indent(&buf, data, "", "  ") == nil {
+			data = append(buf.Bytes(), '\n')
+		}
+	}
+
+	if _, err := os.Stdout.Write(data); err != nil {
+		return err
+	}
+
+	if res.StatusCode >= http.StatusBadRequest {
+		return cli.Exit(fmt.Sprintf("request failed with status: %s", res.Status), 1)
+	}
+	return nil
+}
+
+// deviceClient returns the client of the --device and --user flags.
+func deviceClient(c *cli.Context) (device.ClientWrapper, error) {
+	cfg, err := loadConfig(c)
+	if err != nil {
+		return nil, err
+	}
+
+	dm, err := device.NewDeviceManager(cfg.Devices)
+	if err != nil {
+		return nil, err
+	}
+
+	tag := c.String("device")
+	d, ok := dm.Devices[tag]
+	if !ok {
+		return nil, fmt.Errorf("device %q not found", tag)
+	}
+
+	return d.User(c.String("user"))
+}
+
+// requestBody returns the --data flag, or stdin for methods with a body if
+// it is not a terminal, so that scripts calling GET don't block on stdin.
+func requestBody(c *cli.Context, method string) (string, error) {
+	if c.IsSet("data") {
+		if data := c.String("data"); data != "-" {
+			return data, nil
+		}
+		return readStdin()
+	}
+
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+	default:
+		return "", nil
+	}
+	stat, err := os.Stdin.Stat()
+	if err != nil || stat.Mode()&os.ModeCharDevice != 0 {
+		return "", nil
+	}
+	return readStdin()
+}
+
+func readStdin() (string, error) {
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return "", fmt.Errorf("failed to read request body: %w", err)
+	}
+	return string(data), nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mazzz1y/router-auth-gw/internal/device"
+	"github.com/urfave/cli/v2"
+)
+
+type checkResult struct {
+	Device    string          `json:"device"`
+	Type      string          `json:"type"`
+	User      string          `json:"user"`
+	OK        bool            `json:"ok"`
+	LatencyMs int64           `json:"latency_ms"`
+	Identity  device.Identity `json:"identity"`
+	Error     string          `json:"error,omitempty"`
+
+	latency time.Duration
+}
+
+func checkAction(c *cli.Context) error {
+	cfg, err := loadConfig(c)
+	if err != nil {
+		return err
+	}
+
+	dm, err := device.NewDeviceManager(cfg.Devices)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(c.Context, c.Duration("timeout"))
+	defer cancel()
+
+	var (
+		results []checkResult
+		failed  int
+	)
+	for _, r := range dm.Check(ctx) {
+		res := checkResult{
+			Device:    r.Device,
+			Type:      r.Type,
+			User:      r.User,
+			OK:        r.Err == nil,
+			LatencyMs: r.Latency.Milliseconds(),
+			Identity:  r.Identity,
+			latency:   r.Latency,
+		}
+		if r.Err != nil {
+			res.Error = r.Err.Error()
+			failed++
+		}
+		results = append(results, res)
+	}
+
+	switch format := c.String("format"); format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			return err
+		}
+	case "text":
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "DEVICE\tTYPE\tUSER\tSTATUS\tLATENCY\tMODEL\tFIRMWARE\tERROR")
+		for _, r := range results {
+			status := "ok"
+			if !r.OK {
+				status = "FAIL"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				r.Device, r.Type, r.User, status, r.latency.Round(time.Millisecond), r.Identity.Model, r.Identity.Firmware, r.Error)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported format: %s", format)
+	}
+
+	if failed > 0 {
+		return cli.Exit(fmt.Sprintf("%d of %d checks failed", failed, len(results)), 1)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/mazzz1y/router-auth-gw/internal/device"
+	"github.com/mazzz1y/router-auth-gw/internal/drift"
+	"github.com/urfave/cli/v2"
+)
+
+func driftMonitor(c *cli.Context) (*drift.Monitor, error) {
+	cfg, err := loadConfig(c)
+	if err != nil {
+		return nil, err
+	}
+	if !cfg.Drift.Enabled() {
+		return nil, fmt.Errorf("drift is not configured")
+	}
+
+	dm, err := device.NewDeviceManager(cfg.Devices)
+	if err != nil {
+		return nil, err
+	}
+	return drift.New(cfg.Drift, dm, nil)
+}
+
+func driftPinAction(c *cli.Context) error {
+	m, err := driftMonitor(c)
+	if err != nil {
+		return err
+	}
+
+	dev := c.String("device")
+	if err := m.Pin(c.Context, dev); err != nil {
+		return err
+	}
+	fmt.Printf("pinned the current configuration of %q\n", dev)
+	return nil
+}
+
+type driftResult struct {
+	Device  string         `json:"device"`
+	Changes []drift.Change `json:"changes"`
+	Error   string         `json:"error,omitempty"`
+}
+
+func driftCheckAction(c *cli.Context) error {
+	m, err := driftMonitor(c)
+	if err != nil {
+		return err
+	}
+
+	var (
+		results []driftResult
+		drifted int
+	)
+	for _, r := range m.Check(c.Context) {
+		if dev := c.String("device"); dev != "" && r.Device != dev {
+			continue
+		}
+
+		res := driftResult{Device: r.Device, Changes: r.Changes}
+		if res.Changes == nil {
+			res.Changes = []drift.Change{}
+		}
+		if r.Err != nil {
+			res.Error = r.Err.Error()
+		}
+		if r.Err != nil || len(r.Changes) > 0 {
+			drifted++
+		}
+		results = append(results, res)
+	}
+
+	switch format := c.String("format"); format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			return err
+		}
+	case "text":
+		for _, r := range results {
+			switch {
+			case r.Error != "":
+				fmt.Printf("%s: %s\n", r.Device, r.Error)
+			case len(r.Changes) == 0:
+				fmt.Printf("%s: no drift\n", r.Device)
+			default:
+				fmt.Printf("%s: %d sections drifted\n", r.Device, len(r.Changes))
+				for _, ch := range r.Changes {
+					fmt.Printf("  %s %s\n", ch.Kind, ch.Section)
+					for _, l := range ch.Removed {
+						fmt.Printf("    - %s\n", l)
+					}
+					for _, l := range ch.Added {
+						fmt.Printf("    + %s\n", l)
+					}
+				}
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported format: %s", format)
+	}
+
+	if drifted > 0 {
+		return cli.Exit(fmt.Sprintf("%d of %d devices drifted or failed", drifted, len(results)), 1)
+	}
+	return nil
+}
@@ -2,11 +2,12 @@ package main
 
 import (
 	"os"
-	"sync"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/mazzz1y/router-auth-gw/internal/config"
-	"github.com/mazzz1y/router-auth-gw/internal/device"
-	"github.com/mazzz1y/router-auth-gw/internal/entrypoint"
+	"github.com/mazzz1y/router-auth-gw/internal/gateway"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/urfave/cli/v2"
@@ -14,6 +15,19 @@ import (
 
 var version = "custom"
 
+var deviceFlag = &cli.StringFlag{
+	Name:     "device",
+	Aliases:  []string{"d"},
+	Required: true,
+	Usage:    "device tag",
+}
+
+var userFlag = &cli.StringFlag{
+	Name:    "user",
+	Aliases: []string{"u"},
+	Usage:   "device user, the first user of the device if not set",
+}
+
 func main() {
 	app := &cli.App{
 		Name:    "router-auth-gw",
@@ -39,12 +53,162 @@ func main() {
 				Value:   "pretty",
 				Usage:   "Logging format/type (e.g. pretty, json)",
 			},
+			&cli.StringFlag{
+				Name:    "age-key-file",
+				EnvVars: []string{"AGE_KEY_FILE"},
+				Usage:   "path to age identities for decrypting !age config values (AGE_KEY is used if not set)",
+			},
+		},
+		Before: func(c *cli.Context) error {
+			setLogLevel(c.String("log-level"), c.String("log-type"))
+			return nil
 		},
 		Commands: []*cli.Command{
 			{
 				Name:   "start",
 				Usage:  "start proxy servers based on config",
 				Action: startServersAction,
+				Flags: []cli.Flag{
+					&cli.DurationFlag{
+						Name:    "config-watch-interval",
+						EnvVars: []string{"CONFIG_WATCH_INTERVAL"},
+						Value:   5 * time.Second,
+						Usage:   "interval for checking the config file for changes, 0 disables watching (SIGHUP still reloads)",
+					},
+				},
+			},
+			{
+				Name:   "validate",
+				Usage:  "check the config and report all problems, exits with 1 if any are found",
+				Action: validateAction,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "format",
+						Value: "text",
+						Usage: "output format (text, json)",
+					},
+				},
+			},
+			{
+				Name:   "check",
+				Usage:  "log into every device as every user and read the device identity, exits with 1 if any check fails",
+				Action: checkAction,
+				Flags: []cli.Flag{
+					&cli.DurationFlag{
+						Name:  "timeout",
+						Value: 30 * time.Second,
+						Usage: "timeout for all checks",
+					},
+					&cli.StringFlag{
+						Name:  "format",
+						Value: "text",
+						Usage: "output format (text, json)",
+					},
+				},
+			},
+			{
+				Name:      "call",
+				Usage:     "send an authenticated request to a device, the body of POST, PUT and PATCH requests is read from stdin if it is not a terminal",
+				ArgsUsage: "METHOD PATH",
+				Action:    callAction,
+				Flags: []cli.Flag{
+					deviceFlag,
+					userFlag,
+					&cli.BoolFlag{
+						Name:    "pretty",
+						Aliases: []string{"p"},
+						Usage:   "pretty-print JSON responses",
+					},
+					&cli.BoolFlag{
+						Name:    "include",
+						Aliases: []string{"i"},
+						Usage:   "print the response status and headers",
+					},
+					&cli.StringFlag{
+						Name:  "data",
+						Usage: `request body, "-" to read it from stdin`,
+					},
+				},
+			},
+			{
+				Name:   "shell",
+				Usage:  "open an interactive CLI of a keenetic device, commands are read from stdin if it is not a terminal",
+				Action: shellAction,
+				Flags: []cli.Flag{
+					deviceFlag,
+					userFlag,
+				},
+			},
+			{
+				Name:  "backups",
+				Usage: "inspect the device configuration backups",
+				Subcommands: []*cli.Command{
+					{
+						Name:   "list",
+						Usage:  "list the backup versions of a device",
+						Action: backupsListAction,
+						Flags:  []cli.Flag{deviceFlag},
+					},
+					{
+						Name:      "diff",
+						Usage:     "show the changes between two versions, by default between the last two",
+						ArgsUsage: "[FROM [TO]]",
+						Action:    backupsDiffAction,
+						Flags:     []cli.Flag{deviceFlag},
+					},
+				},
+			},
+			{
+				Name:  "drift",
+				Usage: "compare the device configurations with pinned golden snapshots",
+				Subcommands: []*cli.Command{
+					{
+						Name:   "pin",
+						Usage:  "save the current configuration of a device as its golden snapshot",
+						Action: driftPinAction,
+						Flags:  []cli.Flag{deviceFlag},
+					},
+					{
+						Name:   "check",
+						Usage:  "show the drift of every device, exits with 1 if any device drifted",
+						Action: driftCheckAction,
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:    "device",
+								Aliases: []string{"d"},
+								Usage:   "device tag, all monitored devices if not set",
+							},
+							&cli.StringFlag{
+								Name:  "format",
+								Value: "text",
+								Usage: "output format (text, json)",
+							},
+						},
+					},
+				},
+			},
+			{
+				Name:      "encrypt-value",
+				Usage:     "encrypt a value from stdin for use as !age in config",
+				ArgsUsage: " ",
+				Action:    encryptValueAction,
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{
+						Name:    "recipient",
+						Aliases: []string{"r"},
+						Usage:   "age public key to encrypt to, can be repeated",
+					},
+					&cli.StringSliceFlag{
+						Name:    "recipients-file",
+						Aliases: []string{"R"},
+						Usage:   "file with age public keys to encrypt to, can be repeated",
+					},
+				},
+			},
+			{
+				Name:   "decrypt-config",
+				Usage:  "print the config with all !age values decrypted",
+				Action: decryptConfigAction,
 			},
 		},
 	}
@@ -56,53 +220,36 @@ func main() {
 
 func startServersAction(c *cli.Context) error {
 	configPath := c.String("config")
-	logLevel := c.String("log-level")
-	logType := c.String("log-type")
-
-	setLogLevel(logLevel, logType)
 
-	cfg, err := config.LoadConfig(configPath)
+	cfg, err := loadConfig(c)
 	if err != nil {
 		return err
 	}
 
-	dm, err := device.NewDeviceManager(cfg.Devices)
+	ctx, stop := signal.NotifyContext(c.Context, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	hup, stopHup := notifyReload()
+	defer stopHup()
+
+	gw, err := gateway.New(ctx, cfg)
 	if err != nil {
 		return err
 	}
 
-	var wg sync.WaitGroup
-	for _, entryCfg := range cfg.Entrypoints {
-		wg.Add(1)
-		go startServer(dm, entryCfg, &wg)
-	}
+	go watchConfig(ctx, hup, configPath, c.Duration("config-watch-interval"), func() (*config.Config, error) {
+		return loadConfig(c)
+	}, gw)
 
-	wg.Wait()
-	return nil
+	return gw.Run(ctx)
 }
 
-func startServer(dm *device.Manager, entryCfg config.EntrypointConfig, wg *sync.WaitGroup) {
-	defer wg.Done()
-
-	d, ok := dm.Devices[entryCfg.DeviceTag]
-	if !ok {
-		log.Fatal().Msgf("%s: \"%s\" device not found", entryCfg.Listen, entryCfg.DeviceTag)
-	}
-
-	err := entrypoint.NewEntrypoint(entrypoint.Options{
-		Device:              d,
-		ListenAddr:          entryCfg.Listen,
-		ForwardAuthHeader:   entryCfg.ForwardAuth.Header,
-		ForwardAuthMapping:  entryCfg.ForwardAuth.Mapping,
-		BasicAuth:           entryCfg.BasicAuthMap(),
-		AllowedEndpoints:    entryCfg.AllowedEndpoints,
-		BypassAuthEndpoints: entryCfg.BypassAuthEndpoints,
-		OnlyGet:             entryCfg.ReadOnly,
-	}).Start()
-
+func loadConfig(c *cli.Context) (*config.Config, error) {
+	ids, err := config.AgeIdentities(c.String("age-key-file"))
 	if err != nil {
-		log.Fatal().Err(err).Msg("failed to start entrypoint")
+		return nil, err
 	}
+
+	return config.LoadConfig(c.String("config"), config.WithAgeIdentities(ids))
 }
 
 func setLogLevel(logLevel string, logType string) {
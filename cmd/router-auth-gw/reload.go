@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/mazzz1y/router-auth-gw/internal/config"
+	"github.com/mazzz1y/router-auth-gw/internal/gateway"
+	"github.com/rs/zerolog/log"
+)
+
+// notifyReload returns the channel of SIGHUP signals. It is registered before
+// the gateway starts, as an unhandled SIGHUP terminates the process.
+func notifyReload() (<-chan os.Signal, func()) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	return hup, func() { signal.Stop(hup) }
+}
+
+// watchConfig reloads the configuration on hup signals and, if interval is
+// not zero, when the content of the file changes.
+func watchConfig(ctx context.Context, hup <-chan os.Signal, path string, interval time.Duration, load func() (*config.Config, error), gw *gateway.Gateway) {
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	lastSum, _ := fileSum(path)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			log.Info().Msg("received SIGHUP, reloading configuration")
+		case <-tick:
+			sum, err := fileSum(path)
+			if err != nil || sum == lastSum {
+				continue
+			}
+			log.Info().Msg("configuration file changed, reloading")
+		}
+
+		lastSum, _ = fileSum(path)
+		reloadConfig(load, gw)
+	}
+}
+
+func reloadConfig(load func() (*config.Config, error), gw *gateway.Gateway) {
+	cfg, err := load()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to load configuration, keeping the running one")
+		return
+	}
+
+	if err := gw.Reload(cfg); err != nil {
+		log.Error().Err(err).Msg("failed to apply configuration, keeping the running one")
+	}
+}
+
+func fileSum(path string) ([32]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return [32]byte{}, err
+	}
+	return sha256.Sum256(data), nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/mazzz1y/router-auth-gw/internal/config"
+	"github.com/urfave/cli/v2"
+)
+
+func encryptValueAction(c *cli.Context) error {
+	recipients, err := config.AgeRecipients(c.StringSlice("recipient"), c.StringSlice("recipients-file"))
+	if err != nil {
+		return err
+	}
+
+	value, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return fmt.Errorf("failed to read value: %w", err)
+	}
+
+	encrypted, err := config.EncryptValue(strings.TrimRight(string(value), "\r\n"), recipients)
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(encrypted)
+	return nil
+}
+
+func decryptConfigAction(c *cli.Context) error {
+	ids, err := config.AgeIdentities(c.String("age-key-file"))
+	if err != nil {
+		return err
+	}
+
+	data, err := config.DecryptFile(c.String("config"), ids)
+	if err != nil {
+		return err
+	}
+
+	_, err = os.Stdout.Write(data)
+	return err
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/mazzz1y/router-auth-gw/internal/shell"
+	"github.com/mazzz1y/router-auth-gw/pkg/keenetic"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/term"
+)
+
+func shellAction(c *cli.Context) error {
+	client, err := deviceClient(c)
+	if err != nil {
+		return err
+	}
+
+	kc, ok := client.(*keenetic.Client)
+	if !ok {
+		return fmt.Errorf("shell is only supported for keenetic devices")
+	}
+
+	sh := shell.New(c.Context, kc)
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		// Run commands from a pipe one per line, without the prompt.
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+			if err := sh.Exec(os.Stdout, line); err != nil {
+				return err
+			}
+		}
+		return scanner.Err()
+	}
+
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return fmt.Errorf("failed to set terminal raw mode: %w", err)
+	}
+	defer term.Restore(fd, state)
+
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}
+
+	return sh.Run(rw, c.String("device")+"> ")
+}
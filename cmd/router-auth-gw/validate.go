@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/mazzz1y/router-auth-gw/internal/config"
+	"github.com/urfave/cli/v2"
+)
+
+type validateResult struct {
+	Config   string           `json:"config"`
+	Valid    bool             `json:"valid"`
+	Problems []config.Problem `json:"problems"`
+}
+
+func validateAction(c *cli.Context) error {
+	res := validateResult{
+		Config:   c.String("config"),
+		Problems: []config.Problem{},
+	}
+
+	cfg, err := loadConfig(c)
+	if err != nil {
+		res.Problems = append(res.Problems, config.Problem{Message: err.Error()})
+	} else {
+		res.Problems = append(res.Problems, config.Validate(cfg)...)
+	}
+	res.Valid = len(res.Problems) == 0
+
+	switch format := c.String("format"); format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(res); err != nil {
+			return err
+		}
+	case "text":
+		for _, p := range res.Problems {
+			fmt.Println(p)
+		}
+		if res.Valid {
+			fmt.Printf("%s: configuration is valid\n", res.Config)
+		} else {
+			fmt.Printf("%s: %d problem(s) found\n", res.Config, len(res.Problems))
+		}
+	default:
+		return fmt.Errorf("unsupported format: %s", format)
+	}
+
+	if !res.Valid {
+		return cli.Exit("", 1)
+	}
+	return nil
+}
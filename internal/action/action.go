@@ -0,0 +1,242 @@
+package action
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/mazzz1y/router-auth-gw/internal/config"
+	"github.com/mazzz1y/router-auth-gw/internal/device"
+)
+
+// Action is a templated request to a device.
+type Action struct {
+	Name      string
+	DeviceTag string
+	Method    string
+	Users     []string
+	AllowMQTT bool
+
+	path   *template.Template
+	body   *template.Template
+	params map[string]param
+	client device.ClientWrapper
+}
+
+type param struct {
+	pattern  *regexp.Regexp
+	enum     []string
+	def      string
+	required bool
+}
+
+// Result is the device response of an action.
+type Result struct {
+	StatusCode  int
+	ContentType string
+	Body        []byte
+}
+
+// ParamError is returned for invalid action parameters.
+type ParamError struct {
+	Param   string
+	Message string
+}
+
+func (e *ParamError) Error() string {
+	if e.Param == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("param %q: %s", e.Param, e.Message)
+}
+
+// New creates all actions of the configuration.
+func New(cfg map[string]config.ActionConfig, dm *device.Manager) (map[string]*Action, error) {
+	actions := make(map[string]*Action, len(cfg))
+	for name, c := range cfg {
+		a, err := newAction(name, c, dm)
+		if err != nil {
+			return nil, fmt.Errorf("action %q: %w", name, err)
+		}
+		actions[name] = a
+	}
+	return actions, nil
+}
+
+func newAction(name string, c config.ActionConfig, dm *device.Manager) (*Action, error) {
+	d, ok := dm.Devices[c.DeviceTag]
+	if !ok {
+		return nil, fmt.Errorf("device %q not found", c.DeviceTag)
+	}
+
+	client, err := d.User(c.User)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Action{
+		Name:      name,
+		DeviceTag: c.DeviceTag,
+		Method:    strings.ToUpper(c.Method),
+		Users:     c.Users,
+		AllowMQTT: c.AllowMQTT,
+		params:    make(map[string]param, len(c.Params)),
+		client:    client,
+	}
+	if a.Method == "" {
+		a.Method = http.MethodPost
+	}
+
+	for pName, p := range c.Params {
+		var pattern *regexp.Regexp
+		if p.Pattern != "" {
+			pattern, err = regexp.Compile("^(?:" + p.Pattern + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("param %q: invalid pattern: %w", pName, err)
+			}
+		}
+		a.params[pName] = param{pattern: pattern, enum: p.Enum, def: p.Default, required: p.Required}
+	}
+
+	if a.path, err = parseTemplate("path", c.Path); err != nil {
+		return nil, err
+	}
+	if a.body, err = parseTemplate("body", c.Body); err != nil {
+		return nil, err
+	}
+
+	// Catch references to undeclared params at startup rather than at runtime.
+	sample := make(map[string]string, len(a.params))
+	for pName := range a.params {
+		sample[pName] = "x"
+	}
+	if _, _, err := a.render(sample); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// Allowed reports whether the authenticated user can run the action.
+func (a *Action) Allowed(identity string) bool {
+	return len(a.Users) == 0 || slices.Contains(a.Users, identity)
+}
+
+// Params validates the request params and fills in the defaults.
+func (a *Action) Params(in map[string]string) (map[string]string, error) {
+	for name := range in {
+		if _, ok := a.params[name]; !ok {
+			return nil, &ParamError{Param: name, Message: "unknown param"}
+		}
+	}
+
+	out := make(map[string]string, len(a.params))
+	for _, name := range sortedKeys(a.params) {
+		p := a.params[name]
+
+		v, ok := in[name]
+		if !ok {
+			if p.required {
+				return nil, &ParamError{Param: name, Message: "required"}
+			}
+			v = p.def
+		}
+
+		if len(p.enum) > 0 && !slices.Contains(p.enum, v) {
+			return nil, &ParamError{Param: name, Message: "must be one of: " + strings.Join(p.enum, ", ")}
+		}
+		if p.pattern != nil && !p.pattern.MatchString(v) {
+			return nil, &ParamError{Param: name, Message: "does not match the pattern"}
+		}
+
+		out[name] = v
+	}
+
+	return out, nil
+}
+
+// Render validates the params and returns the path and body of the request.
+func (a *Action) Render(in map[string]string) (string, string, error) {
+	params, err := a.Params(in)
+	if err != nil {
+		return "", "", err
+	}
+	return a.render(params)
+}
+
+// Run validates the params and sends the request to the device.
+func (a *Action) Run(ctx context.Context, in map[string]string) (*Result, error) {
+	path, body, err := a.Render(in)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := a.client.Request(ctx, a.Method, path, body)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	return &Result{
+		StatusCode:  res.StatusCode,
+		ContentType: res.Header.Get("Content-Type"),
+		Body:        data,
+	}, nil
+}
+
+// render executes the templates. Param values are escaped for URL path
+// segments in the path and for JSON strings in the body.
+func (a *Action) render(params map[string]string) (string, string, error) {
+	pathParams := make(map[string]string, len(params))
+	bodyParams := make(map[string]string, len(params))
+	for k, v := range params {
+		pathParams[k] = url.PathEscape(v)
+		quoted, _ := json.Marshal(v)
+		bodyParams[k] = string(quoted[1 : len(quoted)-1])
+	}
+
+	var path, body bytes.Buffer
+	if err := a.path.Execute(&path, pathParams); err != nil {
+		return "", "", err
+	}
+	if err := a.body.Execute(&body, bodyParams); err != nil {
+		return "", "", err
+	}
+
+	if body.Len() > 0 && !json.Valid(body.Bytes()) {
+		return "", "", &ParamError{Message: "body is not valid JSON"}
+	}
+
+	return path.String(), body.String(), nil
+}
+
+func parseTemplate(name, text string) (*template.Template, error) {
+	t, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s template: %w", name, err)
+	}
+	return t, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,106 @@
+package action
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/mazzz1y/router-auth-gw/internal/config"
+	"github.com/mazzz1y/router-auth-gw/internal/device"
+	"golang.org/x/net/websocket"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockClient struct {
+	method, endpoint, body string
+}
+
+func (m *mockClient) Request(_ context.Context, method, endpoint, body string) (*http.Response, error) {
+	m.method, m.endpoint, m.body = method, endpoint, body
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(`{}`)),
+	}, nil
+}
+
+func (m *mockClient) Websocket() (*websocket.Conn, error) {
+	return nil, nil
+}
+
+func newManager(client device.ClientWrapper) *device.Manager {
+	return &device.Manager{Devices: map[string]device.Device{
+		"router": {Tag: "router", Users: []device.User{{Name: "admin", Client: client}}},
+	}}
+}
+
+func TestAction(t *testing.T) {
+	client := &mockClient{}
+	actions, err := New(map[string]config.ActionConfig{
+		"wake": {
+			DeviceTag: "router",
+			Path:      "/rci/ip/hotspot/{{ .section }}",
+			Body:      `{"mac": "{{ .mac }}", "comment": "{{ .comment }}"}`,
+			Params: map[string]config.ActionParamConfig{
+				"mac":     {Pattern: `([0-9a-f]{2}:){5}[0-9a-f]{2}`, Required: true},
+				"section": {Enum: []string{"wake", "host"}, Default: "wake"},
+				"comment": {},
+			},
+			Users: []string{"alice"},
+		},
+	}, newManager(client))
+	require.NoError(t, err)
+
+	a := actions["wake"]
+	assert.True(t, a.Allowed("alice"))
+	assert.False(t, a.Allowed("bob"))
+
+	t.Run("Success", func(t *testing.T) {
+		res, err := a.Run(context.Background(), map[string]string{
+			"mac":     "aa:bb:cc:dd:ee:ff",
+			"comment": `quote " and \ backslash`,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, http.MethodPost, client.method)
+		assert.Equal(t, "/rci/ip/hotspot/wake", client.endpoint)
+		assert.JSONEq(t, `{"mac":"aa:bb:cc:dd:ee:ff","comment":"quote \" and \\ backslash"}`, client.body)
+	})
+
+	tests := []struct {
+		name   string
+		params map[string]string
+		err    string
+	}{
+		{name: "Missing", params: map[string]string{}, err: `param "mac": required`},
+		{name: "Pattern", params: map[string]string{"mac": "aa:bb:cc:dd:ee:ff\"}"}, err: `param "mac": does not match the pattern`},
+		{name: "Enum", params: map[string]string{"mac": "aa:bb:cc:dd:ee:ff", "section": "x"}, err: `param "section": must be one of: wake, host`},
+		{name: "Unknown", params: map[string]string{"mac": "aa:bb:cc:dd:ee:ff", "ip": "1"}, err: `param "ip": unknown param`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := a.Run(context.Background(), tt.params)
+			var paramErr *ParamError
+			assert.ErrorAs(t, err, &paramErr)
+			assert.EqualError(t, err, tt.err)
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	dm := newManager(&mockClient{})
+
+	_, err := New(map[string]config.ActionConfig{
+		"reboot": {DeviceTag: "missing", Path: "/rci/system/reboot"},
+	}, dm)
+	assert.EqualError(t, err, `action "reboot": device "missing" not found`)
+
+	_, err = New(map[string]config.ActionConfig{
+		"reboot": {DeviceTag: "router", Path: "/rci/system/reboot", Body: `{"delay": {{ .delay }}}`},
+	}, dm)
+	assert.ErrorContains(t, err, `map has no entry for key "delay"`)
+}
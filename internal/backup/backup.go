@@ -0,0 +1,148 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mazzz1y/router-auth-gw/internal/config"
+	"github.com/mazzz1y/router-auth-gw/internal/device"
+	"github.com/mazzz1y/router-auth-gw/internal/worker"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultInterval = 24 * time.Hour
+	fetchTimeout    = time.Minute
+)
+
+// Backuper periodically saves the configurations of the devices.
+type Backuper struct {
+	store    Store
+	interval time.Duration
+	devices  []device.Device
+	group    worker.Group
+}
+
+// Result is the outcome of a backup of a device.
+type Result struct {
+	Device  string
+	Version Version
+	Changed bool
+	Err     error
+}
+
+// New creates the backuper of the configuration, without a store it backs up
+// nothing.
+func New(cfg config.BackupConfig, dm *device.Manager) (*Backuper, error) {
+	b := &Backuper{interval: cfg.Interval}
+	if !cfg.Enabled() {
+		return b, nil
+	}
+
+	b.store = NewStore(cfg)
+	if b.interval <= 0 {
+		b.interval = defaultInterval
+	}
+
+	devices, err := dm.Select(cfg.Devices)
+	if err != nil {
+		return nil, fmt.Errorf("backups: %w", err)
+	}
+	b.devices = devices
+
+	return b, nil
+}
+
+// Start backs up the devices now and then every interval.
+func (b *Backuper) Start(ctx context.Context) {
+	if b.store == nil {
+		return
+	}
+
+	ctx = b.group.Start(ctx)
+	b.group.Go(func() {
+		worker.Every(ctx, b.interval, func(ctx context.Context) { b.Run(ctx) })
+	})
+}
+
+// Stop cancels running backups and waits for them to finish.
+func (b *Backuper) Stop() {
+	b.group.Stop()
+}
+
+// Run backs up all devices once and logs the results.
+func (b *Backuper) Run(ctx context.Context) []Result {
+	results := make([]Result, 0, len(b.devices))
+	for _, d := range b.devices {
+		res := b.backup(ctx, d)
+
+		l := log.With().Str("device", d.Tag).Logger()
+		switch {
+		case res.Err != nil:
+			l.Error().Err(res.Err).Msg("config backup failed")
+		case res.Changed:
+			l.Info().Str("version", res.Version.ID).Msg("config backup saved")
+		default:
+			l.Debug().Str("version", res.Version.ID).Msg("config unchanged")
+		}
+
+		results = append(results, res)
+	}
+	return results
+}
+
+func (b *Backuper) backup(ctx context.Context, d device.Device) Result {
+	res := Result{Device: d.Tag}
+
+	client, err := d.User("")
+	if err != nil {
+		res.Err = err
+		return res
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+
+	data, err := device.ReadConfig(ctx, client)
+	if err != nil {
+		res.Err = fmt.Errorf("failed to read config: %w", err)
+		return res
+	}
+
+	res.Version, res.Changed, res.Err = b.store.Save(d.Tag, fileExt(d.Type), data, time.Now())
+	return res
+}
+
+// Diff returns the unified diff between two versions.
+func Diff(from, to []byte, fromName, toName string) (string, error) {
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        splitLines(from),
+		B:        splitLines(to),
+		FromFile: fromName,
+		ToFile:   toName,
+		Context:  3,
+	})
+}
+
+// splitLines splits data after newlines. Unlike difflib.SplitLines, it
+// doesn't add an empty line after a trailing newline.
+func splitLines(data []byte) []string {
+	lines := strings.SplitAfter(string(data), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// fileExts are the extensions returned by fileExt.
+var fileExts = []string{"txt", "json"}
+
+func fileExt(deviceType string) string {
+	if deviceType == "keenetic" {
+		return "txt"
+	}
+	return "json"
+}
@@ -0,0 +1,134 @@
+package backup
+
+import (
+	"context"
+	"net/http"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/mazzz1y/router-auth-gw/internal/config"
+	"github.com/mazzz1y/router-auth-gw/internal/device"
+	"golang.org/x/net/websocket"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockClient struct {
+	config string
+}
+
+func (m *mockClient) Request(context.Context, string, string, string) (*http.Response, error) {
+	return nil, nil
+}
+
+func (m *mockClient) Websocket() (*websocket.Conn, error) {
+	return nil, nil
+}
+
+func (m *mockClient) ReadConfig(context.Context) ([]byte, error) {
+	return []byte(m.config), nil
+}
+
+func TestStore(t *testing.T) {
+	stores := map[string]config.BackupConfig{
+		"Dir": {Dir: t.TempDir()},
+		"Git": {Dir: t.TempDir(), Git: true},
+	}
+
+	for name, cfg := range stores {
+		t.Run(name, func(t *testing.T) {
+			if cfg.Git {
+				if _, err := exec.LookPath("git"); err != nil {
+					t.Skip("git is not installed")
+				}
+			}
+
+			store := NewStore(cfg)
+			start := time.Date(2026, 10, 1, 4, 0, 0, 0, time.UTC)
+
+			_, err := store.Versions("router")
+			assert.ErrorIs(t, err, ErrNoVersions)
+
+			v1, changed, err := store.Save("router", "txt", []byte("a\nb\n"), start)
+			require.NoError(t, err)
+			assert.True(t, changed)
+
+			v, changed, err := store.Save("router", "txt", []byte("a\nb\n"), start.Add(time.Hour))
+			require.NoError(t, err)
+			assert.False(t, changed)
+			assert.Equal(t, v1, v)
+
+			v2, changed, err := store.Save("router", "txt", []byte("a\nc\n"), start.Add(2*time.Hour))
+			require.NoError(t, err)
+			assert.True(t, changed)
+
+			versions, err := store.Versions("router")
+			require.NoError(t, err)
+			assert.Equal(t, []Version{v1, v2}, versions)
+			assert.Equal(t, start.Add(2*time.Hour), versions[1].Time)
+
+			data, err := store.Read("router", v1.ID)
+			require.NoError(t, err)
+			assert.Equal(t, "a\nb\n", string(data))
+
+			_, err = store.Read("router", "0000000")
+			assert.Error(t, err)
+
+			_, _, err = store.Save("office.lab", "json", []byte("{}"), start)
+			require.NoError(t, err)
+			_, err = store.Versions("office")
+			assert.ErrorIs(t, err, ErrNoVersions)
+
+			v3, _, err := store.Save("office", "json", []byte(`{"a":1}`), start)
+			require.NoError(t, err)
+			versions, err = store.Versions("office")
+			require.NoError(t, err)
+			assert.Equal(t, []Version{v3}, versions)
+			data, err = store.Read("office", v3.ID)
+			require.NoError(t, err)
+			assert.Equal(t, `{"a":1}`, string(data))
+		})
+	}
+}
+
+func TestBackuper(t *testing.T) {
+	client := &mockClient{config: "interface Bridge0\n"}
+	dm := &device.Manager{Devices: map[string]device.Device{
+		"router": {Tag: "router", Type: "keenetic", Users: []device.User{{Name: "admin", Client: client}}},
+	}}
+
+	_, err := New(config.BackupConfig{Dir: t.TempDir(), Devices: []string{"missing"}}, dm)
+	assert.EqualError(t, err, `backups: device "missing" not found`)
+
+	cfg := config.BackupConfig{Dir: t.TempDir()}
+	b, err := New(cfg, dm)
+	require.NoError(t, err)
+
+	results := b.Run(context.Background())
+	require.Len(t, results, 1)
+	require.NoError(t, results[0].Err)
+	assert.True(t, results[0].Changed)
+
+	results = b.Run(context.Background())
+	assert.False(t, results[0].Changed)
+
+	client.config = "interface Bridge1\n"
+	results = b.Run(context.Background())
+	assert.True(t, results[0].Changed)
+
+	store := NewStore(cfg)
+	versions, err := store.Versions("router")
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+
+	from, err := store.Read("router", versions[0].ID)
+	require.NoError(t, err)
+	to, err := store.Read("router", versions[1].ID)
+	require.NoError(t, err)
+
+	diff, err := Diff(from, to, "old", "new")
+	require.NoError(t, err)
+	assert.Equal(t, "--- old\n+++ new\n@@ -1 +1 @@\n-interface Bridge0\n+interface Bridge1\n", diff)
+}
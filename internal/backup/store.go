@@ -0,0 +1,253 @@
+package backup
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mazzz1y/router-auth-gw/internal/config"
+)
+
+const (
+	timeFormat = "20060102T150405Z"
+
+	gitUser  = "router-auth-gw"
+	gitEmail = "router-auth-gw@localhost"
+)
+
+// ErrNoVersions is returned for devices without backups.
+var ErrNoVersions = errors.New("no backups")
+
+// Version is a stored configuration of a device.
+type Version struct {
+	ID   string    `json:"id"`
+	Time time.Time `json:"time"`
+}
+
+// Store keeps the configuration versions of devices.
+type Store interface {
+	// Save stores data as a new version unless it equals the latest one.
+	Save(device, ext string, data []byte, t time.Time) (Version, bool, error)
+	// Versions returns the versions of a device, oldest first.
+	Versions(device string) ([]Version, error)
+	Read(device, id string) ([]byte, error)
+}
+
+// NewStore returns the store of the configuration.
+func NewStore(cfg config.BackupConfig) Store {
+	if cfg.Git {
+		return &gitStore{dir: cfg.Dir}
+	}
+	return &dirStore{dir: cfg.Dir}
+}
+
+// dirStore keeps every version as <dir>/<device>/<time>.<ext>.
+type dirStore struct {
+	dir string
+}
+
+func (s *dirStore) Save(device, ext string, data []byte, t time.Time) (Version, bool, error) {
+	t = t.UTC().Truncate(time.Second)
+
+	versions, err := s.Versions(device)
+	if err != nil && !errors.Is(err, ErrNoVersions) {
+		return Version{}, false, err
+	}
+	if len(versions) > 0 {
+		latest := versions[len(versions)-1]
+		prev, err := s.Read(device, latest.ID)
+		if err != nil {
+			return Version{}, false, err
+		}
+		if bytes.Equal(prev, data) {
+			return latest, false, nil
+		}
+		// Versions are named by the second, keep them unique and ordered.
+		if !t.After(latest.Time) {
+			t = latest.Time.Add(time.Second)
+		}
+	}
+
+	dir := filepath.Join(s.dir, device)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return Version{}, false, err
+	}
+
+	v := Version{ID: t.Format(timeFormat), Time: t}
+	if err := os.WriteFile(filepath.Join(dir, v.ID+"."+ext), data, 0o600); err != nil {
+		return Version{}, false, err
+	}
+	return v, true, nil
+}
+
+func (s *dirStore) Versions(device string) ([]Version, error) {
+	entries, err := os.ReadDir(filepath.Join(s.dir, device))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNoVersions
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []Version
+	for _, e := range entries {
+		id, _, _ := strings.Cut(e.Name(), ".")
+		t, err := time.Parse(timeFormat, id)
+		if e.IsDir() || err != nil {
+			continue
+		}
+		versions = append(versions, Version{ID: id, Time: t})
+	}
+	if len(versions) == 0 {
+		return nil, ErrNoVersions
+	}
+
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Time.Before(versions[j].Time) })
+	return versions, nil
+}
+
+func (s *dirStore) Read(device, id string) ([]byte, error) {
+	matches, err := filepath.Glob(filepath.Join(s.dir, device, id+".*"))
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("version %q of %q not found", id, device)
+	}
+	return os.ReadFile(matches[0])
+}
+
+// gitStore keeps the latest version as <dir>/<device>.<ext> and commits
+// every change, the versions are the commits of the file.
+type gitStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+func (s *gitStore) Save(device, ext string, data []byte, t time.Time) (Version, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.init(); err != nil {
+		return Version{}, false, err
+	}
+
+	name := device + "." + ext
+	path := filepath.Join(s.dir, name)
+	if prev, err := os.ReadFile(path); err == nil && bytes.Equal(prev, data) {
+		versions, err := s.Versions(device)
+		if err == nil {
+			return versions[len(versions)-1], false, nil
+		}
+	}
+
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return Version{}, false, err
+	}
+	if _, err := s.git("add", "--", name); err != nil {
+		return Version{}, false, err
+	}
+
+	date := t.UTC().Format(time.RFC3339)
+	if _, err := s.git("commit", "--quiet", "--date", date, "-m", device+": config backup", "--", name); err != nil {
+		return Version{}, false, err
+	}
+
+	id, err := s.git("rev-parse", "--short", "HEAD")
+	if err != nil {
+		return Version{}, false, err
+	}
+	return Version{ID: strings.TrimSpace(id), Time: t.UTC().Truncate(time.Second)}, true, nil
+}
+
+func (s *gitStore) Versions(device string) ([]Version, error) {
+	name, err := s.file(device)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := s.git("log", "--reverse", "--format=%h %ad", "--date=iso-strict", "--", name)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []Version
+	for _, line := range strings.Split(out, "\n") {
+		id, date, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, date)
+		if err != nil {
+			return nil, fmt.Errorf("invalid commit date %q: %w", date, err)
+		}
+		versions = append(versions, Version{ID: id, Time: t.UTC()})
+	}
+	if len(versions) == 0 {
+		return nil, ErrNoVersions
+	}
+	return versions, nil
+}
+
+func (s *gitStore) Read(device, id string) ([]byte, error) {
+	name, err := s.file(device)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := s.git("show", id+":"+name)
+	if err != nil {
+		return nil, fmt.Errorf("version %q of %q not found: %w", id, device, err)
+	}
+	return []byte(out), nil
+}
+
+// file returns the name of the backup file of the device. Tags may contain
+// dots, so only the known extensions are tried.
+func (s *gitStore) file(device string) (string, error) {
+	for _, ext := range fileExts {
+		name := device + "." + ext
+		_, err := os.Stat(filepath.Join(s.dir, name))
+		if err == nil {
+			return name, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+	}
+	return "", ErrNoVersions
+}
+
+func (s *gitStore) init() error {
+	if _, err := os.Stat(filepath.Join(s.dir, ".git")); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return err
+	}
+	_, err := s.git("init", "--quiet")
+	return err
+}
+
+func (s *gitStore) git(args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Dir = s.dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME="+gitUser, "GIT_AUTHOR_EMAIL="+gitEmail,
+		"GIT_COMMITTER_NAME="+gitUser, "GIT_COMMITTER_EMAIL="+gitEmail,
+	)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
@@ -0,0 +1,80 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+type ACMEOptions struct {
+	Domains      []string
+	Email        string
+	DirectoryURL string
+	CacheDir     string
+	// CACertFile is an additional root CA for the ACME directory,
+	// e.g. the Pebble test server certificate.
+	CACertFile string
+}
+
+// NewACMEManager returns a certificate manager that obtains and renews
+// certificates for the configured domains. TLS-ALPN-01 is always available,
+// HTTP-01 is enabled once the manager's HTTPHandler is served on port 80.
+func NewACMEManager(opts ACMEOptions) (*autocert.Manager, error) {
+	if len(opts.Domains) == 0 {
+		return nil, errors.New("acme requires at least one domain")
+	}
+	if opts.CacheDir == "" {
+		return nil, errors.New("acme requires cache_dir")
+	}
+
+	client := &acme.Client{DirectoryURL: opts.DirectoryURL}
+	if opts.CACertFile != "" {
+		httpClient, err := newHTTPClient(opts.CACertFile)
+		if err != nil {
+			return nil, err
+		}
+		client.HTTPClient = httpClient
+	}
+
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(opts.CacheDir),
+		HostPolicy: autocert.HostWhitelist(opts.Domains...),
+		Email:      opts.Email,
+		Client:     client,
+	}, nil
+}
+
+// ACMETLSConfig returns a TLS config serving certificates from the manager
+// and answering TLS-ALPN-01 challenges.
+func ACMETLSConfig(m *autocert.Manager) *tls.Config {
+	return &tls.Config{
+		GetCertificate: m.GetCertificate,
+		NextProtos:     []string{"h2", "http/1.1", acme.ALPNProto},
+	}
+}
+
+func newHTTPClient(caCertFile string) (*http.Client, error) {
+	pem, err := os.ReadFile(caCertFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read acme ca certificate: %w", err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", caCertFile)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	return &http.Client{Transport: transport}, nil
+}
@@ -0,0 +1,180 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const reloadInterval = 10 * time.Second
+
+var versions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+type Pair struct {
+	CertFile string
+	KeyFile  string
+}
+
+// Store holds certificates loaded from disk, selects them by SNI and reloads
+// them when the files change.
+type Store struct {
+	pairs []Pair
+
+	mu       sync.RWMutex
+	certs    []*tls.Certificate
+	modTimes []time.Time
+}
+
+func NewStore(pairs []Pair) (*Store, error) {
+	if len(pairs) == 0 {
+		return nil, errors.New("no certificates configured")
+	}
+
+	s := &Store{pairs: pairs}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate. The first certificate
+// is used when none of them matches the client hello.
+func (s *Store) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, cert := range s.certs {
+		if hello.SupportsCertificate(cert) == nil {
+			return cert, nil
+		}
+	}
+
+	return s.certs[0], nil
+}
+
+// Reload loads all certificates from disk. On error, the previously loaded
+// certificates are kept.
+func (s *Store) Reload() error {
+	certs := make([]*tls.Certificate, len(s.pairs))
+	modTimes := make([]time.Time, len(s.pairs))
+
+	for i, p := range s.pairs {
+		cert, err := tls.LoadX509KeyPair(p.CertFile, p.KeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load certificate %s: %w", p.CertFile, err)
+		}
+
+		modTimes[i], err = pairModTime(p)
+		if err != nil {
+			return err
+		}
+		certs[i] = &cert
+	}
+
+	s.mu.Lock()
+	s.certs, s.modTimes = certs, modTimes
+	s.mu.Unlock()
+	return nil
+}
+
+// Watch reloads the certificates when any of the files changes until the
+// context is canceled.
+func (s *Store) Watch(ctx context.Context) {
+	ticker := time.NewTicker(reloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !s.changed() {
+				continue
+			}
+
+			if err := s.Reload(); err != nil {
+				log.Error().Err(err).Msg("failed to reload certificates")
+				continue
+			}
+			log.Info().Msg("certificates reloaded")
+		}
+	}
+}
+
+func (s *Store) changed() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for i, p := range s.pairs {
+		modTime, err := pairModTime(p)
+		if err != nil || !modTime.Equal(s.modTimes[i]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func pairModTime(p Pair) (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{p.CertFile, p.KeyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+func ParseVersion(version string) (uint16, error) {
+	if version == "" {
+		return tls.VersionTLS12, nil
+	}
+
+	v, ok := versions[strings.TrimPrefix(version, "TLS")]
+	if !ok {
+		return 0, fmt.Errorf("unsupported tls version: %s", version)
+	}
+
+	return v, nil
+}
+
+// ParseCipherSuites converts cipher suite names (e.g. TLS_AES_128_GCM_SHA256)
+// to their IDs. Insecure cipher suites are rejected.
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	known := make(map[string]uint16)
+	for _, cs := range tls.CipherSuites() {
+		known[cs.Name] = cs.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported cipher suite: %s", name)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,217 @@
+package certs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeCert(t *testing.T, dir, name string, hosts ...string) Pair {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: hosts[0]},
+		DNSNames:     hosts,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	p := Pair{
+		CertFile: filepath.Join(dir, name+".crt"),
+		KeyFile:  filepath.Join(dir, name+".key"),
+	}
+	require.NoError(t, os.WriteFile(p.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(p.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600))
+	return p
+}
+
+func leafName(t *testing.T, cert *tls.Certificate) string {
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	return leaf.Subject.CommonName
+}
+
+func hello(name string) *tls.ClientHelloInfo {
+	return &tls.ClientHelloInfo{
+		ServerName:        name,
+		SupportedVersions: []uint16{tls.VersionTLS13},
+		SignatureSchemes:  []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+		SupportedCurves:   []tls.CurveID{tls.CurveP256},
+		CipherSuites:      []uint16{tls.TLS_AES_128_GCM_SHA256},
+	}
+}
+
+func TestStore(t *testing.T) {
+	dir := t.TempDir()
+	home := writeCert(t, dir, "home", "home.example.com")
+	wildcard := writeCert(t, dir, "wildcard", "*.routers.example.com")
+
+	store, err := NewStore([]Pair{home, wildcard})
+	require.NoError(t, err)
+
+	t.Run("SNI", func(t *testing.T) {
+		cert, err := store.GetCertificate(hello("office.routers.example.com"))
+		require.NoError(t, err)
+		assert.Equal(t, "*.routers.example.com", leafName(t, cert))
+	})
+
+	t.Run("Fallback to first certificate", func(t *testing.T) {
+		cert, err := store.GetCertificate(hello("unknown.example.com"))
+		require.NoError(t, err)
+		assert.Equal(t, "home.example.com", leafName(t, cert))
+	})
+
+	t.Run("Reload on change", func(t *testing.T) {
+		assert.False(t, store.changed())
+
+		writeCert(t, dir, "home", "renewed.example.com")
+		future := time.Now().Add(time.Minute)
+		require.NoError(t, os.Chtimes(home.CertFile, future, future))
+
+		assert.True(t, store.changed())
+		require.NoError(t, store.Reload())
+
+		cert, err := store.GetCertificate(hello("renewed.example.com"))
+		require.NoError(t, err)
+		assert.Equal(t, "renewed.example.com", leafName(t, cert))
+	})
+
+	t.Run("Failed reload keeps certificates", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(home.KeyFile, []byte("broken"), 0o600))
+		assert.Error(t, store.Reload())
+
+		cert, err := store.GetCertificate(hello("renewed.example.com"))
+		require.NoError(t, err)
+		assert.Equal(t, "renewed.example.com", leafName(t, cert))
+	})
+}
+
+func TestParse(t *testing.T) {
+	v, err := ParseVersion("1.3")
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), v)
+
+	v, err = ParseVersion("")
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS12), v)
+
+	_, err = ParseVersion("1.4")
+	assert.Error(t, err)
+
+	ids, err := ParseCipherSuites([]string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"})
+	assert.NoError(t, err)
+	assert.Equal(t, []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}, ids)
+
+	_, err = ParseCipherSuites([]string{"TLS_RSA_WITH_RC4_128_SHA"})
+	assert.Error(t, err)
+}
+
+func TestNewACMEManager(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Run("Missing domains", func(t *testing.T) {
+		_, err := NewACMEManager(ACMEOptions{CacheDir: dir})
+		assert.Error(t, err)
+	})
+
+	t.Run("Missing cache dir", func(t *testing.T) {
+		_, err := NewACMEManager(ACMEOptions{Domains: []string{"router.example.com"}})
+		assert.Error(t, err)
+	})
+
+	t.Run("Custom directory with CA", func(t *testing.T) {
+		ca := writeCert(t, dir, "pebble", "pebble")
+
+		m, err := NewACMEManager(ACMEOptions{
+			Domains:      []string{"router.example.com"},
+			DirectoryURL: "https://localhost:14000/dir",
+			CacheDir:     dir,
+			CACertFile:   ca.CertFile,
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, "https://localhost:14000/dir", m.Client.DirectoryURL)
+		assert.NotNil(t, m.Client.HTTPClient)
+		assert.NoError(t, m.HostPolicy(context.Background(), "router.example.com"))
+		assert.Error(t, m.HostPolicy(context.Background(), "other.example.com"))
+	})
+
+	t.Run("Invalid CA", func(t *testing.T) {
+		_, err := NewACMEManager(ACMEOptions{
+			Domains:    []string{"router.example.com"},
+			CacheDir:   dir,
+			CACertFile: filepath.Join(dir, "pebble.key"),
+		})
+		assert.Error(t, err)
+	})
+}
+
+// TestACMEIssuance obtains a certificate from a Pebble test server, e.g. with
+// ACME_TEST_DIRECTORY=https://localhost:14000/dir and ACME_TEST_CA_CERT set
+// to pebble.minica.pem. Challenges are answered on the ports Pebble validates,
+// 5002 for HTTP-01 and 5001 for TLS-ALPN-01, so ACME_TEST_DOMAIN must resolve
+// to this host for Pebble (e.g. pebble-challtestsrv -defaultIPv4 127.0.0.1),
+// unless it runs with PEBBLE_VA_ALWAYS_VALID=1.
+func TestACMEIssuance(t *testing.T) {
+	directory := os.Getenv("ACME_TEST_DIRECTORY")
+	if directory == "" {
+		t.Skip("ACME_TEST_DIRECTORY is not set")
+	}
+	domain := os.Getenv("ACME_TEST_DOMAIN")
+	if domain == "" {
+		domain = "router.example.com"
+	}
+
+	dir := t.TempDir()
+	m, err := NewACMEManager(ACMEOptions{
+		Domains:      []string{domain},
+		DirectoryURL: directory,
+		CacheDir:     dir,
+		CACertFile:   os.Getenv("ACME_TEST_CA_CERT"),
+	})
+	require.NoError(t, err)
+
+	httpServer := &http.Server{Addr: ":5002", Handler: m.HTTPHandler(nil)}
+	go httpServer.ListenAndServe()
+	defer httpServer.Close()
+
+	tlsListener, err := tls.Listen("tcp", ":5001", ACMETLSConfig(m))
+	require.NoError(t, err)
+	tlsServer := &http.Server{Handler: http.NotFoundHandler()}
+	go tlsServer.Serve(tlsListener)
+	defer tlsServer.Close()
+
+	cert, err := m.GetCertificate(hello(domain))
+	require.NoError(t, err)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	require.NoError(t, err)
+	assert.Equal(t, []string{domain}, leaf.DNSNames)
+
+	cached, err := m.Cache.Get(context.Background(), domain)
+	require.NoError(t, err)
+	assert.NotEmpty(t, cached)
+
+	_, err = m.GetCertificate(hello("other.example.com"))
+	assert.Error(t, err)
+}
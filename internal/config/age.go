@@ -0,0 +1,165 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"gopkg.in/yaml.v3"
+)
+
+const ageTag = "!age"
+
+// AgeIdentities loads age identities from the key file or, if it is empty,
+// from the AGE_KEY environment variable. No identities and no error are
+// returned when neither is set.
+func AgeIdentities(keyFile string) ([]age.Identity, error) {
+	var r io.Reader
+	switch {
+	case keyFile != "":
+		f, err := os.Open(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open age key file: %w", err)
+		}
+		defer f.Close()
+		r = f
+	case os.Getenv("AGE_KEY") != "":
+		r = strings.NewReader(os.Getenv("AGE_KEY"))
+	default:
+		return nil, nil
+	}
+
+	ids, err := age.ParseIdentities(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse age key: %w", err)
+	}
+
+	return ids, nil
+}
+
+// AgeRecipients parses recipients given as public keys and recipient files.
+func AgeRecipients(keys []string, files []string) ([]age.Recipient, error) {
+	var recipients []age.Recipient
+	for _, k := range keys {
+		r, err := age.ParseX25519Recipient(k)
+		if err != nil {
+			return nil, fmt.Errorf("invalid recipient %q: %w", k, err)
+		}
+		recipients = append(recipients, r)
+	}
+
+	for _, path := range files {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open recipients file: %w", err)
+		}
+		rs, err := age.ParseRecipients(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse recipients file %s: %w", path, err)
+		}
+		recipients = append(recipients, rs...)
+	}
+
+	if len(recipients) == 0 {
+		return nil, errors.New("no recipients specified")
+	}
+
+	return recipients, nil
+}
+
+// EncryptValue encrypts the value and returns it as a YAML "!age" scalar.
+func EncryptValue(value string, recipients []age.Recipient) (string, error) {
+	var buf bytes.Buffer
+	aw := armor.NewWriter(&buf)
+
+	w, err := age.Encrypt(aw, recipients...)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.WriteString(w, value); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	if err := aw.Close(); err != nil {
+		return "", err
+	}
+
+	node := &yaml.Node{Kind: yaml.ScalarNode, Tag: ageTag, Style: yaml.LiteralStyle, Value: buf.String()}
+	out, err := yaml.Marshal(node)
+	if err != nil {
+		return "", err
+	}
+
+	return string(out), nil
+}
+
+// DecryptFile returns the configuration file with all "!age" values
+// decrypted. Comments are kept and the output is indented by 2 spaces, a file
+// in that style changes only in the decrypted values.
+func DecryptFile(filePath string, ids []age.Identity) ([]byte, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal YAML: %w", err)
+	}
+
+	if err := decryptAge(&node, ids); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&node); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// decryptAge replaces "!age" scalars with their plaintext. Errors contain
+// the position of the value, never the value itself.
+func decryptAge(n *yaml.Node, ids []age.Identity) error {
+	if n.Kind == yaml.ScalarNode && n.Tag == ageTag {
+		if len(ids) == 0 {
+			return fmt.Errorf("line %d: encrypted value found, but no age key is configured", n.Line)
+		}
+
+		r, err := age.Decrypt(armor.NewReader(strings.NewReader(strings.TrimSpace(n.Value))), ids...)
+		if err != nil {
+			return fmt.Errorf("line %d: failed to decrypt value: %w", n.Line, err)
+		}
+
+		plaintext, err := io.ReadAll(r)
+		if err != nil {
+			return fmt.Errorf("line %d: failed to decrypt value: %w", n.Line, err)
+		}
+
+		n.Value = string(plaintext)
+		n.Tag = "!!str"
+		n.Style = 0
+	}
+
+	for _, c := range n.Content {
+		if err := decryptAge(c, ids); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -3,24 +3,235 @@ package config
 import (
 	"bytes"
 	"fmt"
-	"gopkg.in/yaml.v3"
 	"io"
 	"os"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	Entrypoints []EntrypointConfig `yaml:"entrypoints"`
-	Devices     []DeviceConfig     `yaml:"devices"`
+	Entrypoints       []EntrypointConfig        `yaml:"entrypoints"`
+	Devices           []DeviceConfig            `yaml:"devices"`
+	Shutdown          ShutdownConfig            `yaml:"shutdown,omitempty"`
+	WOLHosts          map[string]WOLHostConfig  `yaml:"wol_hosts,omitempty"`
+	Actions           map[string]ActionConfig   `yaml:"actions,omitempty"`
+	Schedules         map[string]ScheduleConfig `yaml:"schedules,omitempty"`
+	ScheduleStateFile string                    `yaml:"schedule_state_file,omitempty"`
+	Backups           BackupConfig              `yaml:"backups,omitempty"`
+	Drift             DriftConfig               `yaml:"drift,omitempty"`
+	Presence          PresenceConfig            `yaml:"presence,omitempty"`
+	Webhooks          WebhooksConfig            `yaml:"webhooks,omitempty"`
+	MQTT              MQTTConfig                `yaml:"mqtt,omitempty"`
+	Metrics           MetricsConfig             `yaml:"metrics,omitempty"`
+	Telemetry         TelemetryConfig           `yaml:"telemetry,omitempty"`
+}
+
+// TelemetryConfig enables periodic scraping of the CPU, memory, uptime,
+// traffic, Wi-Fi clients and WAN state of the devices, exposed with the
+// gateway metrics.
+type TelemetryConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Interval time.Duration `yaml:"interval,omitempty"`
+	Devices  []string      `yaml:"devices,omitempty"`
+}
+
+// MetricsConfig serves Prometheus metrics on a separate listener, without
+// authentication.
+type MetricsConfig struct {
+	Listen string `yaml:"listen"`
+	Path   string `yaml:"path,omitempty"`
+}
+
+// MQTTConfig connects to an MQTT broker. The status of the devices is
+// published to retained topics under topic_prefix, the listed actions are run
+// by messages on their command topics. With discovery, Home Assistant
+// discovery payloads are published under discovery_prefix.
+type MQTTConfig struct {
+	Broker          string        `yaml:"broker"`
+	ClientID        string        `yaml:"client_id,omitempty"`
+	Username        string        `yaml:"username,omitempty"`
+	Password        string        `yaml:"password,omitempty"`
+	TopicPrefix     string        `yaml:"topic_prefix,omitempty"`
+	Interval        time.Duration `yaml:"interval,omitempty"`
+	Devices         []string      `yaml:"devices,omitempty"`
+	Actions         []string      `yaml:"actions,omitempty"`
+	Discovery       bool          `yaml:"discovery,omitempty"`
+	DiscoveryPrefix string        `yaml:"discovery_prefix,omitempty"`
+}
+
+// WebhooksConfig posts gateway events to the endpoints. Payloads are signed
+// with HMAC-SHA256 of the endpoint secret, failed deliveries are retried with
+// backoff and, with queue_dir, kept on disk across restarts.
+type WebhooksConfig struct {
+	Endpoints   []WebhookConfig `yaml:"endpoints"`
+	QueueDir    string          `yaml:"queue_dir,omitempty"`
+	MaxAttempts int             `yaml:"max_attempts,omitempty"`
+}
+
+// WebhookConfig is an endpoint receiving the listed events, all if empty.
+type WebhookConfig struct {
+	URL    string   `yaml:"url"`
+	Secret string   `yaml:"secret,omitempty"`
+	Events []string `yaml:"events,omitempty"`
+}
+
+// PresenceConfig enables polling of the LAN hosts of the devices, hosts
+// coming online or going offline are logged, emitted as webhook events and
+// streamed by GET /_gw/events. WebhookURL is deprecated in favour of the
+// webhooks endpoints.
+type PresenceConfig struct {
+	Enabled    bool          `yaml:"enabled"`
+	Interval   time.Duration `yaml:"interval,omitempty"`
+	Devices    []string      `yaml:"devices,omitempty"`
+	WebhookURL string        `yaml:"webhook_url,omitempty"`
+}
+
+// DriftConfig enables periodic comparison of the device configurations with
+// the golden snapshots in dir, which are pinned with "drift pin". Include and
+// exclude are globs of section names and fields, e.g. "interface *".
+// Events carry the changed lines only with ReportLines, as they may hold
+// secrets. WebhookURL is deprecated in favour of the webhooks endpoints.
+type DriftConfig struct {
+	Dir         string        `yaml:"dir"`
+	Interval    time.Duration `yaml:"interval,omitempty"`
+	Devices     []string      `yaml:"devices,omitempty"`
+	Include     []string      `yaml:"include,omitempty"`
+	Exclude     []string      `yaml:"exclude,omitempty"`
+	ReportLines bool          `yaml:"report_lines,omitempty"`
+	WebhookURL  string        `yaml:"webhook_url,omitempty"`
+}
+
+// BackupConfig enables periodic backups of the device configurations to
+// dir. With git the directory is a git repository and every new version is
+// a commit, otherwise versions are kept as timestamped files.
+type BackupConfig struct {
+	Dir      string        `yaml:"dir"`
+	Git      bool          `yaml:"git,omitempty"`
+	Interval time.Duration `yaml:"interval,omitempty"`
+	Devices  []string      `yaml:"devices,omitempty"`
+}
+
+// WOLHostConfig is a host that can be woken with POST /_gw/wol. Without
+// device_tag the host can be woken through any device, without users by
+// any user of the entrypoint.
+type WOLHostConfig struct {
+	MAC       string   `yaml:"mac"`
+	DeviceTag string   `yaml:"device_tag,omitempty"`
+	Users     []string `yaml:"users,omitempty"`
+}
+
+// ActionConfig is a templated device request run with POST /_gw/actions/<name>.
+// Params are substituted in the path and body as {{ .name }}. MQTT commands
+// have no user, AllowMQTT lets the bridge run an action restricted to users.
+type ActionConfig struct {
+	DeviceTag string                       `yaml:"device_tag"`
+	User      string                       `yaml:"user,omitempty"`
+	Method    string                       `yaml:"method,omitempty"`
+	Path      string                       `yaml:"path"`
+	Body      string                       `yaml:"body,omitempty"`
+	Params    map[string]ActionParamConfig `yaml:"params,omitempty"`
+	Users     []string                     `yaml:"users,omitempty"`
+	AllowMQTT bool                         `yaml:"allow_mqtt,omitempty"`
+}
+
+// ScheduleConfig runs a named action or a device request on a cron schedule.
+// Jitter delays every run by a random duration up to its value. MissedRun is
+// "skip" (default) or "run_once" to run once if runs were missed while the
+// gateway was down, which is detected with schedule_state_file.
+type ScheduleConfig struct {
+	Cron      string            `yaml:"cron"`
+	Action    string            `yaml:"action,omitempty"`
+	Params    map[string]string `yaml:"params,omitempty"`
+	DeviceTag string            `yaml:"device_tag,omitempty"`
+	User      string            `yaml:"user,omitempty"`
+	Method    string            `yaml:"method,omitempty"`
+	Path      string            `yaml:"path,omitempty"`
+	Body      string            `yaml:"body,omitempty"`
+	Jitter    time.Duration     `yaml:"jitter,omitempty"`
+	MissedRun string            `yaml:"missed_run,omitempty"`
+}
+
+type ActionParamConfig struct {
+	Pattern  string   `yaml:"pattern,omitempty"`
+	Enum     []string `yaml:"enum,omitempty"`
+	Default  string   `yaml:"default,omitempty"`
+	Required bool     `yaml:"required,omitempty"`
+}
+
+type ShutdownConfig struct {
+	Timeout       time.Duration `yaml:"timeout,omitempty"`
+	LogoutDevices bool          `yaml:"logout_devices,omitempty"`
 }
 
 type EntrypointConfig struct {
-	Listen              string            `yaml:"listen"`
+	Listen      string `yaml:"listen"`
+	RouteConfig `yaml:",inline"`
+	Hosts       []HostConfig `yaml:"hosts,omitempty"`
+	TLS         TLSConfig    `yaml:"tls,omitempty"`
+}
+
+type TLSConfig struct {
+	CertFile     string              `yaml:"cert_file,omitempty"`
+	KeyFile      string              `yaml:"key_file,omitempty"`
+	Certificates []CertificateConfig `yaml:"certificates,omitempty"`
+	MinVersion   string              `yaml:"min_version,omitempty"`
+	CipherSuites []string            `yaml:"cipher_suites,omitempty"`
+	ACME         ACMEConfig          `yaml:"acme,omitempty"`
+}
+
+type ACMEConfig struct {
+	Domains      []string `yaml:"domains"`
+	Email        string   `yaml:"email,omitempty"`
+	DirectoryURL string   `yaml:"directory_url,omitempty"`
+	CACertFile   string   `yaml:"ca_cert_file,omitempty"`
+	CacheDir     string   `yaml:"cache_dir"`
+	HTTPListen   string   `yaml:"http_listen,omitempty"`
+}
+
+type CertificateConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+}
+
+// HostConfig routes requests with a matching Host header. A wildcard host
+// without device_tag and mounts selects one of devices by the subdomain label.
+type HostConfig struct {
+	Host        string `yaml:"host"`
+	RouteConfig `yaml:",inline"`
+	Devices     []string `yaml:"devices,omitempty"`
+}
+
+// BySubdomain reports whether the host selects the device by the subdomain.
+func (hc HostConfig) BySubdomain() bool {
+	return hc.DeviceTag == "" && len(hc.Mounts) == 0 && strings.HasPrefix(hc.Host, "*.")
+}
+
+type RouteConfig struct {
 	DeviceTag           string            `yaml:"device_tag"`
 	ReadOnly            bool              `yaml:"read_only,omitempty"`
 	ForwardAuth         ForwardAuthConfig `yaml:"forward_auth,omitempty"`
 	BasicAuth           []BasicAuthConfig `yaml:"basic_auth,omitempty"`
 	AllowedEndpoints    []string          `yaml:"allowed_endpoints"`
 	BypassAuthEndpoints []string          `yaml:"bypass_auth_endpoints"`
+	BypassUser          string            `yaml:"bypass_user,omitempty"`
+	DefaultUser         string            `yaml:"default_user,omitempty"`
+	RCIPolicy           RCIPolicyConfig   `yaml:"rci_policy,omitempty"`
+	Mounts              []MountConfig     `yaml:"mounts,omitempty"`
+	API                 bool              `yaml:"api,omitempty"`
+	WOL                 bool              `yaml:"wol,omitempty"`
+	Actions             []string          `yaml:"actions,omitempty"`
+	Schedules           bool              `yaml:"schedules,omitempty"`
+	Events              bool              `yaml:"events,omitempty"`
+}
+
+type MountConfig struct {
+	Prefix      string `yaml:"prefix"`
+	DeviceTag   string `yaml:"device_tag"`
+	BypassUser  string `yaml:"bypass_user,omitempty"`
+	DefaultUser string `yaml:"default_user,omitempty"`
 }
 
 type DeviceConfig struct {
@@ -32,13 +243,20 @@ type DeviceConfig struct {
 }
 
 type UserConfig struct {
-	Username string `yaml:"username"`
-	Password string `yaml:"password"`
+	Username     string `yaml:"username"`
+	Password     string `yaml:"password"`
+	PasswordFile string `yaml:"password_file,omitempty"`
 }
 
 type BasicAuthConfig struct {
-	Username string `yaml:"username"`
-	Password string `yaml:"password"`
+	Username     string `yaml:"username"`
+	Password     string `yaml:"password"`
+	PasswordFile string `yaml:"password_file,omitempty"`
+}
+
+type RCIPolicyConfig struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
 }
 
 type ForwardAuthConfig struct {
@@ -46,15 +264,66 @@ type ForwardAuthConfig struct {
 	Mapping map[string]string `yaml:"mapping"`
 }
 
-func (ec EntrypointConfig) BasicAuthMap() map[string]string {
+func (rc RouteConfig) BasicAuthMap() map[string]string {
 	basicAuthMap := make(map[string]string)
-	for _, e := range ec.BasicAuth {
+	for _, e := range rc.BasicAuth {
 		basicAuthMap[e.Username] = e.Password
 	}
 	return basicAuthMap
 }
 
-func LoadConfig(filePath string) (*Config, error) {
+func (tc TLSConfig) Enabled() bool {
+	return tc.CertFile != "" || len(tc.Certificates) > 0 || tc.ACME.Enabled()
+}
+
+func (bc BackupConfig) Enabled() bool {
+	return bc.Dir != ""
+}
+
+func (dc DriftConfig) Enabled() bool {
+	return dc.Dir != ""
+}
+
+func (mc MQTTConfig) Enabled() bool {
+	return mc.Broker != ""
+}
+
+func (mc MetricsConfig) Enabled() bool {
+	return mc.Listen != ""
+}
+
+func (ac ACMEConfig) Enabled() bool {
+	return len(ac.Domains) > 0
+}
+
+// AllCertificates returns cert_file/key_file followed by the certificates list.
+func (tc TLSConfig) AllCertificates() []CertificateConfig {
+	var res []CertificateConfig
+	if tc.CertFile != "" {
+		res = append(res, CertificateConfig{CertFile: tc.CertFile, KeyFile: tc.KeyFile})
+	}
+	return append(res, tc.Certificates...)
+}
+
+type LoadOption func(*loadOptions)
+
+type loadOptions struct {
+	ageIdentities []age.Identity
+}
+
+// WithAgeIdentities sets the identities used to decrypt "!age" values.
+func WithAgeIdentities(ids []age.Identity) LoadOption {
+	return func(o *loadOptions) {
+		o.ageIdentities = ids
+	}
+}
+
+func LoadConfig(filePath string, opts ...LoadOption) (*Config, error) {
+	var o loadOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	file, err := os.Open(filePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open config file: %w", err)
@@ -66,6 +335,29 @@ func LoadConfig(filePath string) (*Config, error) {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
 
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal YAML: %w", err)
+	}
+	if node.Kind == 0 {
+		return nil, fmt.Errorf("failed to unmarshal YAML: %w", io.EOF)
+	}
+
+	if err := expandEnv(&node); err != nil {
+		return nil, fmt.Errorf("failed to expand config: %w", err)
+	}
+
+	if err := decryptAge(&node, o.ageIdentities); err != nil {
+		return nil, fmt.Errorf("failed to decrypt config: %w", err)
+	}
+
+	// Decoding is done from the expanded document, since yaml.Node.Decode
+	// doesn't support strict field checking.
+	data, err = yaml.Marshal(&node)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal expanded YAML: %w", err)
+	}
+
 	var config Config
 	decoder := yaml.NewDecoder(bytes.NewReader(data))
 	decoder.KnownFields(true)
@@ -74,5 +366,9 @@ func LoadConfig(filePath string) (*Config, error) {
 		return nil, fmt.Errorf("failed to unmarshal YAML: %w", err)
 	}
 
+	if err := resolveSecrets(&config); err != nil {
+		return nil, err
+	}
+
 	return &config, nil
 }
@@ -2,8 +2,12 @@ package config_test
 
 import (
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"filippo.io/age"
 	"github.com/mazzz1y/router-auth-gw/internal/config"
 
 	"github.com/stretchr/testify/assert"
@@ -40,19 +44,21 @@ func TestLoadConfig_Success(t *testing.T) {
 
 	expected := &config.Config{
 		Entrypoints: []config.EntrypointConfig{{
-			Listen:    "localhost:8080",
-			DeviceTag: "device123",
-			BasicAuth: []config.BasicAuthConfig{
-				{
-					Username: "xxx",
-					Password: "xxx",
+			Listen: "localhost:8080",
+			RouteConfig: config.RouteConfig{
+				DeviceTag: "device123",
+				BasicAuth: []config.BasicAuthConfig{
+					{
+						Username: "xxx",
+						Password: "xxx",
+					},
 				},
-			},
-			AllowedEndpoints: []string{"/status", "/health"},
-			ForwardAuth: config.ForwardAuthConfig{
-				Header: "X-Forwared-User",
-				Mapping: map[string]string{
-					"user1": "user2",
+				AllowedEndpoints: []string{"/status", "/health"},
+				ForwardAuth: config.ForwardAuthConfig{
+					Header: "X-Forwared-User",
+					Mapping: map[string]string{
+						"user1": "user2",
+					},
 				},
 			},
 		}},
@@ -103,3 +109,488 @@ func writeTempFile(content string) (string, error) {
 
 	return tmpFile.Name(), nil
 }
+
+func TestLoadConfig_Secrets(t *testing.T) {
+	secretsDir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(secretsDir, "router_admin"), []byte("file-secret\n"), 0o600))
+	assert.NoError(t, os.WriteFile(filepath.Join(secretsDir, "basic"), []byte("basic-secret"), 0o600))
+
+	t.Setenv("SECRETS_DIR", secretsDir)
+	t.Setenv("ROUTER_URL", "http://192.168.1.1")
+	t.Setenv("READ_ONLY", "true")
+	t.Setenv("ADMIN_PASSWORD", "env-secret")
+	t.Setenv("TILDE_PASSWORD", "~")
+	t.Setenv("YES_PASSWORD", "yes")
+
+	content := `
+entrypoints:
+  - listen: "${LISTEN:-localhost:8080}"
+    device_tag: "device123"
+    read_only: ${READ_ONLY}
+    basic_auth:
+      - username: user
+        password_file: ` + filepath.Join(secretsDir, "basic") + `
+      - username: tilde
+        password: ${TILDE_PASSWORD}
+      - username: "yes"
+        password: ${YES_PASSWORD}
+    allowed_endpoints: ["/literal/$${NOT_EXPANDED}"]
+devices:
+  - tag: "device123"
+    url: ${ROUTER_URL}
+    type: "keenetic"
+    users:
+      - username: admin
+        password: ${ADMIN_PASSWORD}
+      - username: user
+        password_file: router_admin
+`
+	filePath, err := writeTempFile(content)
+	assert.NoError(t, err)
+	defer os.Remove(filePath)
+
+	cfg, err := config.LoadConfig(filePath)
+	assert.NoError(t, err)
+
+	e := cfg.Entrypoints[0]
+	assert.Equal(t, "localhost:8080", e.Listen)
+	assert.True(t, e.ReadOnly)
+	assert.Equal(t, "basic-secret", e.BasicAuth[0].Password)
+	assert.Equal(t, "~", e.BasicAuth[1].Password, "substituted values aren't resolved as null")
+	assert.Equal(t, "yes", e.BasicAuth[2].Password)
+	assert.Equal(t, []string{"/literal/${NOT_EXPANDED}"}, e.AllowedEndpoints)
+
+	d := cfg.Devices[0]
+	assert.Equal(t, "http://192.168.1.1", d.URL)
+	assert.Equal(t, "env-secret", d.Users[0].Password)
+	assert.Equal(t, "file-secret", d.Users[1].Password)
+}
+
+func TestLoadConfig_SecretErrors(t *testing.T) {
+	t.Setenv("SECRETS_DIR", t.TempDir())
+	t.Setenv("ADMIN_PASSWORD", "env-secret")
+
+	tests := []struct {
+		name     string
+		user     string
+		expected string
+	}{
+		{
+			name:     "MissingEnv",
+			user:     "password: ${MISSING_PASSWORD}",
+			expected: "MISSING_PASSWORD is not set",
+		},
+		{
+			name:     "MissingFile",
+			user:     "password_file: missing_secret",
+			expected: "missing_secret",
+		},
+		{
+			name:     "PasswordAndFile",
+			user:     "password: ${ADMIN_PASSWORD}\n        password_file: admin",
+			expected: "mutually exclusive",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			content := "devices:\n  - tag: device\n    users:\n      - username: admin\n        " + test.user + "\n"
+
+			filePath, err := writeTempFile(content)
+			assert.NoError(t, err)
+			defer os.Remove(filePath)
+
+			_, err = config.LoadConfig(filePath)
+			assert.ErrorContains(t, err, test.expected)
+			assert.NotContains(t, err.Error(), "env-secret")
+		})
+	}
+}
+
+func TestLoadConfig_Age(t *testing.T) {
+	id, err := age.GenerateX25519Identity()
+	assert.NoError(t, err)
+
+	encrypted, err := config.EncryptValue("age-secret", []age.Recipient{id.Recipient()})
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(encrypted, "!age |"))
+
+	lines := strings.Split(strings.TrimSpace(encrypted), "\n")
+	indented := lines[0] + "\n          " + strings.Join(lines[1:], "\n          ")
+	content := `
+devices:
+  - tag: device
+    users:
+      - username: admin
+        password: ` + indented + "\n"
+
+	filePath, err := writeTempFile(content)
+	assert.NoError(t, err)
+	defer os.Remove(filePath)
+
+	t.Run("Decrypted", func(t *testing.T) {
+		cfg, err := config.LoadConfig(filePath, config.WithAgeIdentities([]age.Identity{id}))
+		assert.NoError(t, err)
+		assert.Equal(t, "age-secret", cfg.Devices[0].Users[0].Password)
+	})
+
+	t.Run("NoKey", func(t *testing.T) {
+		_, err := config.LoadConfig(filePath)
+		assert.ErrorContains(t, err, "no age key")
+	})
+
+	t.Run("WrongKey", func(t *testing.T) {
+		other, err := age.GenerateX25519Identity()
+		assert.NoError(t, err)
+
+		_, err = config.LoadConfig(filePath, config.WithAgeIdentities([]age.Identity{other}))
+		assert.ErrorContains(t, err, "failed to decrypt value")
+	})
+
+	t.Run("DecryptFile", func(t *testing.T) {
+		data, err := config.DecryptFile(filePath, []age.Identity{id})
+		assert.NoError(t, err)
+		assert.Contains(t, string(data), "password: age-secret")
+		assert.NotContains(t, string(data), "!age")
+	})
+
+	t.Run("DecryptFile keeps the formatting", func(t *testing.T) {
+		content := `# Gateway configuration.
+entrypoints:
+  - listen: "127.0.0.1:8080" # Local only
+    device_tag: device
+    allowed_endpoints: [/rci/show/version]
+devices:
+  - tag: device
+    url: http://192.168.1.1
+    users:
+      - username: admin
+        password: plain
+`
+		filePath, err := writeTempFile(content)
+		assert.NoError(t, err)
+		defer os.Remove(filePath)
+
+		data, err := config.DecryptFile(filePath, []age.Identity{id})
+		assert.NoError(t, err)
+		assert.Equal(t, content, string(data))
+	})
+
+	t.Run("IdentitiesFromEnv", func(t *testing.T) {
+		t.Setenv("AGE_KEY", id.String())
+
+		ids, err := config.AgeIdentities("")
+		assert.NoError(t, err)
+
+		cfg, err := config.LoadConfig(filePath, config.WithAgeIdentities(ids))
+		assert.NoError(t, err)
+		assert.Equal(t, "age-secret", cfg.Devices[0].Users[0].Password)
+	})
+}
+
+func TestValidate(t *testing.T) {
+	valid := func() *config.Config {
+		return &config.Config{
+			Entrypoints: []config.EntrypointConfig{{
+				Listen: "127.0.0.1:8080",
+				RouteConfig: config.RouteConfig{
+					DeviceTag: "keenetic",
+					ForwardAuth: config.ForwardAuthConfig{
+						Header:  "X-User",
+						Mapping: map[string]string{"alice": "admin"},
+					},
+				},
+			}},
+			Devices: []config.DeviceConfig{
+				{
+					Tag:   "keenetic",
+					Type:  "keenetic",
+					URL:   "http://192.168.1.1",
+					Users: []config.UserConfig{{Username: "admin", Password: "pass"}},
+				},
+				{
+					Tag:      "glinet",
+					Type:     "glinet",
+					URL:      "https://192.168.8.1",
+					ProxyUrl: "socks5://127.0.0.1:1080",
+					Users:    []config.UserConfig{{Username: "root", Password: "pass"}},
+				},
+			},
+		}
+	}
+
+	assert.Empty(t, config.Validate(valid()))
+
+	tests := []struct {
+		name   string
+		modify func(cfg *config.Config)
+		want   []config.Problem
+	}{
+		{
+			name: "Unknown device",
+			modify: func(cfg *config.Config) {
+				cfg.Entrypoints[0].Mounts = []config.MountConfig{{Prefix: "/gl", DeviceTag: "missing"}}
+			},
+			want: []config.Problem{{Path: "entrypoints[0].mounts[0].device_tag", Message: `device "missing" not found`}},
+		},
+		{
+			name: "Duplicate listen",
+			modify: func(cfg *config.Config) {
+				cfg.Entrypoints = append(cfg.Entrypoints, config.EntrypointConfig{
+					Listen:      "127.0.0.1:8080",
+					RouteConfig: config.RouteConfig{DeviceTag: "glinet"},
+				})
+			},
+			want: []config.Problem{{
+				Path:    "entrypoints[1].listen",
+				Message: `duplicate listen address "127.0.0.1:8080", already used by entrypoints[0]`,
+			}},
+		},
+		{
+			name: "Device without users",
+			modify: func(cfg *config.Config) {
+				cfg.Devices[1].Users = nil
+			},
+			want: []config.Problem{{Path: "devices[1].users", Message: "device has no users"}},
+		},
+		{
+			name: "Mapping to unknown user",
+			modify: func(cfg *config.Config) {
+				cfg.Entrypoints[0].ForwardAuth.Mapping["bob"] = "guest"
+			},
+			want: []config.Problem{{
+				Path:    "entrypoints[0].forward_auth.mapping.bob",
+				Message: `user "guest" not found for device "keenetic"`,
+			}},
+		},
+		{
+			name: "Unsupported proxy scheme",
+			modify: func(cfg *config.Config) {
+				cfg.Devices[1].ProxyUrl = "socks4://127.0.0.1:1080"
+			},
+			want: []config.Problem{{
+				Path:    "devices[1].proxy_url",
+				Message: `unsupported scheme "socks4", expected one of: http, https, socks5, socks5h`,
+			}},
+		},
+		{
+			name: "Bypass endpoint not allowed",
+			modify: func(cfg *config.Config) {
+				cfg.Entrypoints[0].BypassUser = "admin"
+				cfg.Entrypoints[0].AllowedEndpoints = []string{"/rci/show/version"}
+				cfg.Entrypoints[0].BypassAuthEndpoints = []string{"/rci/ip/hotspot/wake"}
+			},
+			want: []config.Problem{{
+				Path:    "entrypoints[0].bypass_auth_endpoints[0]",
+				Message: `endpoint "/rci/ip/hotspot/wake" is not in allowed_endpoints and will always be rejected`,
+			}},
+		},
+		{
+			name: "Basic auth with forward auth",
+			modify: func(cfg *config.Config) {
+				cfg.Entrypoints[0].BasicAuth = []config.BasicAuthConfig{{Username: "user", Password: "pass"}}
+			},
+			want: []config.Problem{{
+				Path:    "entrypoints[0]",
+				Message: "basic_auth and forward_auth can't be combined, basic auth is ignored when forward_auth is set",
+			}},
+		},
+		{
+			name: "Mount inherits users",
+			modify: func(cfg *config.Config) {
+				cfg.Entrypoints[0].DefaultUser = "admin"
+				cfg.Entrypoints[0].ForwardAuth = config.ForwardAuthConfig{}
+				cfg.Entrypoints[0].Mounts = []config.MountConfig{{Prefix: "/gl", DeviceTag: "glinet"}}
+			},
+			want: []config.Problem{{
+				Path:    "entrypoints[0].mounts[0].default_user",
+				Message: `user "admin" not found for device "glinet"`,
+			}},
+		},
+		{
+			name: "WOL hosts",
+			modify: func(cfg *config.Config) {
+				cfg.Entrypoints[0].WOL = true
+				cfg.WOLHosts = map[string]config.WOLHostConfig{
+					"nas":       {MAC: "aa:bb:cc:dd:ee:ff", DeviceTag: "keenetic"},
+					"office-pc": {MAC: "office-pc", DeviceTag: "missing"},
+				}
+			},
+			want: []config.Problem{
+				{Path: "wol_hosts.office-pc.mac", Message: `invalid mac address "office-pc"`},
+				{Path: "wol_hosts.office-pc.device_tag", Message: `device "missing" not found`},
+			},
+		},
+		{
+			name: "Schedules",
+			modify: func(cfg *config.Config) {
+				cfg.Actions = map[string]config.ActionConfig{
+					"guest-wifi": {
+						DeviceTag: "keenetic",
+						Path:      "/rci/interface/{{ .iface }}",
+						Params:    map[string]config.ActionParamConfig{"iface": {Required: true}},
+					},
+				}
+				cfg.Schedules = map[string]config.ScheduleConfig{
+					"guest-on":  {Cron: "0 9 * * sat,sun", Action: "guest-wifi", Params: map[string]string{"state": "up"}},
+					"reboot":    {Cron: "0 4 * *", DeviceTag: "keenetic", Path: "/rci/system/reboot", MissedRun: "always"},
+					"unknown":   {Cron: "@daily", Action: "missing"},
+					"no-target": {Cron: "@hourly", Jitter: -time.Minute},
+				}
+			},
+			want: []config.Problem{
+				{Path: "schedules.guest-on.params.state", Message: `unknown param of action "guest-wifi"`},
+				{Path: "schedules.guest-on.params.iface", Message: `param is required by action "guest-wifi"`},
+				{Path: "schedules.no-target.jitter", Message: "jitter can't be negative"},
+				{Path: "schedules.no-target", Message: "action or path is required"},
+				{Path: "schedules.reboot.cron", Message: "invalid cron expression: expected exactly 5 fields, found 4: [0 4 * *]"},
+				{Path: "schedules.reboot.missed_run", Message: `unsupported policy "always", expected one of: skip, run_once`},
+				{Path: "schedules.unknown.action", Message: `action "missing" not found`},
+			},
+		},
+		{
+			name: "Backups",
+			modify: func(cfg *config.Config) {
+				cfg.Backups = config.BackupConfig{Dir: "/data/backups", Interval: -time.Hour, Devices: []string{"keenetic", "missing"}}
+			},
+			want: []config.Problem{
+				{Path: "backups.interval", Message: "interval can't be negative"},
+				{Path: "backups.devices[1]", Message: `device "missing" not found`},
+			},
+		},
+		{
+			name: "Drift",
+			modify: func(cfg *config.Config) {
+				cfg.Drift = config.DriftConfig{Devices: []string{"keenetic"}, WebhookURL: "ftp://example.com"}
+			},
+			want: []config.Problem{
+				{Path: "drift.dir", Message: "dir is required"},
+			},
+		},
+		{
+			name: "Presence",
+			modify: func(cfg *config.Config) {
+				cfg.Entrypoints[0].Events = true
+				cfg.Presence = config.PresenceConfig{Enabled: true, Devices: []string{"glinet", "missing"}}
+			},
+			want: []config.Problem{
+				{Path: "presence.devices[1]", Message: `device "missing" not found`},
+				{Path: "entrypoints[0].events", Message: `device "keenetic" is not in presence.devices`},
+			},
+		},
+		{
+			name: "Webhooks",
+			modify: func(cfg *config.Config) {
+				cfg.Webhooks = config.WebhooksConfig{
+					MaxAttempts: -1,
+					Endpoints: []config.WebhookConfig{
+						{URL: "https://hooks.example.com", Events: []string{"auth_failed", "login"}},
+						{URL: "https://hooks.example.com"},
+						{},
+					},
+				}
+			},
+			want: []config.Problem{
+				{Path: "webhooks.max_attempts", Message: "max_attempts can't be negative"},
+				{Path: "webhooks.endpoints[0].events[1]", Message: `unknown event "login", expected one of: auth_failed, request_denied, device_login_failed, device_unreachable, config_drift, config_drift_resolved, host_join, host_leave`},
+				{Path: "webhooks.endpoints[1].url", Message: `duplicate url "https://hooks.example.com"`},
+				{Path: "webhooks.endpoints[2].url", Message: "url is required"},
+			},
+		},
+		{
+			name: "MQTT",
+			modify: func(cfg *config.Config) {
+				cfg.Actions = map[string]config.ActionConfig{
+					"guest-wifi": {DeviceTag: "keenetic", Path: "/rci/interface", Users: []string{"alice"}},
+					"wifi":       {DeviceTag: "keenetic", Path: "/rci/interface", Users: []string{"alice"}, AllowMQTT: true},
+				}
+				cfg.MQTT = config.MQTTConfig{
+					Broker:      "http://broker:1883",
+					TopicPrefix: "home/#",
+					Devices:     []string{"missing"},
+					Actions:     []string{"reboot", "guest-wifi", "wifi"},
+				}
+			},
+			want: []config.Problem{
+				{Path: "mqtt.broker", Message: `unsupported scheme "http", expected one of: tcp, mqtt, ssl, tls, mqtts, ws, wss`},
+				{Path: "mqtt.topic_prefix", Message: "topic prefix can't contain wildcards"},
+				{Path: "mqtt.devices[0]", Message: `device "missing" not found`},
+				{Path: "mqtt.actions[0]", Message: `action "reboot" not found`},
+				{Path: "mqtt.actions[1]", Message: `action "guest-wifi" is restricted to users, set allow_mqtt to run it by mqtt`},
+			},
+		},
+		{
+			name: "Metrics",
+			modify: func(cfg *config.Config) {
+				cfg.Metrics = config.MetricsConfig{Listen: "127.0.0.1:8080", Path: "metrics"}
+			},
+			want: []config.Problem{
+				{Path: "metrics.path", Message: "path must start with /"},
+				{Path: "metrics.listen", Message: `listen address "127.0.0.1:8080" is already used by entrypoints[0]`},
+			},
+		},
+		{
+			name: "Telemetry",
+			modify: func(cfg *config.Config) {
+				cfg.Telemetry = config.TelemetryConfig{Enabled: true, Interval: -time.Second, Devices: []string{"missing"}}
+			},
+			want: []config.Problem{
+				{Path: "telemetry.enabled", Message: "telemetry requires metrics.listen"},
+				{Path: "telemetry.interval", Message: "interval can't be negative"},
+				{Path: "telemetry.devices[0]", Message: `device "missing" not found`},
+			},
+		},
+		{
+			name: "Hosts",
+			modify: func(cfg *config.Config) {
+				cfg.Entrypoints[0].Hosts = []config.HostConfig{
+					{Host: "*.routers.example.com"},
+					{Host: "*.lab.example.com", Devices: []string{"missing"}},
+					{Host: "router.example.com", RouteConfig: config.RouteConfig{DeviceTag: "keenetic"}, Devices: []string{"keenetic"}},
+				}
+			},
+			want: []config.Problem{
+				{Path: "entrypoints[0].hosts[0].devices", Message: "devices are required for wildcard hosts without device_tag and mounts"},
+				{Path: "entrypoints[0].hosts[1].devices[0]", Message: `device "missing" not found`},
+				{Path: "entrypoints[0].hosts[2].devices", Message: "devices are only used by wildcard hosts without device_tag and mounts"},
+			},
+		},
+		{
+			name: "Route actions",
+			modify: func(cfg *config.Config) {
+				cfg.Actions = map[string]config.ActionConfig{
+					"reboot":    {DeviceTag: "keenetic", Path: "/rci/system/reboot"},
+					"gl-reboot": {DeviceTag: "glinet", Path: "/rpc"},
+				}
+				cfg.Entrypoints[0].Actions = []string{"reboot", "gl-reboot"}
+				cfg.Entrypoints[0].Hosts = []config.HostConfig{{
+					Host:        "gl.example.com",
+					RouteConfig: config.RouteConfig{DeviceTag: "glinet", Actions: []string{"gl-reboot"}},
+				}}
+			},
+			want: []config.Problem{{
+				Path:    "entrypoints[0].actions[1]",
+				Message: `action "gl-reboot" runs on device "glinet", which the route doesn't serve`,
+			}},
+		},
+		{
+			name: "Multiple problems",
+			modify: func(cfg *config.Config) {
+				cfg.Devices[0].Type = "openwrt"
+				cfg.Entrypoints[0].Hosts = []config.HostConfig{{Host: "gl.example.com"}}
+			},
+			want: []config.Problem{
+				{Path: "devices[0].type", Message: `unsupported device type "openwrt", expected one of: keenetic, glinet`},
+				{Path: "entrypoints[0].hosts[0]", Message: "device_tag or mounts are required for non-wildcard hosts"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid()
+			tt.modify(cfg)
+			assert.Equal(t, tt.want, config.Validate(cfg))
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const defaultSecretsDir = "/run/secrets"
+
+// ${NAME}, ${NAME:-default} or the escaped $${...}
+var envRegexp = regexp.MustCompile(`\$?\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+var intRegexp = regexp.MustCompile(`^[-+]?[0-9]+$`)
+
+// expandEnv replaces environment variable references in all scalar values.
+// Errors contain the variable name, never its value.
+func expandEnv(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode && strings.Contains(n.Value, "${") {
+		var errs []string
+		value := envRegexp.ReplaceAllStringFunc(n.Value, func(ref string) string {
+			if strings.HasPrefix(ref, "$$") {
+				return ref[1:]
+			}
+
+			m := envRegexp.FindStringSubmatch(ref)
+			if v, ok := os.LookupEnv(m[1]); ok {
+				return v
+			}
+			if m[2] != "" {
+				return m[3]
+			}
+
+			errs = append(errs, m[1])
+			return ""
+		})
+
+		if len(errs) > 0 {
+			return fmt.Errorf("line %d: environment variable %s is not set", n.Line, strings.Join(errs, ", "))
+		}
+
+		n.Value = value
+		if n.Style == 0 {
+			n.Tag = substitutedTag(value)
+		}
+	}
+
+	for _, c := range n.Content {
+		if err := expandEnv(c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// substitutedTag is the tag of a plain scalar after substitution. Booleans
+// and decimal integers keep their type for typed fields, anything else stays a
+// string, so that e.g. a password "~" or "yes" isn't resolved by YAML.
+func substitutedTag(value string) string {
+	switch {
+	case value == "true" || value == "false":
+		return "!!bool"
+	case intRegexp.MatchString(value):
+		return "!!int"
+	default:
+		return "!!str"
+	}
+}
+
+// resolveSecrets reads password_file values into passwords.
+func resolveSecrets(cfg *Config) error {
+	for i := range cfg.Devices {
+		d := &cfg.Devices[i]
+		for j := range d.Users {
+			u := &d.Users[j]
+			name := fmt.Sprintf("device %q user %q", d.Tag, u.Username)
+			if err := readPasswordFile(name, &u.Password, u.PasswordFile); err != nil {
+				return err
+			}
+		}
+	}
+
+	for i := range cfg.Entrypoints {
+		e := &cfg.Entrypoints[i]
+		if err := resolveRouteSecrets(e.Listen, &e.RouteConfig); err != nil {
+			return err
+		}
+		for j := range e.Hosts {
+			if err := resolveRouteSecrets(e.Listen+" "+e.Hosts[j].Host, &e.Hosts[j].RouteConfig); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func resolveRouteSecrets(route string, rc *RouteConfig) error {
+	for i := range rc.BasicAuth {
+		b := &rc.BasicAuth[i]
+		name := fmt.Sprintf("entrypoint %q basic auth user %q", route, b.Username)
+		if err := readPasswordFile(name, &b.Password, b.PasswordFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readPasswordFile(name string, password *string, file string) error {
+	if file == "" {
+		return nil
+	}
+	if *password != "" {
+		return fmt.Errorf("%s: password and password_file are mutually exclusive", name)
+	}
+
+	path := secretPath(file)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("%s: failed to read password_file %s: %w", name, path, err)
+	}
+
+	*password = strings.TrimRight(string(data), "\r\n")
+	if *password == "" {
+		return fmt.Errorf("%s: password_file %s is empty", name, path)
+	}
+
+	return nil
+}
+
+// secretPath resolves bare file names to the Docker secrets directory,
+// overridable with SECRETS_DIR.
+func secretPath(file string) string {
+	if strings.ContainsRune(file, filepath.Separator) {
+		return file
+	}
+
+	dir := os.Getenv("SECRETS_DIR")
+	if dir == "" {
+		dir = defaultSecretsDir
+	}
+	return filepath.Join(dir, file)
+}
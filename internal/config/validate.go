@@ -0,0 +1,573 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Problem is a semantic error in the configuration. Path points to the field
+// in the YAML document, e.g. "entrypoints[0].mounts[1].device_tag".
+type Problem struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (p Problem) String() string {
+	if p.Path == "" {
+		return p.Message
+	}
+	return p.Path + ": " + p.Message
+}
+
+var (
+	deviceTypes      = []string{"keenetic", "glinet"}
+	deviceURLSchemes = []string{"http", "https"}
+	proxyURLSchemes  = []string{"http", "https", "socks5", "socks5h"}
+	actionMethods    = []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
+
+	missedRunPolicies = []string{"skip", "run_once"}
+	mqttSchemes       = []string{"tcp", "mqtt", "ssl", "tls", "mqtts", "ws", "wss"}
+	webhookEvents     = []string{
+		"auth_failed", "request_denied", "device_login_failed", "device_unreachable",
+		"config_drift", "config_drift_resolved", "host_join", "host_leave",
+	}
+)
+
+// Validate checks the references between entrypoints and devices and other
+// settings which are syntactically valid but can't work. It reports all
+// problems instead of stopping at the first one.
+func Validate(cfg *Config) []Problem {
+	v := &validator{devices: make(map[string]DeviceConfig)}
+
+	for i, d := range cfg.Devices {
+		v.device(fmt.Sprintf("devices[%d]", i), d)
+	}
+
+	for _, name := range sortedKeys(cfg.WOLHosts) {
+		v.wolHost("wol_hosts."+name, cfg.WOLHosts[name])
+	}
+
+	for _, name := range sortedKeys(cfg.Actions) {
+		v.action("actions."+name, cfg.Actions[name])
+	}
+
+	for _, name := range sortedKeys(cfg.Schedules) {
+		v.schedule("schedules."+name, cfg.Schedules[name], cfg.Actions)
+	}
+
+	v.backups("backups", cfg.Backups)
+	v.drift("drift", cfg.Drift)
+	v.presence("presence", cfg.Presence)
+	v.webhooks("webhooks", cfg.Webhooks)
+	v.mqtt("mqtt", cfg.MQTT, cfg.Actions)
+	v.metrics("metrics", cfg.Metrics, cfg.Entrypoints)
+	v.telemetry("telemetry", cfg.Telemetry, cfg.Metrics)
+
+	listens := make(map[string]int)
+	for i, e := range cfg.Entrypoints {
+		path := fmt.Sprintf("entrypoints[%d]", i)
+
+		switch prev, ok := listens[e.Listen]; {
+		case e.Listen == "":
+			v.add(path+".listen", "listen address is required")
+		case ok:
+			v.add(path+".listen", "duplicate listen address %q, already used by entrypoints[%d]", e.Listen, prev)
+		default:
+			listens[e.Listen] = i
+		}
+
+		if e.DeviceTag == "" && len(e.Mounts) == 0 && len(e.Hosts) == 0 {
+			v.add(path, "device_tag, mounts or hosts are required")
+		}
+		v.route(path, e.RouteConfig)
+		v.wol(path, e.RouteConfig, cfg.WOLHosts)
+		v.routeActions(path, e.RouteConfig, nil, cfg.Actions)
+		v.routeEvents(path, e.RouteConfig, cfg.Presence)
+
+		hosts := make(map[string]bool)
+		for j, h := range e.Hosts {
+			hostPath := fmt.Sprintf("%s.hosts[%d]", path, j)
+
+			switch {
+			case h.Host == "":
+				v.add(hostPath+".host", "host is required")
+			case hosts[strings.ToLower(h.Host)]:
+				v.add(hostPath+".host", "duplicate host %q", h.Host)
+			}
+			hosts[strings.ToLower(h.Host)] = true
+			v.wol(hostPath, h.RouteConfig, cfg.WOLHosts)
+			v.routeActions(hostPath, h.RouteConfig, h.Devices, cfg.Actions)
+			v.routeEvents(hostPath, h.RouteConfig, cfg.Presence)
+
+			if h.BySubdomain() {
+				if len(h.Devices) == 0 {
+					v.add(hostPath+".devices", "devices are required for wildcard hosts without device_tag and mounts")
+				}
+				for k, tag := range h.Devices {
+					if _, ok := v.devices[tag]; !ok {
+						v.add(fmt.Sprintf("%s.devices[%d]", hostPath, k), "device %q not found", tag)
+						continue
+					}
+					rc := h.RouteConfig
+					rc.DeviceTag = tag
+					v.routeDevice(hostPath, rc, tag, rc.BypassUser, rc.DefaultUser)
+				}
+				v.routeAuth(hostPath, h.RouteConfig)
+				continue
+			}
+
+			if h.DeviceTag == "" && len(h.Mounts) == 0 {
+				v.add(hostPath, "device_tag or mounts are required for non-wildcard hosts")
+			}
+			if len(h.Devices) > 0 {
+				v.add(hostPath+".devices", "devices are only used by wildcard hosts without device_tag and mounts")
+			}
+			v.route(hostPath, h.RouteConfig)
+		}
+	}
+
+	return v.problems
+}
+
+type validator struct {
+	devices  map[string]DeviceConfig
+	problems []Problem
+}
+
+func (v *validator) add(path, format string, args ...any) {
+	v.problems = append(v.problems, Problem{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) device(path string, d DeviceConfig) {
+	switch _, ok := v.devices[d.Tag]; {
+	case d.Tag == "":
+		v.add(path+".tag", "tag is required")
+	case ok:
+		v.add(path+".tag", "duplicate device tag %q", d.Tag)
+	default:
+		v.devices[d.Tag] = d
+	}
+
+	if !contains(deviceTypes, d.Type) {
+		v.add(path+".type", "unsupported device type %q, expected one of: %s", d.Type, strings.Join(deviceTypes, ", "))
+	}
+
+	v.url(path+".url", d.URL, deviceURLSchemes, true)
+	v.url(path+".proxy_url", d.ProxyUrl, proxyURLSchemes, false)
+
+	if len(d.Users) == 0 {
+		v.add(path+".users", "device has no users")
+	}
+
+	users := make(map[string]bool)
+	for i, u := range d.Users {
+		userPath := fmt.Sprintf("%s.users[%d]", path, i)
+		switch {
+		case u.Username == "":
+			v.add(userPath+".username", "username is required")
+		case users[u.Username]:
+			v.add(userPath+".username", "duplicate user %q", u.Username)
+		}
+		users[u.Username] = true
+	}
+}
+
+func (v *validator) url(path, raw string, schemes []string, required bool) {
+	if raw == "" {
+		if required {
+			v.add(path, "url is required")
+		}
+		return
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		v.add(path, "invalid url: %v", err)
+		return
+	}
+	if !contains(schemes, u.Scheme) {
+		v.add(path, "unsupported scheme %q, expected one of: %s", u.Scheme, strings.Join(schemes, ", "))
+	}
+	if u.Host == "" {
+		v.add(path, "url has no host")
+	}
+}
+
+func (v *validator) route(path string, rc RouteConfig) {
+	if rc.DeviceTag != "" {
+		v.routeDevice(path, rc, rc.DeviceTag, rc.BypassUser, rc.DefaultUser)
+	}
+
+	prefixes := make(map[string]bool)
+	for i, m := range rc.Mounts {
+		mountPath := fmt.Sprintf("%s.mounts[%d]", path, i)
+
+		switch prefix := strings.TrimSuffix(m.Prefix, "/"); {
+		case !strings.HasPrefix(m.Prefix, "/"):
+			v.add(mountPath+".prefix", "prefix must start with \"/\"")
+		case prefixes[prefix]:
+			v.add(mountPath+".prefix", "duplicate prefix %q", m.Prefix)
+		default:
+			prefixes[prefix] = true
+		}
+
+		if m.DeviceTag == "" {
+			v.add(mountPath+".device_tag", "device_tag is required")
+			continue
+		}
+
+		bypassUser, defaultUser := m.BypassUser, m.DefaultUser
+		if bypassUser == "" {
+			bypassUser = rc.BypassUser
+		}
+		if defaultUser == "" {
+			defaultUser = rc.DefaultUser
+		}
+		v.routeDevice(mountPath, rc, m.DeviceTag, bypassUser, defaultUser)
+	}
+
+	v.routeAuth(path, rc)
+}
+
+// routeDevice checks the settings of a route that depend on the device it
+// is served by.
+func (v *validator) routeDevice(path string, rc RouteConfig, tag, bypassUser, defaultUser string) {
+	d, ok := v.devices[tag]
+	if !ok {
+		v.add(path+".device_tag", "device %q not found", tag)
+		return
+	}
+
+	if len(rc.BypassAuthEndpoints) > 0 && bypassUser == "" {
+		v.add(path+".bypass_user", "bypass_auth_endpoints require bypass_user to be set")
+	}
+	if bypassUser != "" && !hasUser(d, bypassUser) {
+		v.add(path+".bypass_user", "user %q not found for device %q", bypassUser, tag)
+	}
+	if defaultUser != "" && !hasUser(d, defaultUser) {
+		v.add(path+".default_user", "user %q not found for device %q", defaultUser, tag)
+	}
+
+	for _, header := range sortedKeys(rc.ForwardAuth.Mapping) {
+		if user := rc.ForwardAuth.Mapping[header]; !hasUser(d, user) {
+			v.add(path+".forward_auth.mapping."+header, "user %q not found for device %q", user, tag)
+		}
+	}
+
+	if (len(rc.RCIPolicy.Allow) > 0 || len(rc.RCIPolicy.Deny) > 0) && d.Type != "keenetic" {
+		v.add(path+".rci_policy", "rci_policy is only supported for keenetic devices, %q is %s", tag, d.Type)
+	}
+}
+
+func (v *validator) wol(path string, rc RouteConfig, hosts map[string]WOLHostConfig) {
+	if rc.WOL && len(hosts) == 0 {
+		v.add(path+".wol", "wol is enabled, but there are no wol_hosts")
+	}
+}
+
+func (v *validator) action(path string, a ActionConfig) {
+	if d, ok := v.devices[a.DeviceTag]; !ok {
+		v.add(path+".device_tag", "device %q not found", a.DeviceTag)
+	} else if a.User != "" && !hasUser(d, a.User) {
+		v.add(path+".user", "user %q not found for device %q", a.User, a.DeviceTag)
+	}
+
+	if a.Method != "" && !contains(actionMethods, strings.ToUpper(a.Method)) {
+		v.add(path+".method", "unsupported method %q", a.Method)
+	}
+
+	if a.Path == "" {
+		v.add(path+".path", "path is required")
+	}
+	if _, err := template.New("path").Parse(a.Path); err != nil {
+		v.add(path+".path", "invalid template: %v", err)
+	}
+	if _, err := template.New("body").Parse(a.Body); err != nil {
+		v.add(path+".body", "invalid template: %v", err)
+	}
+
+	for _, name := range sortedKeys(a.Params) {
+		p := a.Params[name]
+		if _, err := regexp.Compile(p.Pattern); err != nil {
+			v.add(path+".params."+name+".pattern", "invalid pattern: %v", err)
+		}
+		if p.Required && p.Default != "" {
+			v.add(path+".params."+name, "required params can't have a default")
+		}
+	}
+}
+
+func (v *validator) schedule(path string, s ScheduleConfig, actions map[string]ActionConfig) {
+	if _, err := cron.ParseStandard(s.Cron); err != nil {
+		v.add(path+".cron", "invalid cron expression: %v", err)
+	}
+	if s.Jitter < 0 {
+		v.add(path+".jitter", "jitter can't be negative")
+	}
+	if s.MissedRun != "" && !contains(missedRunPolicies, s.MissedRun) {
+		v.add(path+".missed_run", "unsupported policy %q, expected one of: %s", s.MissedRun, strings.Join(missedRunPolicies, ", "))
+	}
+
+	switch {
+	case s.Action != "" && (s.Path != "" || s.DeviceTag != ""):
+		v.add(path, "action can't be combined with device_tag and path")
+	case s.Action != "":
+		a, ok := actions[s.Action]
+		if !ok {
+			v.add(path+".action", "action %q not found", s.Action)
+			return
+		}
+		for _, name := range sortedKeys(s.Params) {
+			if _, ok := a.Params[name]; !ok {
+				v.add(path+".params."+name, "unknown param of action %q", s.Action)
+			}
+		}
+		for _, name := range sortedKeys(a.Params) {
+			if _, ok := s.Params[name]; a.Params[name].Required && !ok {
+				v.add(path+".params."+name, "param is required by action %q", s.Action)
+			}
+		}
+	case s.Path == "":
+		v.add(path, "action or path is required")
+	default:
+		if len(s.Params) > 0 {
+			v.add(path+".params", "params are only supported with action")
+		}
+		if s.Method != "" && !contains(actionMethods, strings.ToUpper(s.Method)) {
+			v.add(path+".method", "unsupported method %q", s.Method)
+		}
+		if d, ok := v.devices[s.DeviceTag]; !ok {
+			v.add(path+".device_tag", "device %q not found", s.DeviceTag)
+		} else if s.User != "" && !hasUser(d, s.User) {
+			v.add(path+".user", "user %q not found for device %q", s.User, s.DeviceTag)
+		}
+	}
+}
+
+func (v *validator) backups(path string, b BackupConfig) {
+	if !b.Enabled() {
+		if b.Git || b.Interval != 0 || len(b.Devices) > 0 {
+			v.add(path+".dir", "dir is required")
+		}
+		return
+	}
+	if b.Interval < 0 {
+		v.add(path+".interval", "interval can't be negative")
+	}
+	for i, tag := range b.Devices {
+		if _, ok := v.devices[tag]; !ok {
+			v.add(fmt.Sprintf("%s.devices[%d]", path, i), "device %q not found", tag)
+		}
+	}
+}
+
+func (v *validator) drift(path string, d DriftConfig) {
+	if !d.Enabled() {
+		if d.Interval != 0 || len(d.Devices) > 0 || len(d.Include) > 0 || len(d.Exclude) > 0 || d.WebhookURL != "" {
+			v.add(path+".dir", "dir is required")
+		}
+		return
+	}
+	if d.Interval < 0 {
+		v.add(path+".interval", "interval can't be negative")
+	}
+	for i, tag := range d.Devices {
+		if _, ok := v.devices[tag]; !ok {
+			v.add(fmt.Sprintf("%s.devices[%d]", path, i), "device %q not found", tag)
+		}
+	}
+	v.url(path+".webhook_url", d.WebhookURL, deviceURLSchemes, false)
+}
+
+func (v *validator) presence(path string, p PresenceConfig) {
+	if p.Interval < 0 {
+		v.add(path+".interval", "interval can't be negative")
+	}
+	for i, tag := range p.Devices {
+		if _, ok := v.devices[tag]; !ok {
+			v.add(fmt.Sprintf("%s.devices[%d]", path, i), "device %q not found", tag)
+		}
+	}
+	v.url(path+".webhook_url", p.WebhookURL, deviceURLSchemes, false)
+}
+
+func (v *validator) webhooks(path string, w WebhooksConfig) {
+	if w.MaxAttempts < 0 {
+		v.add(path+".max_attempts", "max_attempts can't be negative")
+	}
+	if len(w.Endpoints) == 0 && (w.QueueDir != "" || w.MaxAttempts != 0) {
+		v.add(path+".endpoints", "at least one endpoint is required")
+	}
+
+	urls := make(map[string]bool)
+	for i, e := range w.Endpoints {
+		endpointPath := fmt.Sprintf("%s.endpoints[%d]", path, i)
+		v.url(endpointPath+".url", e.URL, deviceURLSchemes, true)
+		if urls[e.URL] {
+			v.add(endpointPath+".url", "duplicate url %q", e.URL)
+		}
+		urls[e.URL] = true
+
+		for j, name := range e.Events {
+			if !contains(webhookEvents, name) {
+				v.add(fmt.Sprintf("%s.events[%d]", endpointPath, j), "unknown event %q, expected one of: %s", name, strings.Join(webhookEvents, ", "))
+			}
+		}
+	}
+}
+
+func (v *validator) mqtt(path string, m MQTTConfig, actions map[string]ActionConfig) {
+	if !m.Enabled() {
+		if m.TopicPrefix != "" || len(m.Devices) > 0 || len(m.Actions) > 0 || m.Discovery {
+			v.add(path+".broker", "broker is required")
+		}
+		return
+	}
+	v.url(path+".broker", m.Broker, mqttSchemes, true)
+	if m.Interval < 0 {
+		v.add(path+".interval", "interval can't be negative")
+	}
+	if strings.ContainsAny(m.TopicPrefix, "+#") {
+		v.add(path+".topic_prefix", "topic prefix can't contain wildcards")
+	}
+	for i, tag := range m.Devices {
+		if _, ok := v.devices[tag]; !ok {
+			v.add(fmt.Sprintf("%s.devices[%d]", path, i), "device %q not found", tag)
+		}
+	}
+	for i, name := range m.Actions {
+		a, ok := actions[name]
+		switch {
+		case !ok:
+			v.add(fmt.Sprintf("%s.actions[%d]", path, i), "action %q not found", name)
+		case len(a.Users) > 0 && !a.AllowMQTT:
+			v.add(fmt.Sprintf("%s.actions[%d]", path, i), "action %q is restricted to users, set allow_mqtt to run it by mqtt", name)
+		}
+	}
+}
+
+func (v *validator) metrics(path string, m MetricsConfig, entrypoints []EntrypointConfig) {
+	if !m.Enabled() {
+		if m.Path != "" {
+			v.add(path+".listen", "listen address is required")
+		}
+		return
+	}
+	if m.Path != "" && !strings.HasPrefix(m.Path, "/") {
+		v.add(path+".path", "path must start with /")
+	}
+	for i, e := range entrypoints {
+		if e.Listen == m.Listen {
+			v.add(path+".listen", "listen address %q is already used by entrypoints[%d]", m.Listen, i)
+		}
+	}
+}
+
+func (v *validator) telemetry(path string, t TelemetryConfig, m MetricsConfig) {
+	if !t.Enabled {
+		return
+	}
+	if !m.Enabled() {
+		v.add(path+".enabled", "telemetry requires metrics.listen")
+	}
+	if t.Interval < 0 {
+		v.add(path+".interval", "interval can't be negative")
+	}
+	for i, tag := range t.Devices {
+		if _, ok := v.devices[tag]; !ok {
+			v.add(fmt.Sprintf("%s.devices[%d]", path, i), "device %q not found", tag)
+		}
+	}
+}
+
+func (v *validator) routeEvents(path string, rc RouteConfig, p PresenceConfig) {
+	switch {
+	case !rc.Events:
+	case !p.Enabled:
+		v.add(path+".events", "events are enabled, but presence is not")
+	case rc.DeviceTag != "" && len(p.Devices) > 0 && !contains(p.Devices, rc.DeviceTag):
+		v.add(path+".events", "device %q is not in presence.devices", rc.DeviceTag)
+	}
+}
+
+// routeActions checks the actions of a route, which are only served for the
+// devices of the route.
+func (v *validator) routeActions(path string, rc RouteConfig, devices []string, actions map[string]ActionConfig) {
+	devices = append(slices.Clone(devices), rc.DeviceTag)
+	for _, m := range rc.Mounts {
+		devices = append(devices, m.DeviceTag)
+	}
+
+	for i, name := range rc.Actions {
+		a, ok := actions[name]
+		switch {
+		case !ok:
+			v.add(fmt.Sprintf("%s.actions[%d]", path, i), "action %q not found", name)
+		case !slices.Contains(devices, a.DeviceTag):
+			v.add(fmt.Sprintf("%s.actions[%d]", path, i), "action %q runs on device %q, which the route doesn't serve", name, a.DeviceTag)
+		}
+	}
+}
+
+func (v *validator) wolHost(path string, h WOLHostConfig) {
+	if _, err := net.ParseMAC(h.MAC); err != nil {
+		v.add(path+".mac", "invalid mac address %q", h.MAC)
+	}
+	if _, ok := v.devices[h.DeviceTag]; h.DeviceTag != "" && !ok {
+		v.add(path+".device_tag", "device %q not found", h.DeviceTag)
+	}
+}
+
+// routeAuth checks the authentication settings of a route.
+func (v *validator) routeAuth(path string, rc RouteConfig) {
+	if len(rc.BasicAuth) > 0 && rc.ForwardAuth.Header != "" {
+		v.add(path, "basic_auth and forward_auth can't be combined, basic auth is ignored when forward_auth is set")
+	}
+	if len(rc.ForwardAuth.Mapping) > 0 && rc.ForwardAuth.Header == "" {
+		v.add(path+".forward_auth.header", "header is required when mapping is set")
+	}
+
+	if len(rc.AllowedEndpoints) > 0 {
+		for i, endpoint := range rc.BypassAuthEndpoints {
+			if !contains(rc.AllowedEndpoints, endpoint) {
+				v.add(fmt.Sprintf("%s.bypass_auth_endpoints[%d]", path, i),
+					"endpoint %q is not in allowed_endpoints and will always be rejected", endpoint)
+			}
+		}
+	}
+}
+
+func hasUser(d DeviceConfig, name string) bool {
+	for _, u := range d.Users {
+		if u.Username == name {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
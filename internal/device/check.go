@@ -0,0 +1,78 @@
+package device
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LoginClient is implemented by clients that can log in on demand.
+type LoginClient interface {
+	Login(ctx context.Context) error
+}
+
+type CheckResult struct {
+	Device   string
+	Type     string
+	User     string
+	Latency  time.Duration
+	Identity Identity
+	Err      error
+}
+
+// Check logs in as every user of every device and reads the device identity.
+// Users are checked in parallel, results are sorted by device tag and keep
+// the order of the users.
+func (m *Manager) Check(ctx context.Context) []CheckResult {
+	tags := make([]string, 0, len(m.Devices))
+	for tag := range m.Devices {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	var (
+		results []CheckResult
+		clients []ClientWrapper
+	)
+	for _, tag := range tags {
+		d := m.Devices[tag]
+		for _, u := range d.Users {
+			results = append(results, CheckResult{Device: d.Tag, Type: d.Type, User: u.Name})
+			clients = append(clients, u.Client)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(res *CheckResult, c ClientWrapper) {
+			defer wg.Done()
+			start := time.Now()
+			res.Identity, res.Err = check(ctx, c)
+			res.Latency = time.Since(start)
+		}(&results[i], clients[i])
+	}
+	wg.Wait()
+
+	return results
+}
+
+func check(ctx context.Context, c ClientWrapper) (Identity, error) {
+	if lc, ok := c.(LoginClient); ok {
+		if err := lc.Login(ctx); err != nil {
+			return Identity{}, err
+		}
+	}
+
+	return ReadIdentity(ctx, c)
+}
+
+// ReadIdentity returns the model and firmware version of the device.
+func ReadIdentity(ctx context.Context, c ClientWrapper) (Identity, error) {
+	d, err := NewDriver(c)
+	if err != nil {
+		return Identity{}, err
+	}
+	return d.Identity(ctx)
+}
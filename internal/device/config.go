@@ -0,0 +1,53 @@
+package device
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/mazzz1y/router-auth-gw/pkg/glinet"
+	"github.com/mazzz1y/router-auth-gw/pkg/keenetic"
+)
+
+// ConfigReader is implemented by clients that can export the device
+// configuration themselves.
+type ConfigReader interface {
+	ReadConfig(ctx context.Context) ([]byte, error)
+}
+
+// ReadConfig returns the device configuration in a stable text form, so that
+// unchanged configurations are byte-equal: the running-config for keenetic
+// and indented JSON with sorted keys for glinet.
+func ReadConfig(ctx context.Context, c ClientWrapper) ([]byte, error) {
+	switch c := c.(type) {
+	case ConfigReader:
+		return c.ReadConfig(ctx)
+	case *keenetic.Client:
+		lines, err := c.RunningConfig(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strings.Join(lines, "\n") + "\n"), nil
+	case *glinet.Client:
+		data, err := c.Backup(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		var v any
+		if err := json.Unmarshal(data, &v); err != nil {
+			return nil, fmt.Errorf("failed to decode backup: %w", err)
+		}
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(v); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		return nil, ErrNotSupported
+	}
+}
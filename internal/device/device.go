@@ -2,10 +2,17 @@ package device
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"sort"
+	"syscall"
 
 	"github.com/mazzz1y/router-auth-gw/internal/config"
+	"github.com/mazzz1y/router-auth-gw/internal/metrics"
 	"github.com/mazzz1y/router-auth-gw/pkg/glinet"
 	"github.com/mazzz1y/router-auth-gw/pkg/keenetic"
 	"golang.org/x/net/websocket"
@@ -13,6 +20,7 @@ import (
 
 type Device struct {
 	Tag   string
+	Type  string
 	Users []User
 }
 
@@ -23,6 +31,7 @@ type User struct {
 
 type Manager struct {
 	Devices map[string]Device
+	configs map[string]config.DeviceConfig
 }
 
 type ClientWrapper interface {
@@ -30,29 +39,112 @@ type ClientWrapper interface {
 	Websocket() (*websocket.Conn, error)
 }
 
+// User returns the client of the named user, or of the first user if the
+// name is empty. Background jobs read the devices with their first user.
+func (d Device) User(name string) (ClientWrapper, error) {
+	for _, u := range d.Users {
+		if name == "" || u.Name == name {
+			return u.Client, nil
+		}
+	}
+
+	if name == "" {
+		return nil, fmt.Errorf("device %q has no users", d.Tag)
+	}
+	return nil, fmt.Errorf("user %q not found for device %q", name, d.Tag)
+}
+
+// IsLoginError reports whether the error is a device rejecting the
+// credentials of a user.
+func IsLoginError(err error) bool {
+	return errors.Is(err, keenetic.ErrAuthFailed) || errors.Is(err, glinet.ErrAuthFailed)
+}
+
+// Error classes of failed device requests.
+const (
+	ErrorClassLogin             = "login"
+	ErrorClassTimeout           = "timeout"
+	ErrorClassDNS               = "dns"
+	ErrorClassConnectionRefused = "connection_refused"
+	ErrorClassConnectionReset   = "connection_reset"
+	ErrorClassTLS               = "tls"
+	ErrorClassOther             = "other"
+)
+
+// ErrorClass returns the class of a failed device request.
+func ErrorClass(err error) string {
+	var (
+		dnsErr  *net.DNSError
+		netErr  net.Error
+		certErr *tls.CertificateVerificationError
+		recErr  tls.RecordHeaderError
+	)
+	switch {
+	case IsLoginError(err):
+		return ErrorClassLogin
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return ErrorClassTimeout
+	case errors.As(err, &dnsErr):
+		return ErrorClassDNS
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ErrorClassConnectionRefused
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+		return ErrorClassConnectionReset
+	case errors.As(err, &certErr), errors.As(err, &recErr):
+		return ErrorClassTLS
+	}
+	return ErrorClassOther
+}
+
+// LogoutClient is implemented by clients that can close their device session.
+type LogoutClient interface {
+	Logout(ctx context.Context) error
+}
+
 func NewDeviceManager(cfg []config.DeviceConfig) (*Manager, error) {
+	return newDeviceManager(cfg, nil)
+}
+
+// Reload creates a manager for the new configuration. Clients of users whose
+// device and credentials did not change are reused, so their sessions are kept.
+func (m *Manager) Reload(cfg []config.DeviceConfig) (*Manager, error) {
+	return newDeviceManager(cfg, m)
+}
+
+func newDeviceManager(cfg []config.DeviceConfig, prev *Manager) (*Manager, error) {
 	deviceManager := &Manager{
 		Devices: make(map[string]Device),
+		configs: make(map[string]config.DeviceConfig),
 	}
 
 	for _, cfgDevice := range cfg {
-		users, err := initClients(cfgDevice)
+		users, err := initClients(cfgDevice, prev)
 		if err != nil {
 			return nil, fmt.Errorf("%s: %v", cfgDevice.URL, err)
 		}
 
 		deviceManager.Devices[cfgDevice.Tag] = Device{
 			Tag:   cfgDevice.Tag,
+			Type:  cfgDevice.Type,
 			Users: users,
 		}
+		deviceManager.configs[cfgDevice.Tag] = cfgDevice
 	}
 	return deviceManager, nil
 }
 
-func initClients(c config.DeviceConfig) ([]User, error) {
+func initClients(c config.DeviceConfig, prev *Manager) ([]User, error) {
 	users := make([]User, len(c.Users))
 	for i, v := range c.Users {
-		client, err := createClient(c.Type, c.URL, c.ProxyUrl, v.Username, v.Password)
+		if client, ok := prev.client(c, v); ok {
+			users[i] = User{
+				Name:   v.Username,
+				Client: client,
+			}
+			continue
+		}
+
+		client, err := createClient(c.Type, c.URL, c.ProxyUrl, v.Username, v.Password, loginHook(c.Tag, v.Username))
 		if err != nil {
 			return nil, err
 		}
@@ -66,12 +158,58 @@ func initClients(c config.DeviceConfig) ([]User, error) {
 	return users, nil
 }
 
-func createClient(deviceType, url, proxyUrl, username, password string) (ClientWrapper, error) {
+// Select returns the devices of the tags sorted by tag, or all devices if no
+// tags are given.
+func (m *Manager) Select(tags []string) ([]Device, error) {
+	var devices []Device
+	if len(tags) == 0 {
+		for _, d := range m.Devices {
+			devices = append(devices, d)
+		}
+	}
+	for _, tag := range tags {
+		d, ok := m.Devices[tag]
+		if !ok {
+			return nil, fmt.Errorf("device %q not found", tag)
+		}
+		devices = append(devices, d)
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].Tag < devices[j].Tag })
+
+	return devices, nil
+}
+
+// client returns the existing client of the user if the device connection
+// settings and the user credentials are the same.
+func (m *Manager) client(c config.DeviceConfig, u config.UserConfig) (ClientWrapper, bool) {
+	if m == nil {
+		return nil, false
+	}
+
+	prevCfg, ok := m.configs[c.Tag]
+	if !ok || prevCfg.Type != c.Type || prevCfg.URL != c.URL || prevCfg.ProxyUrl != c.ProxyUrl {
+		return nil, false
+	}
+
+	for i, prevUser := range prevCfg.Users {
+		if prevUser == u {
+			return m.Devices[c.Tag].Users[i].Client, true
+		}
+	}
+
+	return nil, false
+}
+
+func createClient(deviceType, url, proxyUrl, username, password string, onAuth func(error)) (ClientWrapper, error) {
 	switch deviceType {
 	case "keenetic":
-		return keenetic.NewClient(url, proxyUrl, username, password), nil
+		c := keenetic.NewClient(url, proxyUrl, username, password)
+		c.OnAuth = onAuth
+		return c, nil
 	case "glinet":
-		return glinet.NewClient(url, proxyUrl, username, password), nil
+		c := glinet.NewClient(url, proxyUrl, username, password)
+		c.OnAuth = onAuth
+		return c, nil
 	default:
 		if deviceType == "" {
 			return nil, fmt.Errorf("you must specify a device type")
@@ -79,3 +217,31 @@ func createClient(deviceType, url, proxyUrl, username, password string) (ClientW
 		return nil, fmt.Errorf("unsupported device type: %s", deviceType)
 	}
 }
+
+// loginHook counts the logins of a device user.
+func loginHook(tag, user string) func(error) {
+	return func(err error) {
+		result := metrics.LoginSuccess
+		if err != nil {
+			result = metrics.LoginFailure
+		}
+		metrics.DeviceLogins.WithLabelValues(tag, user, result).Inc()
+	}
+}
+
+// Logout closes the sessions of all device users.
+func (m *Manager) Logout(ctx context.Context) error {
+	var errs []error
+	for _, d := range m.Devices {
+		for _, u := range d.Users {
+			c, ok := u.Client.(LogoutClient)
+			if !ok {
+				continue
+			}
+			if err := c.Logout(ctx); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %s: %w", d.Tag, u.Name, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
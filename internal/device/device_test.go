@@ -1,10 +1,23 @@
 package device_test
 
 import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"syscall"
 	"testing"
 
 	"github.com/mazzz1y/router-auth-gw/internal/config"
 	"github.com/mazzz1y/router-auth-gw/internal/device"
+	"github.com/mazzz1y/router-auth-gw/pkg/glinet"
+	"github.com/mazzz1y/router-auth-gw/pkg/keenetic"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -34,3 +47,211 @@ func TestNewDeviceManager(t *testing.T) {
 		assert.Equal(t, 2, len(manager.Devices["Device1"].Users))
 	})
 }
+
+func TestReload(t *testing.T) {
+	manager, err := device.NewDeviceManager(mockConfig.Devices)
+	assert.NoError(t, err)
+
+	changed := mockConfig.Devices[0]
+	changed.Users = []config.UserConfig{
+		{Username: "user1", Password: "pass1"},
+		{Username: "user2", Password: "changed"},
+	}
+
+	reloaded, err := manager.Reload([]config.DeviceConfig{changed})
+	assert.NoError(t, err)
+
+	prevUsers := manager.Devices["Device1"].Users
+	users := reloaded.Devices["Device1"].Users
+	assert.Same(t, prevUsers[0].Client, users[0].Client)
+	assert.NotSame(t, prevUsers[1].Client, users[1].Client)
+
+	changed.URL = "http://device1.lan"
+	reloaded, err = reloaded.Reload([]config.DeviceConfig{changed})
+	assert.NoError(t, err)
+	assert.NotSame(t, users[0].Client, reloaded.Devices["Device1"].Users[0].Client)
+}
+
+func TestCheck(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/auth" && r.Method == http.MethodGet:
+			w.Header().Set("X-NDM-Realm", "realm")
+			w.Header().Set("X-NDM-Challenge", "challenge")
+			w.WriteHeader(http.StatusUnauthorized)
+		case r.URL.Path == "/auth" && r.Method == http.MethodPost:
+			var payload map[string]string
+			_ = json.NewDecoder(r.Body).Decode(&payload)
+			if payload["login"] != "admin" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "1"})
+		case r.URL.Path == "/rci/show/version":
+			if _, err := r.Cookie("session"); err != nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"model":"Giga","title":"4.1.7"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	manager, err := device.NewDeviceManager([]config.DeviceConfig{
+		{
+			Tag:  "keenetic",
+			Type: "keenetic",
+			URL:  server.URL,
+			Users: []config.UserConfig{
+				{Username: "admin", Password: "pass"},
+				{Username: "expired", Password: "pass"},
+			},
+		},
+		{
+			Tag:   "glinet",
+			Type:  "glinet",
+			URL:   "http://127.0.0.1:1",
+			Users: []config.UserConfig{{Username: "root", Password: "pass"}},
+		},
+	})
+	assert.NoError(t, err)
+
+	results := manager.Check(context.Background())
+	assert.Len(t, results, 3)
+
+	assert.Equal(t, "glinet", results[0].Device)
+	assert.Error(t, results[0].Err)
+
+	assert.Equal(t, "admin", results[1].User)
+	assert.NoError(t, results[1].Err)
+	assert.Equal(t, device.Identity{Model: "Giga", Firmware: "4.1.7"}, results[1].Identity)
+	assert.Positive(t, results[1].Latency)
+
+	assert.Equal(t, "expired", results[2].User)
+	assert.EqualError(t, results[2].Err, "auth failed")
+}
+
+func TestDeviceUser(t *testing.T) {
+	manager, err := device.NewDeviceManager(mockConfig.Devices)
+	assert.NoError(t, err)
+	d := manager.Devices["Device1"]
+
+	c, err := d.User("")
+	assert.NoError(t, err)
+	assert.Same(t, d.Users[0].Client, c)
+
+	c, err = d.User("user2")
+	assert.NoError(t, err)
+	assert.Same(t, d.Users[1].Client, c)
+
+	_, err = d.User("missing")
+	assert.EqualError(t, err, `user "missing" not found for device "Device1"`)
+}
+
+func TestSelect(t *testing.T) {
+	manager := &device.Manager{Devices: map[string]device.Device{
+		"b": {Tag: "b"},
+		"a": {Tag: "a"},
+		"c": {Tag: "c"},
+	}}
+
+	devices, err := manager.Select(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []device.Device{{Tag: "a"}, {Tag: "b"}, {Tag: "c"}}, devices)
+
+	devices, err = manager.Select([]string{"c", "a"})
+	assert.NoError(t, err)
+	assert.Equal(t, []device.Device{{Tag: "a"}, {Tag: "c"}}, devices)
+
+	_, err = manager.Select([]string{"missing"})
+	assert.EqualError(t, err, `device "missing" not found`)
+}
+
+func TestDriver(t *testing.T) {
+	t.Run("Keenetic", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/rci/show/ip/hotspot":
+				w.Write([]byte(`{"host":[{"mac":"AA:BB:CC:DD:EE:FF","ip":"192.168.1.10","hostname":"pc","name":"Office PC","active":true,"interface":{"id":"Bridge0"}}]}`))
+			case "/rci/show/interface":
+				w.Write([]byte(`{"Bridge0":{"global":false},"PPPoE0":{"global":true,"connected":"yes","address":"1.2.3.4","uptime":60},` +
+					`"WifiMaster0/AccessPoint0":{"type":"AccessPoint","link":"up"},"WifiMaster1/AccessPoint0":{"type":"AccessPoint","link":"up"}}`))
+			case "/rci/show/interface/stat":
+				if r.URL.Query().Get("name") != "PPPoE0" {
+					http.Error(w, "no stat", http.StatusBadRequest)
+					return
+				}
+				w.Write([]byte(`{"rxbytes":1000,"txbytes":200}`))
+			case "/rci/show/system":
+				w.Write([]byte(`{"cpuload":12,"memtotal":262144,"memfree":100000,"membuffers":1000,"memcache":3000,"uptime":"3600"}`))
+			case "/rci/show/associations":
+				w.Write([]byte(`{"station":[{"mac":"aa:bb:cc:dd:ee:01","ap":"WifiMaster0/AccessPoint0"},{"mac":"aa:bb:cc:dd:ee:02","ap":"WifiMaster0/AccessPoint0"}]}`))
+			case "/rci/show/running-config":
+				w.Write([]byte(`{"message":["! $$$ Model: Keenetic Giga","interface Bridge0","!"]}`))
+			case "/rci/ip/hotspot/wake":
+				w.Write([]byte(`{"status":[{"status":"error","message":"host not found"}]}`))
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+		defer server.Close()
+
+		manager, err := device.NewDeviceManager([]config.DeviceConfig{{
+			Tag: "keenetic", Type: "keenetic", URL: server.URL,
+			Users: []config.UserConfig{{Username: "admin", Password: "pass"}},
+		}})
+		assert.NoError(t, err)
+
+		d, err := device.NewDriver(manager.Devices["keenetic"].Users[0].Client)
+		assert.NoError(t, err)
+
+		ctx := context.Background()
+
+		hosts, err := d.Hosts(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, []device.Host{{MAC: "aa:bb:cc:dd:ee:ff", IP: "192.168.1.10", Hostname: "Office PC", Interface: "Bridge0", Online: true}}, hosts)
+
+		wan, err := d.WAN(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, []device.WAN{{Interface: "PPPoE0", Up: true, IP: "1.2.3.4", Uptime: 60}}, wan)
+
+		telemetry, err := d.Telemetry(ctx)
+		assert.NoError(t, err)
+		cpuLoad := 12.0
+		assert.Equal(t, device.Telemetry{
+			CPULoad:         &cpuLoad,
+			MemoryTotal:     262144 * 1024,
+			MemoryAvailable: 104000 * 1024,
+			Uptime:          3600,
+			Interfaces:      []device.InterfaceTraffic{{Interface: "PPPoE0", RxBytes: 1000, TxBytes: 200}},
+			WirelessClients: map[string]int{"WifiMaster0/AccessPoint0": 2, "WifiMaster1/AccessPoint0": 0},
+		}, telemetry)
+
+		assert.EqualError(t, d.Wake(ctx, "aa:bb:cc:dd:ee:ff"), "/rci/ip/hotspot/wake: host not found")
+
+		cfg, err := device.ReadConfig(ctx, manager.Devices["keenetic"].Users[0].Client)
+		assert.NoError(t, err)
+		assert.Equal(t, "! $$$ Model: Keenetic Giga\ninterface Bridge0\n!\n", string(cfg))
+	})
+}
+
+func TestErrorClass(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{fmt.Errorf("auth: %w", keenetic.ErrAuthFailed), device.ErrorClassLogin},
+		{fmt.Errorf("auth: %w", glinet.ErrAuthFailed), device.ErrorClassLogin},
+		{fmt.Errorf("request: %w", context.DeadlineExceeded), device.ErrorClassTimeout},
+		{&net.DNSError{Err: "no such host", Name: "router.local"}, device.ErrorClassDNS},
+		{&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, device.ErrorClassConnectionRefused},
+		{&url.Error{Op: "Get", URL: "http://router", Err: io.EOF}, device.ErrorClassConnectionReset},
+		{&tls.CertificateVerificationError{Err: errors.New("unknown authority")}, device.ErrorClassTLS},
+		{errors.New("unexpected status"), device.ErrorClassOther},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, device.ErrorClass(tt.err), tt.err.Error())
+	}
+}
@@ -0,0 +1,119 @@
+// Package devicetest provides a fake device client for tests.
+package devicetest
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/mazzz1y/router-auth-gw/internal/device"
+	"golang.org/x/net/websocket"
+)
+
+// Client is a device client that implements device.Driver with fixed
+// results. Nil clients, WANs and stats are not supported by the device, a
+// set Err fails all driver functions. Requests get the Responses body of
+// their "METHOD path", or an empty one. Requests and woken hosts are recorded.
+type Client struct {
+	Info      device.Identity
+	Clients   []device.Host
+	WANs      []device.WAN
+	Stats     *device.Telemetry
+	Responses map[string]string
+	Err       error
+
+	mu       sync.Mutex
+	requests []string
+	woken    []string
+}
+
+// SetClients replaces the LAN hosts while the client is in use.
+func (c *Client) SetClients(hosts []device.Host) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Clients = hosts
+}
+
+// Requests returns the "METHOD path" of the received requests.
+func (c *Client) Requests() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.requests...)
+}
+
+// Woken returns the MAC addresses of the woken hosts.
+func (c *Client) Woken() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.woken...)
+}
+
+func (c *Client) Request(_ context.Context, method, path, _ string) (*http.Response, error) {
+	c.mu.Lock()
+	c.requests = append(c.requests, method+" "+path)
+	body := c.Responses[method+" "+path]
+	c.mu.Unlock()
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Header:     make(http.Header),
+	}, nil
+}
+
+func (c *Client) Websocket() (*websocket.Conn, error) {
+	return nil, device.ErrNotSupported
+}
+
+func (c *Client) Identity(context.Context) (device.Identity, error) {
+	return c.Info, c.Err
+}
+
+func (c *Client) Hosts(context.Context) ([]device.Host, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.Err != nil {
+		return nil, c.Err
+	}
+	if c.Clients == nil {
+		return nil, device.ErrNotSupported
+	}
+	return c.Clients, nil
+}
+
+func (c *Client) WAN(context.Context) ([]device.WAN, error) {
+	if c.Err != nil {
+		return nil, c.Err
+	}
+	if c.WANs == nil {
+		return nil, device.ErrNotSupported
+	}
+	return c.WANs, nil
+}
+
+func (c *Client) Telemetry(context.Context) (device.Telemetry, error) {
+	if c.Err != nil {
+		return device.Telemetry{}, c.Err
+	}
+	if c.Stats == nil {
+		return device.Telemetry{}, device.ErrNotSupported
+	}
+	return *c.Stats, nil
+}
+
+func (c *Client) Reboot(context.Context) error {
+	return c.Err
+}
+
+func (c *Client) Wake(_ context.Context, mac string) error {
+	if c.Err != nil {
+		return c.Err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.woken = append(c.woken, mac)
+	return nil
+}
@@ -0,0 +1,274 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/mazzz1y/router-auth-gw/pkg/glinet"
+	"github.com/mazzz1y/router-auth-gw/pkg/keenetic"
+)
+
+var ErrNotSupported = errors.New("not supported by the device")
+
+// Identity describes the hardware and firmware of a device.
+type Identity struct {
+	Model    string `json:"model"`
+	Firmware string `json:"firmware"`
+}
+
+// Host is a LAN client of a device.
+type Host struct {
+	MAC       string `json:"mac"`
+	IP        string `json:"ip,omitempty"`
+	Hostname  string `json:"hostname,omitempty"`
+	Interface string `json:"interface,omitempty"`
+	Online    bool   `json:"online"`
+}
+
+// WAN is the state of an internet connection of a device.
+type WAN struct {
+	Interface string `json:"interface"`
+	Up        bool   `json:"up"`
+	IP        string `json:"ip,omitempty"`
+	Gateway   string `json:"gateway,omitempty"`
+	Uptime    int64  `json:"uptime,omitempty"`
+}
+
+// Telemetry is the resource usage and traffic of a device. CPULoad and
+// LoadAverage are nil if the device doesn't report them.
+type Telemetry struct {
+	CPULoad         *float64 // percent
+	LoadAverage     *float64 // 1 minute
+	MemoryTotal     int64    // bytes
+	MemoryAvailable int64    // bytes, including buffers and cache
+	Uptime          int64    // seconds
+	Interfaces      []InterfaceTraffic
+	WirelessClients map[string]int // by access point
+}
+
+// InterfaceTraffic is the traffic counters of an interface.
+type InterfaceTraffic struct {
+	Interface string
+	RxBytes   int64
+	TxBytes   int64
+}
+
+// Driver exposes common device functions in the same form for all vendors.
+type Driver interface {
+	Identity(ctx context.Context) (Identity, error)
+	Hosts(ctx context.Context) ([]Host, error)
+	WAN(ctx context.Context) ([]WAN, error)
+	Telemetry(ctx context.Context) (Telemetry, error)
+	Reboot(ctx context.Context) error
+	Wake(ctx context.Context, mac string) error
+}
+
+// NewDriver returns the driver for the client of a device user. Clients may
+// implement Driver themselves.
+func NewDriver(c ClientWrapper) (Driver, error) {
+	switch c := c.(type) {
+	case Driver:
+		return c, nil
+	case *keenetic.Client:
+		return keeneticDriver{c}, nil
+	case *glinet.Client:
+		return glinetDriver{c}, nil
+	default:
+		return nil, ErrNotSupported
+	}
+}
+
+type keeneticDriver struct {
+	c *keenetic.Client
+}
+
+func (d keeneticDriver) Identity(ctx context.Context) (Identity, error) {
+	v, err := d.c.Version(ctx)
+	if err != nil {
+		return Identity{}, err
+	}
+	return Identity{Model: v.Model, Firmware: v.Title}, nil
+}
+
+func (d keeneticDriver) Hosts(ctx context.Context) ([]Host, error) {
+	hosts, err := d.c.Hosts(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]Host, 0, len(hosts))
+	for _, h := range hosts {
+		name := h.Name
+		if name == "" {
+			name = h.Hostname
+		}
+		res = append(res, Host{
+			MAC:       strings.ToLower(h.MAC),
+			IP:        h.IP,
+			Hostname:  name,
+			Interface: h.Interface.ID,
+			Online:    h.Active,
+		})
+	}
+	return res, nil
+}
+
+func (d keeneticDriver) WAN(ctx context.Context) ([]WAN, error) {
+	ifaces, err := d.c.Interfaces(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []WAN
+	for id, i := range ifaces {
+		if !i.Global && !i.DefaultGW {
+			continue
+		}
+		res = append(res, WAN{
+			Interface: id,
+			Up:        i.Connected == "yes",
+			IP:        i.Address,
+			Uptime:    i.Uptime,
+		})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Interface < res[j].Interface })
+
+	return res, nil
+}
+
+func (d keeneticDriver) Telemetry(ctx context.Context) (Telemetry, error) {
+	sys, err := d.c.System(ctx)
+	if err != nil {
+		return Telemetry{}, err
+	}
+	uptime, _ := sys.Uptime.Int64()
+	t := Telemetry{
+		CPULoad:         &sys.CPULoad,
+		MemoryTotal:     sys.MemTotal * 1024,
+		MemoryAvailable: (sys.MemFree + sys.MemBuffers + sys.MemCache) * 1024,
+		Uptime:          uptime,
+		WirelessClients: make(map[string]int),
+	}
+
+	ifaces, err := d.c.Interfaces(ctx)
+	if err != nil {
+		return Telemetry{}, err
+	}
+	for id, i := range ifaces {
+		if i.Type == "AccessPoint" && i.Link == "up" {
+			t.WirelessClients[id] = 0
+		}
+		if i.Link != "up" && i.Connected != "yes" {
+			continue
+		}
+		stat, err := d.c.InterfaceStat(ctx, id)
+		if err != nil {
+			// Not every interface type has counters.
+			if ctx.Err() != nil {
+				return Telemetry{}, err
+			}
+			continue
+		}
+		t.Interfaces = append(t.Interfaces, InterfaceTraffic{Interface: id, RxBytes: stat.RxBytes, TxBytes: stat.TxBytes})
+	}
+	sort.Slice(t.Interfaces, func(i, j int) bool { return t.Interfaces[i].Interface < t.Interfaces[j].Interface })
+
+	stations, err := d.c.Associations(ctx)
+	if err != nil {
+		return Telemetry{}, err
+	}
+	for _, st := range stations {
+		t.WirelessClients[st.AP]++
+	}
+
+	return t, nil
+}
+
+func (d keeneticDriver) Reboot(ctx context.Context) error {
+	return d.c.Reboot(ctx)
+}
+
+func (d keeneticDriver) Wake(ctx context.Context, mac string) error {
+	return d.c.Wake(ctx, mac)
+}
+
+type glinetDriver struct {
+	c *glinet.Client
+}
+
+func (d glinetDriver) Identity(ctx context.Context) (Identity, error) {
+	info, err := d.c.SystemInfo(ctx)
+	if err != nil {
+		return Identity{}, err
+	}
+	return Identity{Model: info.Model, Firmware: info.FirmwareVersion}, nil
+}
+
+func (d glinetDriver) Hosts(ctx context.Context) ([]Host, error) {
+	clients, err := d.c.Clients(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]Host, 0, len(clients))
+	for _, c := range clients {
+		res = append(res, Host{
+			MAC:       strings.ToLower(c.MAC),
+			IP:        c.IP,
+			Hostname:  c.Name,
+			Interface: c.Iface,
+			Online:    c.Online,
+		})
+	}
+	return res, nil
+}
+
+func (d glinetDriver) WAN(ctx context.Context) ([]WAN, error) {
+	status, err := d.c.CableStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return []WAN{{
+		Interface: "wan",
+		Up:        status.Up,
+		IP:        status.IPv4.IP,
+		Gateway:   status.IPv4.Gateway,
+		Uptime:    status.Uptime,
+	}}, nil
+}
+
+// Telemetry of GL.iNet devices has no interface counters, and the Wi-Fi
+// clients are counted for all radios as "wireless".
+func (d glinetDriver) Telemetry(ctx context.Context) (Telemetry, error) {
+	status, err := d.c.SystemStatus(ctx)
+	if err != nil {
+		return Telemetry{}, err
+	}
+
+	sys := status.System
+	t := Telemetry{
+		MemoryTotal:     sys.MemoryTotal,
+		MemoryAvailable: sys.MemoryFree + sys.MemoryBuffCache,
+		Uptime:          sys.Uptime,
+		WirelessClients: make(map[string]int),
+	}
+	if len(sys.LoadAverage) > 0 {
+		t.LoadAverage = &sys.LoadAverage[0]
+	}
+	for _, c := range status.Client {
+		t.WirelessClients["wireless"] += c.WirelessTotal
+	}
+
+	return t, nil
+}
+
+func (d glinetDriver) Reboot(ctx context.Context) error {
+	return d.c.Reboot(ctx)
+}
+
+func (d glinetDriver) Wake(ctx context.Context, mac string) error {
+	return d.c.Wake(ctx, mac)
+}
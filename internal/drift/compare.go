@@ -0,0 +1,227 @@
+package drift
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Change kinds.
+const (
+	Added   = "added"
+	Removed = "removed"
+	Changed = "changed"
+)
+
+// Change is a section that differs from the golden snapshot.
+type Change struct {
+	Section string   `json:"section"`
+	Kind    string   `json:"change"`
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+}
+
+// section is a top-level block of a configuration: a command with its
+// indented sub-commands for text configs, a top-level key for JSON ones.
+type section struct {
+	name   string
+	fields []field
+}
+
+// field is a line of a section. The key is matched by the rules, it is the
+// line itself for text configs and the dotted path for JSON ones.
+type field struct {
+	key  string
+	line string
+}
+
+// Rules select the compared sections and fields. Patterns are globs where
+// "*" matches any text.
+type Rules struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+// NewRules compiles the patterns. Only sections matching include are
+// compared, all if it is empty. Sections and fields matching exclude are
+// ignored.
+func NewRules(include, exclude []string) Rules {
+	return Rules{include: globs(include), exclude: globs(exclude)}
+}
+
+func globs(patterns []string) []*regexp.Regexp {
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		expr := strings.ReplaceAll(regexp.QuoteMeta(p), `\*`, ".*")
+		res = append(res, regexp.MustCompile("^"+expr+"$"))
+	}
+	return res
+}
+
+func matchAny(res []*regexp.Regexp, s string) bool {
+	for _, re := range res {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Rules) section(name string) bool {
+	if len(r.include) > 0 && !matchAny(r.include, name) {
+		return false
+	}
+	return !matchAny(r.exclude, name)
+}
+
+func (r Rules) field(key string) bool {
+	return !matchAny(r.exclude, key)
+}
+
+// Compare returns the changes of current against golden, sorted by section.
+func Compare(golden, current []byte, rules Rules) ([]Change, error) {
+	goldenSections, err := parse(golden)
+	if err != nil {
+		return nil, fmt.Errorf("golden: %w", err)
+	}
+	currentSections, err := parse(current)
+	if err != nil {
+		return nil, err
+	}
+
+	prev := rules.lines(goldenSections)
+	next := rules.lines(currentSections)
+
+	var changes []Change
+	for name, lines := range prev {
+		nextLines, ok := next[name]
+		if !ok {
+			changes = append(changes, Change{Section: name, Kind: Removed, Removed: lines})
+			continue
+		}
+
+		added, removed := diffLines(lines, nextLines)
+		if len(added) > 0 || len(removed) > 0 {
+			changes = append(changes, Change{Section: name, Kind: Changed, Added: added, Removed: removed})
+		}
+	}
+	for name, lines := range next {
+		if _, ok := prev[name]; !ok {
+			changes = append(changes, Change{Section: name, Kind: Added, Added: lines})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Section < changes[j].Section })
+	return changes, nil
+}
+
+// lines returns the lines of the sections selected by the rules.
+func (r Rules) lines(sections []section) map[string][]string {
+	res := make(map[string][]string)
+	for _, s := range sections {
+		if !r.section(s.name) {
+			continue
+		}
+		var lines []string
+		for _, f := range s.fields {
+			if r.field(f.key) {
+				lines = append(lines, f.line)
+			}
+		}
+		res[s.name] = append(res[s.name], lines...)
+	}
+	return res
+}
+
+// diffLines returns the lines only in next and only in prev.
+func diffLines(prev, next []string) (added, removed []string) {
+	count := make(map[string]int)
+	for _, l := range prev {
+		count[l]++
+	}
+	for _, l := range next {
+		if count[l] > 0 {
+			count[l]--
+			continue
+		}
+		added = append(added, l)
+	}
+	for _, l := range prev {
+		if count[l] > 0 {
+			count[l]--
+			removed = append(removed, l)
+		}
+	}
+	return added, removed
+}
+
+func parse(data []byte) ([]section, error) {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		return parseJSON(data)
+	}
+	return parseText(data), nil
+}
+
+// parseText splits a CLI configuration into top-level commands. Comment
+// lines starting with "!" are ignored, they hold volatile data such as the
+// time of the last change.
+func parseText(data []byte) []section {
+	var (
+		sections []section
+		current  *section
+	)
+	for _, line := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "!") {
+			continue
+		}
+
+		if line[0] != ' ' && line[0] != '\t' {
+			sections = append(sections, section{name: trimmed})
+			current = &sections[len(sections)-1]
+			continue
+		}
+		if current != nil {
+			current.fields = append(current.fields, field{key: trimmed, line: trimmed})
+		}
+	}
+	return sections
+}
+
+// parseJSON splits a JSON object by its top-level keys, the values are
+// flattened to "path = value" lines.
+func parseJSON(data []byte) ([]section, error) {
+	var root map[string]any
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("failed to decode config: %w", err)
+	}
+
+	sections := make([]section, 0, len(root))
+	for name, v := range root {
+		s := section{name: name}
+		flatten(name, v, &s.fields)
+		sort.Slice(s.fields, func(i, j int) bool { return s.fields[i].key < s.fields[j].key })
+		sections = append(sections, s)
+	}
+	return sections, nil
+}
+
+func flatten(path string, v any, fields *[]field) {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, child := range v {
+			flatten(path+"."+k, child, fields)
+		}
+	case []any:
+		for i, child := range v {
+			flatten(path+"["+strconv.Itoa(i)+"]", child, fields)
+		}
+	default:
+		value, _ := json.Marshal(v)
+		*fields = append(*fields, field{key: path, line: path + " = " + string(value)})
+	}
+}
@@ -0,0 +1,251 @@
+package drift
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/mazzz1y/router-auth-gw/internal/config"
+	"github.com/mazzz1y/router-auth-gw/internal/device"
+	"github.com/mazzz1y/router-auth-gw/internal/webhook"
+	"github.com/mazzz1y/router-auth-gw/internal/worker"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultInterval = time.Hour
+	fetchTimeout    = time.Minute
+)
+
+// Event names.
+const (
+	EventDrift    = "config_drift"
+	EventResolved = "config_drift_resolved"
+)
+
+// ErrNoGolden is returned for devices without a pinned snapshot.
+var ErrNoGolden = errors.New("no golden snapshot, pin one with \"drift pin\"")
+
+// Event is posted to the webhook when the drift of a device changes.
+type Event struct {
+	Event   string        `json:"event"`
+	Device  string        `json:"device"`
+	Time    time.Time     `json:"time"`
+	Changes []EventChange `json:"changes"`
+}
+
+// EventChange is a drifted section in an event. It has the number of added
+// and removed lines, the lines themselves only with report_lines, as they may
+// hold passwords and keys.
+type EventChange struct {
+	Section      string   `json:"section"`
+	Kind         string   `json:"change"`
+	Added        int      `json:"added"`
+	Removed      int      `json:"removed"`
+	AddedLines   []string `json:"added_lines,omitempty"`
+	RemovedLines []string `json:"removed_lines,omitempty"`
+}
+
+// Result is the outcome of a drift check of a device.
+type Result struct {
+	Device  string
+	Changes []Change
+	Err     error
+}
+
+// Monitor periodically compares the device configurations with their golden
+// snapshots and reports when the differences change.
+type Monitor struct {
+	dir         string
+	interval    time.Duration
+	devices     []device.Device
+	rules       Rules
+	reportLines bool
+	hooks       *webhook.Dispatcher
+
+	mu       sync.Mutex
+	reported map[string]string
+	group    worker.Group
+}
+
+// New creates the monitor of the configuration. Events are emitted to hooks,
+// which may be nil.
+func New(cfg config.DriftConfig, dm *device.Manager, hooks *webhook.Dispatcher) (*Monitor, error) {
+	m := &Monitor{
+		dir:         cfg.Dir,
+		interval:    cfg.Interval,
+		rules:       NewRules(cfg.Include, cfg.Exclude),
+		reportLines: cfg.ReportLines,
+		hooks:       hooks,
+		reported:    make(map[string]string),
+	}
+	if !cfg.Enabled() {
+		return m, nil
+	}
+	if m.interval <= 0 {
+		m.interval = defaultInterval
+	}
+
+	devices, err := dm.Select(cfg.Devices)
+	if err != nil {
+		return nil, fmt.Errorf("drift: %w", err)
+	}
+	m.devices = devices
+
+	return m, nil
+}
+
+// Inherit takes over the reported drifts of a previous monitor, so that a
+// reload doesn't repeat the events.
+func (m *Monitor) Inherit(prev *Monitor) {
+	prev.mu.Lock()
+	defer prev.mu.Unlock()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for k, v := range prev.reported {
+		m.reported[k] = v
+	}
+}
+
+// Start checks the devices now and then every interval.
+func (m *Monitor) Start(ctx context.Context) {
+	if len(m.devices) == 0 {
+		return
+	}
+
+	ctx = m.group.Start(ctx)
+	m.group.Go(func() {
+		worker.Every(ctx, m.interval, func(ctx context.Context) {
+			for _, res := range m.Check(ctx) {
+				m.report(res)
+			}
+		})
+	})
+}
+
+// Stop cancels running checks and waits for them to finish.
+func (m *Monitor) Stop() {
+	m.group.Stop()
+}
+
+// Check compares the configurations of all devices with their golden
+// snapshots.
+func (m *Monitor) Check(ctx context.Context) []Result {
+	results := make([]Result, 0, len(m.devices))
+	for _, d := range m.devices {
+		res := Result{Device: d.Tag}
+
+		golden, err := os.ReadFile(m.goldenPath(d.Tag))
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			res.Err = ErrNoGolden
+		case err != nil:
+			res.Err = err
+		default:
+			var current []byte
+			current, res.Err = readConfig(ctx, d)
+			if res.Err == nil {
+				res.Changes, res.Err = Compare(golden, current, m.rules)
+			}
+		}
+
+		results = append(results, res)
+	}
+	return results
+}
+
+// Pin saves the current configuration of the device as its golden snapshot.
+func (m *Monitor) Pin(ctx context.Context, tag string) error {
+	for _, d := range m.devices {
+		if d.Tag != tag {
+			continue
+		}
+
+		data, err := readConfig(ctx, d)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(m.dir, 0o700); err != nil {
+			return err
+		}
+		return os.WriteFile(m.goldenPath(tag), data, 0o600)
+	}
+	return fmt.Errorf("device %q is not monitored", tag)
+}
+
+// report logs and emits the result if it differs from the last reported one.
+func (m *Monitor) report(res Result) {
+	l := log.With().Str("device", res.Device).Logger()
+	if res.Err != nil {
+		l.Error().Err(res.Err).Msg("config drift check failed")
+		return
+	}
+
+	var key string
+	if len(res.Changes) > 0 {
+		data, _ := json.Marshal(res.Changes)
+		key = string(data)
+	}
+
+	m.mu.Lock()
+	prev := m.reported[res.Device]
+	m.reported[res.Device] = key
+	m.mu.Unlock()
+
+	if key == prev {
+		return
+	}
+
+	event := Event{Event: EventDrift, Device: res.Device, Time: time.Now().UTC(), Changes: m.eventChanges(res.Changes)}
+	if len(res.Changes) == 0 {
+		event.Event = EventResolved
+		l.Info().Msg("config drift resolved")
+	} else {
+		sections := make([]string, 0, len(res.Changes))
+		for _, c := range res.Changes {
+			sections = append(sections, c.Kind+" "+c.Section)
+		}
+		l.Warn().Strs("sections", sections).Msg("config drift detected")
+	}
+
+	m.hooks.Emit(event.Event, event.Device, event)
+}
+
+func (m *Monitor) eventChanges(changes []Change) []EventChange {
+	res := make([]EventChange, 0, len(changes))
+	for _, c := range changes {
+		ec := EventChange{Section: c.Section, Kind: c.Kind, Added: len(c.Added), Removed: len(c.Removed)}
+		if m.reportLines {
+			ec.AddedLines = c.Added
+			ec.RemovedLines = c.Removed
+		}
+		res = append(res, ec)
+	}
+	return res
+}
+
+func (m *Monitor) goldenPath(tag string) string {
+	return filepath.Join(m.dir, tag+".golden")
+}
+
+func readConfig(ctx context.Context, d device.Device) ([]byte, error) {
+	client, err := d.User("")
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+
+	data, err := device.ReadConfig(ctx, client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	return data, nil
+}
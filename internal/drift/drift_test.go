@@ -0,0 +1,181 @@
+package drift
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mazzz1y/router-auth-gw/internal/config"
+	"github.com/mazzz1y/router-auth-gw/internal/device"
+	"github.com/mazzz1y/router-auth-gw/internal/webhook"
+	"golang.org/x/net/websocket"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockClient struct {
+	config string
+}
+
+func (m *mockClient) Request(context.Context, string, string, string) (*http.Response, error) {
+	return nil, nil
+}
+
+func (m *mockClient) Websocket() (*websocket.Conn, error) {
+	return nil, nil
+}
+
+func (m *mockClient) ReadConfig(context.Context) ([]byte, error) {
+	return []byte(m.config), nil
+}
+
+const keeneticConfig = `! $$$ Model: Keenetic Giga
+! $$$ Last change: Sat, 17 Oct 2026 10:00:00 GMT
+system
+    hostname Keenetic
+    clock timezone Europe/Berlin
+!
+interface Bridge0
+    ip address 192.168.1.1 255.255.255.0
+    up
+!
+ip static tcp PPPoE0 8080 192.168.1.10 80
+`
+
+func TestCompare(t *testing.T) {
+	t.Run("Text", func(t *testing.T) {
+		current := `! $$$ Model: Keenetic Giga
+! $$$ Last change: Sun, 18 Oct 2026 10:00:00 GMT
+system
+    hostname Keenetic
+    clock timezone Europe/Paris
+!
+interface Bridge0
+    ip address 192.168.1.1 255.255.255.0
+    up
+!
+ip static tcp PPPoE0 8443 192.168.1.10 443
+`
+		changes, err := Compare([]byte(keeneticConfig), []byte(current), NewRules(nil, nil))
+		require.NoError(t, err)
+		assert.Equal(t, []Change{
+			{Section: "ip static tcp PPPoE0 8080 192.168.1.10 80", Kind: Removed},
+			{Section: "ip static tcp PPPoE0 8443 192.168.1.10 443", Kind: Added},
+			{Section: "system", Kind: Changed, Added: []string{"clock timezone Europe/Paris"}, Removed: []string{"clock timezone Europe/Berlin"}},
+		}, changes)
+
+		changes, err = Compare([]byte(keeneticConfig), []byte(current), NewRules([]string{"system", "interface *"}, []string{"clock *"}))
+		require.NoError(t, err)
+		assert.Empty(t, changes)
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		golden := `{"system": {"hostname": "gl", "uptime": 10}, "firewall": {"redirects": [{"port": 80}]}}`
+		current := `{"system": {"hostname": "gl", "uptime": 20}, "firewall": {"redirects": [{"port": 8080}]}}`
+
+		changes, err := Compare([]byte(golden), []byte(current), NewRules(nil, []string{"system.uptime"}))
+		require.NoError(t, err)
+		assert.Equal(t, []Change{{
+			Section: "firewall",
+			Kind:    Changed,
+			Added:   []string{"firewall.redirects[0].port = 8080"},
+			Removed: []string{"firewall.redirects[0].port = 80"},
+		}}, changes)
+	})
+}
+
+func TestMonitor(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		posted []Event
+	)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p struct{ Data Event }
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&p))
+		mu.Lock()
+		posted = append(posted, p.Data)
+		mu.Unlock()
+	}))
+	defer receiver.Close()
+
+	hooks, err := webhook.NewDispatcher(config.WebhooksConfig{Endpoints: []config.WebhookConfig{{URL: receiver.URL}}})
+	require.NoError(t, err)
+	hooks.Start(context.Background())
+	defer hooks.Stop()
+	received := func(n int) []Event {
+		assert.Eventually(t, func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(posted) >= n
+		}, 5*time.Second, 10*time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]Event(nil), posted...)
+	}
+
+	client := &mockClient{config: keeneticConfig}
+	dm := &device.Manager{Devices: map[string]device.Device{
+		"router": {Tag: "router", Type: "keenetic", Users: []device.User{{Name: "admin", Client: client}}},
+	}}
+
+	m, err := New(config.DriftConfig{Dir: t.TempDir()}, dm, hooks)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	results := m.Check(ctx)
+	require.Len(t, results, 1)
+	assert.ErrorIs(t, results[0].Err, ErrNoGolden)
+
+	require.NoError(t, m.Pin(ctx, "router"))
+	assert.EqualError(t, m.Pin(ctx, "missing"), `device "missing" is not monitored`)
+
+	report := func() {
+		for _, res := range m.Check(ctx) {
+			m.report(res)
+		}
+	}
+
+	report()
+
+	client.config += "ip static tcp PPPoE0 22 192.168.1.10 22\n"
+	report()
+	report()
+	events := received(1)
+	assert.Equal(t, EventDrift, events[0].Event)
+	assert.Equal(t, "router", events[0].Device)
+	assert.Equal(t, []EventChange{{Section: "ip static tcp PPPoE0 22 192.168.1.10 22", Kind: Added}}, events[0].Changes)
+
+	client.config = keeneticConfig
+	report()
+	events = received(2)
+	require.Len(t, events, 2)
+	assert.Equal(t, EventResolved, events[1].Event)
+	assert.Empty(t, events[1].Changes)
+
+	t.Run("Changed lines", func(t *testing.T) {
+		client.config = strings.Replace(keeneticConfig, "Europe/Berlin", "Europe/Paris", 1)
+		report()
+		events := received(3)
+		assert.Equal(t, []EventChange{{Section: "system", Kind: Changed, Added: 1, Removed: 1}}, events[2].Changes,
+			"lines are not reported by default")
+
+		m.reportLines = true
+		client.config = strings.Replace(keeneticConfig, "Europe/Berlin", "Europe/Rome", 1)
+		report()
+		events = received(4)
+		assert.Equal(t, []EventChange{{
+			Section:      "system",
+			Kind:         Changed,
+			Added:        1,
+			Removed:      1,
+			AddedLines:   []string{"clock timezone Europe/Rome"},
+			RemovedLines: []string{"clock timezone Europe/Berlin"},
+		}}, events[3].Changes)
+	})
+}
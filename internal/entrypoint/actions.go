@@ -0,0 +1,113 @@
+package entrypoint
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/mazzz1y/router-auth-gw/internal/action"
+)
+
+func (e *Entrypoint) runAction(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	a, ok := e.Options.Actions[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("unknown action"))
+		return
+	}
+
+	identity, _ := r.Context().Value(identityContextKey).(string)
+	if !a.Allowed(identity) {
+		e.log.Warn().
+			Str("from", r.RemoteAddr).
+			Str("user", identity).
+			Str("action", name).
+			Msg("action not allowed")
+		writeError(w, http.StatusForbidden, errors.New("not allowed to run this action"))
+		return
+	}
+
+	params, err := actionParams(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// The action runs as its device user, its request has to pass the policy
+	// of the entrypoint like any other.
+	path, body, err := a.Render(params)
+	var paramErr *action.ParamError
+	switch {
+	case errors.As(err, &paramErr):
+		writeError(w, http.StatusBadRequest, err)
+		return
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !e.policyAllows(w, r, path, []byte(body)) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	res, err := a.Run(ctx, params)
+	switch {
+	case errors.As(err, &paramErr):
+		writeError(w, http.StatusBadRequest, err)
+		return
+	case err != nil:
+		e.deviceError(w, r, err)
+		return
+	}
+
+	e.log.Info().
+		Str("from", r.RemoteAddr).
+		Str("user", identity).
+		Str("action", name).
+		Int("status", res.StatusCode).
+		Msg("action executed")
+
+	if res.ContentType != "" {
+		w.Header().Set("Content-Type", res.ContentType)
+	}
+	w.WriteHeader(res.StatusCode)
+	w.Write(res.Body)
+}
+
+// actionParams reads params from the query string and a JSON object body.
+// Non-string JSON values are passed in their JSON form.
+func actionParams(r *http.Request) (map[string]string, error) {
+	params := make(map[string]string)
+	for k, v := range r.URL.Query() {
+		params[k] = v[0]
+	}
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body")
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return params, nil
+	}
+
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(data, &body); err != nil {
+		return nil, fmt.Errorf("request body must be a JSON object")
+	}
+	for k, raw := range body {
+		var s string
+		if json.Unmarshal(raw, &s) == nil {
+			params[k] = s
+		} else {
+			params[k] = string(raw)
+		}
+	}
+
+	return params, nil
+}
@@ -0,0 +1,194 @@
+package entrypoint
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/mazzz1y/router-auth-gw/internal/device"
+	"github.com/mazzz1y/router-auth-gw/internal/schedule"
+)
+
+// gatewayPrefix is the path prefix of endpoints served by the gateway itself
+// instead of the device.
+const gatewayPrefix = "/_gw/"
+
+// RCI commands run by the Keenetic driver. They are checked against the RCI
+// policy, which is only set for Keenetic devices, so that the gateway
+// endpoints can't run what the policy denies.
+const (
+	rebootCommand = "/rci/system/reboot"
+	wakeCommand   = "/rci/ip/hotspot/wake"
+)
+
+func isGatewayPath(path string) bool {
+	return strings.HasPrefix(path, gatewayPrefix)
+}
+
+// newGatewayHandler returns the handler of the gateway endpoints, or nil if
+// none are enabled and all requests go to the device.
+func (e *Entrypoint) newGatewayHandler() http.Handler {
+	if !e.Options.API && !e.Options.WOL && len(e.Options.Actions) == 0 &&
+		e.Options.Schedules == nil && e.Options.Presence == nil {
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	if e.Options.API {
+		mux.HandleFunc("GET /_gw/api/v1/clients", e.withDriver(e.apiClients))
+		mux.HandleFunc("GET /_gw/api/v1/wan", e.withDriver(e.apiWAN))
+		mux.HandleFunc("POST /_gw/api/v1/reboot", e.withDriver(e.apiReboot))
+		mux.HandleFunc("POST /_gw/api/v1/wol", e.withDriver(e.apiWake))
+	}
+	if e.Options.WOL {
+		mux.HandleFunc("POST /_gw/wol", e.withDriver(e.wake))
+	}
+	if len(e.Options.Actions) > 0 {
+		mux.HandleFunc("POST /_gw/actions/{name}", e.runAction)
+	}
+	if e.Options.Schedules != nil {
+		mux.HandleFunc("GET /_gw/api/schedules", e.schedules)
+	}
+	if e.Options.Presence != nil {
+		mux.HandleFunc("GET /_gw/events", e.events)
+	}
+	return mux
+}
+
+type driverHandler func(w http.ResponseWriter, r *http.Request, d device.Driver)
+
+func (e *Entrypoint) withDriver(next driverHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, ok := r.Context().Value(clientContextKey).(device.ClientWrapper)
+		if !ok {
+			writeError(w, http.StatusInternalServerError, errors.New(http.StatusText(http.StatusInternalServerError)))
+			return
+		}
+
+		d, err := device.NewDriver(c)
+		if err != nil {
+			writeError(w, http.StatusNotImplemented, err)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		next(w, r.WithContext(ctx), d)
+	}
+}
+
+func (e *Entrypoint) apiClients(w http.ResponseWriter, r *http.Request, d device.Driver) {
+	hosts, err := d.Hosts(r.Context())
+	if err != nil {
+		e.deviceError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"clients": hosts})
+}
+
+func (e *Entrypoint) apiWAN(w http.ResponseWriter, r *http.Request, d device.Driver) {
+	wan, err := d.WAN(r.Context())
+	if err != nil {
+		e.deviceError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"wan": wan})
+}
+
+func (e *Entrypoint) apiReboot(w http.ResponseWriter, r *http.Request, d device.Driver) {
+	if !e.policyAllows(w, r, rebootCommand, nil) {
+		return
+	}
+	if err := d.Reboot(r.Context()); err != nil {
+		e.deviceError(w, r, err)
+		return
+	}
+	e.log.Info().Str("from", r.RemoteAddr).Msg("device reboot requested")
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "rebooting"})
+}
+
+func (e *Entrypoint) apiWake(w http.ResponseWriter, r *http.Request, d device.Driver) {
+	var req struct {
+		MAC string `json:"mac"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, errors.New("invalid request body"))
+		return
+	}
+
+	mac, err := net.ParseMAC(req.MAC)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.New("invalid mac address"))
+		return
+	}
+
+	// With wake-on-lan hosts on the entrypoint, only the hosts the user may
+	// wake through /_gw/wol can be woken here.
+	if e.Options.WOL {
+		name, host, ok := e.wolHost(mac.String())
+		if !ok || !e.wolAllowed(r, name, host) {
+			writeError(w, http.StatusForbidden, errors.New("not allowed to wake this host"))
+			return
+		}
+	}
+	if !e.policyAllows(w, r, wakeCommand, nil) {
+		return
+	}
+
+	if err := d.Wake(r.Context(), mac.String()); err != nil {
+		e.deviceError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "sent", "mac": mac.String()})
+}
+
+// policyAllows checks an RCI request made on behalf of the client against the
+// policy and responds with 403 if it is denied.
+func (e *Entrypoint) policyAllows(w http.ResponseWriter, r *http.Request, path string, body []byte) bool {
+	err := e.Options.RCIPolicy.Check(path, body)
+	if err == nil {
+		return true
+	}
+
+	e.log.Info().
+		Err(err).
+		Str("from", r.RemoteAddr).
+		Str("uri", r.URL.RequestURI()).
+		Msg("rci command not allowed")
+	writeError(w, http.StatusForbidden, err)
+	return false
+}
+
+// schedules lists the schedules of the entrypoint device.
+func (e *Entrypoint) schedules(w http.ResponseWriter, r *http.Request) {
+	res := make([]schedule.Status, 0)
+	for _, st := range e.Options.Schedules.Status() {
+		if st.Device == e.Options.Device.Tag {
+			res = append(res, st)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"schedules": res})
+}
+
+func (e *Entrypoint) deviceError(w http.ResponseWriter, r *http.Request, err error) {
+	e.log.Error().Err(err).Str("uri", r.URL.RequestURI()).Msg("device api request failed")
+	if errors.Is(err, device.ErrNotSupported) {
+		writeError(w, http.StatusNotImplemented, err)
+		return
+	}
+	writeError(w, http.StatusBadGateway, errors.New("device request failed"))
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -1,50 +1,105 @@
 package entrypoint
 
 import (
+	"fmt"
+	"sync"
+
+	"github.com/mazzz1y/router-auth-gw/internal/action"
 	"github.com/mazzz1y/router-auth-gw/internal/device"
+	"github.com/mazzz1y/router-auth-gw/internal/presence"
+	"github.com/mazzz1y/router-auth-gw/internal/schedule"
+	"github.com/mazzz1y/router-auth-gw/internal/webhook"
+	"github.com/mazzz1y/router-auth-gw/pkg/keenetic"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"golang.org/x/net/websocket"
 	"net/http"
 )
 
 type contextKey string
 
-const clientContextKey = contextKey("client")
+const (
+	clientContextKey   = contextKey("client")
+	identityContextKey = contextKey("identity")
+)
 
 type Entrypoint struct {
 	log     zerolog.Logger
 	Options Options
+	handler http.Handler
+	gateway http.Handler
+
+	wsMu    sync.Mutex
+	wsConns map[*websocket.Conn]struct{}
 }
 
 type Options struct {
 	Device              device.Device
 	ListenAddr          string
+	Prefix              string
 	ForwardAuthHeader   string
 	ForwardAuthMapping  map[string]string
 	BasicAuth           map[string]string
 	BypassAuthEndpoints []string
+	BypassUser          string
+	DefaultUser         string
 	AllowedEndpoints    []string
 	OnlyGet             bool
+	RCIPolicy           keenetic.Policy
+	API                 bool
+	WOL                 bool
+	WOLHosts            map[string]WOLHost
+	Actions             map[string]*action.Action
+	Schedules           *schedule.Scheduler
+	Presence            *presence.Poller
+	Webhooks            *webhook.Dispatcher
 }
 
 func NewEntrypoint(options Options) *Entrypoint {
-	return &Entrypoint{
+	e := &Entrypoint{
 		log: log.With().
 			Str("entrypoint", options.ListenAddr).
 			Str("device", options.Device.Tag).
 			Logger(),
 		Options: options,
+		wsConns: make(map[*websocket.Conn]struct{}),
 	}
+	e.gateway = e.newGatewayHandler()
+	e.handler = e.newHandler()
+	return e
 }
 
-func (e *Entrypoint) Start() error {
-	mux := http.NewServeMux()
-	handler := e.authenticateMiddleware(
-		e.reqAllowedMiddleware(e.handleRequest),
+func (e *Entrypoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.handler.ServeHTTP(w, r)
+}
+
+func (e *Entrypoint) newHandler() http.Handler {
+	return e.metricsMiddleware(
+		e.authenticateMiddleware(
+			e.reqAllowedMiddleware(
+				e.rciPolicyMiddleware(e.handleRequest),
+			),
+		),
 	)
-	mux.HandleFunc("/", handler)
-	e.log.Info().Msg("listener started")
-	return http.ListenAndServe(e.Options.ListenAddr, mux)
+}
+
+// validate makes sure that requests without authentication never run as
+// a device user that was not explicitly chosen for them.
+func (e *Entrypoint) validate() error {
+	if len(e.Options.BypassAuthEndpoints) > 0 && e.Options.BypassUser == "" {
+		return fmt.Errorf("bypass_auth_endpoints require bypass_user to be set")
+	}
+
+	for _, name := range []string{e.Options.BypassUser, e.Options.DefaultUser} {
+		if name == "" {
+			continue
+		}
+		if _, ok := e.deviceUser(name); !ok {
+			return fmt.Errorf("user %q not found for device %q", name, e.Options.Device.Tag)
+		}
+	}
+
+	return nil
 }
 
 func (e *Entrypoint) isAuthEnabled() bool {
@@ -59,6 +114,11 @@ func (e *Entrypoint) handleRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if e.gateway != nil && isGatewayPath(r.URL.Path) {
+		e.gateway.ServeHTTP(w, r)
+		return
+	}
+
 	e.log.Debug().
 		Str("from", r.RemoteAddr).
 		Str("uri", r.URL.RequestURI()).
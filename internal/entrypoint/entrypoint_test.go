@@ -1,17 +1,30 @@
 package entrypoint
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"golang.org/x/net/html"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/mazzz1y/router-auth-gw/internal/action"
+	"github.com/mazzz1y/router-auth-gw/internal/config"
 	"github.com/mazzz1y/router-auth-gw/internal/device"
+	"github.com/mazzz1y/router-auth-gw/internal/device/devicetest"
+	"github.com/mazzz1y/router-auth-gw/internal/metrics"
+	"github.com/mazzz1y/router-auth-gw/internal/presence"
+	"github.com/mazzz1y/router-auth-gw/internal/schedule"
+	"github.com/mazzz1y/router-auth-gw/internal/webhook"
+	"github.com/mazzz1y/router-auth-gw/pkg/keenetic"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"golang.org/x/net/websocket"
 
 	"github.com/stretchr/testify/assert"
@@ -53,6 +66,7 @@ func TestServerForwardedAuth(t *testing.T) {
 		ForwardAuthHeader:   "X-Forwarded-User",
 		OnlyGet:             true,
 		BypassAuthEndpoints: []string{"/auth-bypass"},
+		BypassUser:          "user",
 	}
 
 	server := NewEntrypoint(options)
@@ -88,6 +102,15 @@ func TestServerForwardedAuth(t *testing.T) {
 		assert.Contains(t, string(body), "mock response")
 	})
 
+	t.Run("Bypass in query string", func(t *testing.T) {
+		for _, uri := range []string{"/rci/?x=/favicon.ico", "/not-allowed?x=/auth-bypass", "/x/favicon.ico"} {
+			w := httptest.NewRecorder()
+			server.authenticateMiddleware(server.handleRequest).ServeHTTP(w, httptest.NewRequest(http.MethodGet, uri, nil))
+
+			assert.Equal(t, http.StatusUnauthorized, w.Result().StatusCode, uri)
+		}
+	})
+
 	t.Run("Unauthorized access", func(t *testing.T) {
 		req := httptest.NewRequest(http.MethodGet, "/not-allowed", nil)
 
@@ -157,6 +180,353 @@ func TestServerBasicAuth(t *testing.T) {
 	})
 }
 
+func TestServerUserSelection(t *testing.T) {
+	admin, guest := &MockClient{}, &MockClient{}
+	d := device.Device{
+		Tag: "device",
+		Users: []device.User{
+			{Name: "admin", Client: admin},
+			{Name: "guest", Client: guest},
+		},
+	}
+
+	t.Run("Bypass endpoints require bypass user", func(t *testing.T) {
+		server := NewEntrypoint(Options{
+			Device:              d,
+			BasicAuth:           map[string]string{"user": "pass"},
+			BypassAuthEndpoints: []string{"/auth-bypass"},
+		})
+		assert.Error(t, server.validate())
+	})
+
+	t.Run("Unknown user", func(t *testing.T) {
+		server := NewEntrypoint(Options{Device: d, DefaultUser: "missing"})
+		assert.Error(t, server.validate())
+	})
+
+	t.Run("Bypass and default users", func(t *testing.T) {
+		server := NewEntrypoint(Options{
+			Device:              d,
+			BasicAuth:           map[string]string{"user": "pass"},
+			BypassAuthEndpoints: []string{"/auth-bypass"},
+			BypassUser:          "guest",
+			DefaultUser:         "guest",
+		})
+		assert.NoError(t, server.validate())
+
+		client, identity, err := server.authenticate(httptest.NewRequest(http.MethodGet, "/auth-bypass", nil))
+		assert.NoError(t, err)
+		assert.Same(t, guest, client)
+		assert.Empty(t, identity)
+
+		req := httptest.NewRequest(http.MethodGet, "/behind-auth", nil)
+		req.SetBasicAuth("user", "pass")
+		client, identity, err = server.authenticate(req)
+		assert.NoError(t, err)
+		assert.Same(t, guest, client)
+		assert.Equal(t, "user", identity)
+	})
+
+	t.Run("Favicon is not bypassed without bypass user", func(t *testing.T) {
+		server := NewEntrypoint(Options{
+			Device:    d,
+			BasicAuth: map[string]string{"user": "pass"},
+		})
+
+		_, _, err := server.authenticate(httptest.NewRequest(http.MethodGet, "/favicon.ico", nil))
+		assert.Error(t, err)
+	})
+}
+
+func TestServerRCIPolicy(t *testing.T) {
+	options := Options{
+		Device: NewMockDevice(),
+		RCIPolicy: keenetic.Policy{
+			Allow: []string{"ip hotspot wake"},
+		},
+	}
+
+	server := NewEntrypoint(options)
+	handler := server.authenticateMiddleware(server.rciPolicyMiddleware(server.handleRequest))
+
+	t.Run("Allowed command", func(t *testing.T) {
+		body := strings.NewReader(`{"ip":{"hotspot":{"wake":{"mac":"00:11:22:33:44:55"}}}}`)
+		req := httptest.NewRequest(http.MethodPost, "/rci/", body)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+	})
+
+	t.Run("Denied command in batch", func(t *testing.T) {
+		body := strings.NewReader(`[{"ip":{"hotspot":{"wake":{"mac":"x"}}}},{"system":{"reboot":{}}}]`)
+		req := httptest.NewRequest(http.MethodPost, "/rci/", body)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		resp := w.Result()
+		respBody, _ := io.ReadAll(resp.Body)
+
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		assert.Contains(t, string(respBody), "system reboot")
+	})
+
+	t.Run("Read request", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/rci/show/version", nil)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+	})
+
+	t.Run("Uncleaned and mixed case paths", func(t *testing.T) {
+		client := &PrefixClient{}
+		srv := NewServer("")
+		srv.Mount("", NewEntrypoint(Options{
+			Device:    device.Device{Tag: "router", Users: []device.User{{Name: "admin", Client: client}}},
+			RCIPolicy: options.RCIPolicy,
+		}))
+
+		for _, path := range []string{"/./rci/", "//rci/", "/x/../rci/", "/RCI/", "/Rci/System"} {
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"system":{"reboot":{}}}`))
+			req.URL.Path = path
+
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusForbidden, w.Result().StatusCode, path)
+		}
+
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"System":{"Reboot":{}}}`))
+		req.URL.Path = "/rci/"
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusForbidden, w.Result().StatusCode)
+
+		req = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"ip":{"hotspot":{"wake":{"mac":"x"}}}}`))
+		req.URL.Path = "/./rci/"
+		w = httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+		assert.Equal(t, "/rci/", client.Endpoint)
+	})
+}
+
+type PrefixClient struct {
+	Endpoint string
+}
+
+func (m *PrefixClient) Request(_ context.Context, _, endpoint, _ string) (*http.Response, error) {
+	m.Endpoint = endpoint
+
+	header := make(http.Header)
+	header.Set("Content-Type", "text/html; charset=utf-8")
+	header.Set("Location", "/login")
+	header.Add("Set-Cookie", "session=1; Path=/; HttpOnly")
+
+	body := `<html><head><link rel="stylesheet" href="/style.css"></head>` +
+		`<body><a href="//cdn.example.com/x">cdn</a><script src="/app.js"></script></body></html>`
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Header:     header,
+	}, nil
+}
+
+func (m *PrefixClient) Websocket() (*websocket.Conn, error) {
+	return nil, errors.New("not implemented")
+}
+
+func TestServerMounts(t *testing.T) {
+	home, office := &PrefixClient{}, &PrefixClient{}
+
+	server := NewServer("")
+	server.Mount("/home/", NewEntrypoint(Options{
+		Device: device.Device{Tag: "home", Users: []device.User{{Name: "admin", Client: home}}},
+	}))
+	server.Mount("/office", NewEntrypoint(Options{
+		Device: device.Device{Tag: "office", Users: []device.User{{Name: "admin", Client: office}}},
+	}))
+
+	t.Run("Prefix is stripped", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/office/rci/show/version?a=1", nil))
+
+		assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+		assert.Equal(t, "/rci/show/version?a=1", office.Endpoint)
+	})
+
+	t.Run("Response is rewritten", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/home/", nil))
+
+		resp := w.Result()
+		body, _ := io.ReadAll(resp.Body)
+
+		assert.Equal(t, "/home/login", resp.Header.Get("Location"))
+		assert.Equal(t, "session=1; Path=/home/; HttpOnly", resp.Header.Get("Set-Cookie"))
+		assert.Contains(t, string(body), `href="/home/style.css"`)
+		assert.Contains(t, string(body), `src="/home/app.js"`)
+		assert.Contains(t, string(body), `href="//cdn.example.com/x"`)
+	})
+
+	t.Run("Prefix without trailing slash", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/home", nil))
+
+		assert.Equal(t, http.StatusMovedPermanently, w.Result().StatusCode)
+		assert.Equal(t, "/home/", w.Result().Header.Get("Location"))
+	})
+
+	t.Run("Absolute request is not routed by referer", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/rci/show/system", nil)
+		req.Header.Set("Referer", "http://example.com/home/dashboard")
+
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Result().StatusCode)
+	})
+
+	t.Run("Unknown prefix", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/unknown/", nil))
+
+		assert.Equal(t, http.StatusNotFound, w.Result().StatusCode)
+	})
+}
+
+func TestServerHosts(t *testing.T) {
+	newEntrypoint := func(tag string, client device.ClientWrapper, basicAuth map[string]string) *Entrypoint {
+		return NewEntrypoint(Options{
+			Device:    device.Device{Tag: tag, Users: []device.User{{Name: "admin", Client: client}}},
+			BasicAuth: basicAuth,
+		})
+	}
+
+	fallback, exact, home, office := &PrefixClient{}, &PrefixClient{}, &PrefixClient{}, &PrefixClient{}
+
+	server := NewServer("")
+	server.Mount("/", newEntrypoint("fallback", fallback, nil))
+	server.Host("router.example.com").Mount("/", newEntrypoint("exact", exact, map[string]string{"user": "pass"}))
+	wildcard := server.Host("*.routers.example.com")
+	wildcard.Subdomain("home").Mount("/", newEntrypoint("home", home, nil))
+	wildcard.Subdomain("office").Mount("/", newEntrypoint("office", office, nil))
+
+	serve := func(host, path string) *http.Response {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Host = host
+
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		return w.Result()
+	}
+
+	t.Run("Exact host with its own auth", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, serve("router.example.com:8080", "/exact").StatusCode)
+		assert.Equal(t, "", exact.Endpoint)
+	})
+
+	t.Run("Wildcard host selects device by subdomain", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serve("Office.Routers.Example.com", "/office").StatusCode)
+		assert.Equal(t, "/office", office.Endpoint)
+		assert.Equal(t, "", home.Endpoint)
+	})
+
+	t.Run("Unknown subdomain", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, serve("lab.routers.example.com", "/").StatusCode)
+	})
+
+	t.Run("Nested subdomain is not matched", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serve("a.home.routers.example.com", "/fallback").StatusCode)
+		assert.Equal(t, "/fallback", fallback.Endpoint)
+	})
+}
+
+func TestServerReplace(t *testing.T) {
+	first, second := &PrefixClient{}, &PrefixClient{}
+
+	server := NewServer("")
+	server.Mount("/", NewEntrypoint(Options{
+		Device: device.Device{Users: []device.User{{Name: "admin", Client: first}}},
+	}))
+
+	next := NewServer("")
+	next.Mount("/", NewEntrypoint(Options{
+		Device:    device.Device{Users: []device.User{{Name: "admin", Client: second}}},
+		BasicAuth: map[string]string{"user": "pass"},
+	}))
+	assert.NoError(t, server.Replace(next))
+
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/page", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Result().StatusCode)
+
+	req := httptest.NewRequest(http.MethodGet, "/page", nil)
+	req.SetBasicAuth("user", "pass")
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Result().StatusCode)
+	assert.Equal(t, "", first.Endpoint)
+	assert.Equal(t, "/page", second.Endpoint)
+
+	invalid := NewServer("")
+	invalid.Mount("/", NewEntrypoint(Options{
+		Device:              device.Device{Users: []device.User{{Name: "admin", Client: first}}},
+		BypassAuthEndpoints: []string{"/public"},
+	}))
+	assert.Error(t, server.Replace(invalid))
+
+	// Drained routes are dropped by the next reload.
+	assert.Len(t, server.retired, 1)
+	assert.NoError(t, server.Replace(NewServer("")))
+	assert.Equal(t, []*Server{next}, server.retired)
+}
+
+type WSClient struct {
+	MockClient
+	URL string
+}
+
+func (m *WSClient) Websocket() (*websocket.Conn, error) {
+	return websocket.Dial(m.URL, "", "http://localhost")
+}
+
+func TestServerShutdownClosesWebsockets(t *testing.T) {
+	upstream := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+		io.Copy(ws, ws)
+	}))
+	defer upstream.Close()
+
+	server := NewServer("")
+	server.Mount("/", NewEntrypoint(Options{
+		Device: device.Device{Users: []device.User{
+			{Name: "user", Client: &WSClient{URL: "ws" + strings.TrimPrefix(upstream.URL, "http")}},
+		}},
+	}))
+
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	ws, err := websocket.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/ws", "", ts.URL)
+	assert.NoError(t, err)
+	defer ws.Close()
+
+	assert.NoError(t, websocket.Message.Send(ws, "ping"))
+	var msg string
+	assert.NoError(t, websocket.Message.Receive(ws, &msg))
+	assert.Equal(t, "ping", msg)
+
+	assert.NoError(t, server.Shutdown(context.Background()))
+
+	assert.Equal(t, io.EOF, websocket.Message.Receive(ws, &msg))
+}
+
 func TestUpdateManifestLinks(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -240,16 +610,11 @@ func TestUpdateManifestLinks(t *testing.T) {
 
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			reader := io.NopCloser(strings.NewReader(test.htmlInput))
-			output, err := manifestFix(reader)
-			if err != nil {
-				t.Fatalf("Expected no error, got %v", err)
-			}
-
-			parsedOutput, err := html.Parse(strings.NewReader(string(output)))
+			parsedOutput, err := html.Parse(strings.NewReader(test.htmlInput))
 			if err != nil {
-				t.Fatalf("Error parsing output HTML: %v", err)
+				t.Fatalf("Error parsing input HTML: %v", err)
 			}
+			fixManifestLink(parsedOutput)
 
 			parsedExpectedOutput, err := html.Parse(strings.NewReader(test.expectedOutput))
 			if err != nil {
@@ -266,3 +631,361 @@ func TestUpdateManifestLinks(t *testing.T) {
 		})
 	}
 }
+
+func TestServerAPI(t *testing.T) {
+	client := &devicetest.Client{
+		Info:    device.Identity{Model: "mock"},
+		Clients: []device.Host{{MAC: "aa:bb:cc:dd:ee:ff", IP: "192.168.1.10", Hostname: "pc", Online: true}},
+	}
+	options := Options{
+		Device:  device.Device{Users: []device.User{{Name: "user", Client: client}}},
+		API:     true,
+		OnlyGet: true,
+	}
+	server := NewEntrypoint(options)
+
+	serve := func(e *Entrypoint, method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+
+	t.Run("Clients", func(t *testing.T) {
+		w := serve(server, http.MethodGet, "/_gw/api/v1/clients", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"clients":[{"mac":"aa:bb:cc:dd:ee:ff","ip":"192.168.1.10","hostname":"pc","online":true}]}`, w.Body.String())
+	})
+
+	t.Run("Not supported", func(t *testing.T) {
+		w := serve(server, http.MethodGet, "/_gw/api/v1/wan", "")
+		assert.Equal(t, http.StatusNotImplemented, w.Code)
+	})
+
+	t.Run("Read only", func(t *testing.T) {
+		w := serve(server, http.MethodPost, "/_gw/api/v1/reboot", "")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	options.OnlyGet = false
+	server = NewEntrypoint(options)
+
+	t.Run("Wake", func(t *testing.T) {
+		w := serve(server, http.MethodPost, "/_gw/api/v1/wol", `{"mac":"AA-BB-CC-DD-EE-FF"}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, []string{"aa:bb:cc:dd:ee:ff"}, client.Woken())
+
+		w = serve(server, http.MethodPost, "/_gw/api/v1/wol", `{"mac":"pc"}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("RCI policy", func(t *testing.T) {
+		policy := options
+		policy.RCIPolicy = keenetic.Policy{Deny: []string{"system reboot", "ip hotspot"}}
+		e := NewEntrypoint(policy)
+
+		w := serve(e, http.MethodPost, "/_gw/api/v1/reboot", "")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), "system reboot")
+
+		w = serve(e, http.MethodPost, "/_gw/api/v1/wol", `{"mac":"aa:bb:cc:dd:ee:ff"}`)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Equal(t, []string{"aa:bb:cc:dd:ee:ff"}, client.Woken())
+	})
+
+	t.Run("Wrong method", func(t *testing.T) {
+		w := serve(server, http.MethodGet, "/_gw/api/v1/reboot", "")
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	})
+
+	t.Run("Client without driver", func(t *testing.T) {
+		options.Device = NewMockDevice()
+		w := serve(NewEntrypoint(options), http.MethodGet, "/_gw/api/v1/clients", "")
+		assert.Equal(t, http.StatusNotImplemented, w.Code)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		options.API = false
+		w := serve(NewEntrypoint(options), http.MethodGet, "/_gw/api/v1/clients", "")
+		assert.Equal(t, "mock response", w.Body.String())
+	})
+}
+
+func TestServerWOL(t *testing.T) {
+	client := &devicetest.Client{}
+	server := NewEntrypoint(Options{
+		Device:            device.Device{Users: []device.User{{Name: "user", Client: client}}},
+		ForwardAuthHeader: "X-Forwarded-User",
+		ForwardAuthMapping: map[string]string{
+			"alice": "user",
+			"bob":   "user",
+		},
+		WOL: true,
+		WOLHosts: map[string]WOLHost{
+			"office-pc": {MAC: "aa:bb:cc:dd:ee:01", Users: []string{"alice"}},
+			"nas":       {MAC: "aa:bb:cc:dd:ee:02"},
+		},
+	})
+
+	wake := func(user, path, body string) int {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("X-Forwarded-User", user)
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, wake("alice", "/_gw/wol", `{"host":"office-pc"}`))
+	assert.Equal(t, http.StatusForbidden, wake("bob", "/_gw/wol", `{"host":"office-pc"}`))
+	assert.Equal(t, http.StatusForbidden, wake("bob", "/_gw/wol", `{"host":"AA-BB-CC-DD-EE-01"}`))
+	assert.Equal(t, http.StatusOK, wake("bob", "/_gw/wol?host=nas", ""))
+	assert.Equal(t, http.StatusNotFound, wake("bob", "/_gw/wol", `{"host":"aa:bb:cc:dd:ee:03"}`))
+	assert.Equal(t, []string{"aa:bb:cc:dd:ee:01", "aa:bb:cc:dd:ee:02"}, client.Woken())
+
+	t.Run("API respects hosts", func(t *testing.T) {
+		server.Options.API = true
+		server = NewEntrypoint(server.Options)
+		defer func() {
+			server.Options.API = false
+			server = NewEntrypoint(server.Options)
+		}()
+
+		assert.Equal(t, http.StatusForbidden, wake("bob", "/_gw/api/v1/wol", `{"mac":"aa:bb:cc:dd:ee:01"}`))
+		assert.Equal(t, http.StatusForbidden, wake("bob", "/_gw/api/v1/wol", `{"mac":"aa:bb:cc:dd:ee:03"}`))
+		assert.Equal(t, http.StatusOK, wake("alice", "/_gw/api/v1/wol", `{"mac":"AA:BB:CC:DD:EE:01"}`))
+		assert.Equal(t, []string{"aa:bb:cc:dd:ee:01", "aa:bb:cc:dd:ee:02", "aa:bb:cc:dd:ee:01"}, client.Woken())
+	})
+
+	t.Run("Other gateway paths", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/_gw/api/v1/clients", nil)
+		req.Header.Set("X-Forwarded-User", "alice")
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+func TestServerActions(t *testing.T) {
+	client := &PrefixClient{}
+	dm := &device.Manager{Devices: map[string]device.Device{
+		"router": {Tag: "router", Users: []device.User{{Name: "user", Client: client}}},
+	}}
+	actions, err := action.New(map[string]config.ActionConfig{
+		"wake": {
+			DeviceTag: "router",
+			Path:      "/rci/ip/hotspot/wake",
+			Body:      `{"mac": "{{ .mac }}"}`,
+			Params:    map[string]config.ActionParamConfig{"mac": {Required: true}},
+			Users:     []string{"user"},
+		},
+	}, dm)
+	assert.NoError(t, err)
+
+	server := NewEntrypoint(Options{
+		Device:    dm.Devices["router"],
+		BasicAuth: map[string]string{"user": "pass", "guest": "pass"},
+		Actions:   actions,
+	})
+
+	run := func(user, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.SetBasicAuth(user, "pass")
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, req)
+		return w
+	}
+
+	w := run("user", "/_gw/actions/wake", `{"mac":"aa:bb:cc:dd:ee:ff"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "/rci/ip/hotspot/wake", client.Endpoint)
+
+	assert.Equal(t, http.StatusForbidden, run("guest", "/_gw/actions/wake", `{"mac":"aa:bb:cc:dd:ee:ff"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, run("user", "/_gw/actions/wake", `{}`).Code)
+	assert.Equal(t, http.StatusBadRequest, run("user", "/_gw/actions/wake", `[]`).Code)
+	assert.Equal(t, http.StatusNotFound, run("user", "/_gw/actions/reboot", ``).Code)
+
+	t.Run("RCI policy", func(t *testing.T) {
+		server.Options.RCIPolicy = keenetic.Policy{Deny: []string{"ip hotspot"}}
+		server = NewEntrypoint(server.Options)
+		client.Endpoint = ""
+
+		w := run("user", "/_gw/actions/wake", `{"mac":"aa:bb:cc:dd:ee:ff"}`)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), "ip hotspot wake")
+		assert.Empty(t, client.Endpoint)
+	})
+}
+
+func TestServerSchedules(t *testing.T) {
+	dm := &device.Manager{Devices: map[string]device.Device{
+		"router": {Tag: "router", Users: []device.User{{Name: "user", Client: &PrefixClient{}}}},
+		"ap":     {Tag: "ap", Users: []device.User{{Name: "user", Client: &PrefixClient{}}}},
+	}}
+	scheduler, err := schedule.New(map[string]config.ScheduleConfig{
+		"reboot":    {Cron: "0 4 * * *", DeviceTag: "router", Path: "/rci/system/reboot"},
+		"ap-reboot": {Cron: "0 5 * * *", DeviceTag: "ap", Path: "/rci/system/reboot"},
+	}, "", dm, nil)
+	assert.NoError(t, err)
+
+	server := NewEntrypoint(Options{
+		Device:    dm.Devices["router"],
+		BasicAuth: map[string]string{"user": "pass"},
+		Schedules: scheduler,
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/_gw/api/schedules", nil)
+	req.SetBasicAuth("user", "pass")
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"schedules":[{"name":"reboot","cron":"0 4 * * *","device":"router","request":"POST /rci/system/reboot","running":false}]}`, w.Body.String())
+}
+
+func TestServerEvents(t *testing.T) {
+	client := &devicetest.Client{Clients: []device.Host{}}
+	dm := &device.Manager{Devices: map[string]device.Device{
+		"router": {Tag: "router", Users: []device.User{{Name: "user", Client: client}}},
+	}}
+	poller, err := presence.New(config.PresenceConfig{Enabled: true, Interval: 10 * time.Millisecond}, dm, nil)
+	assert.NoError(t, err)
+
+	server := httptest.NewServer(NewEntrypoint(Options{
+		Device:    dm.Devices["router"],
+		BasicAuth: map[string]string{"user": "pass"},
+		Presence:  poller,
+	}))
+	defer server.Close()
+
+	poller.Start(context.Background())
+	defer poller.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/_gw/events", nil)
+	req.SetBasicAuth("user", "pass")
+	res, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+	client.SetClients([]device.Host{{MAC: "aa:bb:cc:dd:ee:ff", IP: "192.168.1.10", Hostname: "pc", Online: true}})
+
+	scanner := bufio.NewScanner(res.Body)
+	assert.True(t, scanner.Scan())
+	assert.Equal(t, "event: host_join", scanner.Text())
+	assert.True(t, scanner.Scan())
+	assert.Contains(t, scanner.Text(), `"mac":"aa:bb:cc:dd:ee:ff"`)
+}
+
+type FailingClient struct {
+	MockClient
+	err error
+}
+
+func (m *FailingClient) Request(_ context.Context, _, _, _ string) (*http.Response, error) {
+	return nil, m.err
+}
+
+func TestServerWebhooks(t *testing.T) {
+	received := make(chan webhook.Payload, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p webhook.Payload
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&p))
+		received <- p
+	}))
+	defer receiver.Close()
+
+	hooks, err := webhook.NewDispatcher(config.WebhooksConfig{Endpoints: []config.WebhookConfig{{URL: receiver.URL}}})
+	assert.NoError(t, err)
+	hooks.Start(context.Background())
+	defer hooks.Stop()
+
+	client := &FailingClient{err: fmt.Errorf("login: %w", keenetic.ErrAuthFailed)}
+	server := NewEntrypoint(Options{
+		Device:           device.Device{Tag: "router", Users: []device.User{{Name: "user", Client: client}}},
+		ListenAddr:       ":8080",
+		BasicAuth:        map[string]string{"user": "pass"},
+		AllowedEndpoints: []string{"/allowed"},
+		Webhooks:         hooks,
+	})
+
+	request := func(path, pass string) {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.SetBasicAuth("user", pass)
+		server.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	next := func() webhook.Payload {
+		select {
+		case p := <-received:
+			return p
+		case <-time.After(5 * time.Second):
+			t.Fatal("webhook not received")
+			return webhook.Payload{}
+		}
+	}
+
+	request("/allowed", "wrong")
+	request("/allowed", "wrong")
+	p := next()
+	assert.Equal(t, webhook.EventAuthFailed, p.Event)
+	assert.Equal(t, "router", p.Device)
+	assert.Equal(t, map[string]any{
+		"entrypoint": ":8080",
+		"from":       "192.0.2.1:1234",
+		"method":     "GET",
+		"uri":        "/allowed",
+		"error":      "invalid password for user: user",
+	}, p.Data)
+
+	// Failures are limited per client address.
+	req := httptest.NewRequest(http.MethodGet, "/allowed", nil)
+	req.RemoteAddr = "192.0.2.2:1234"
+	req.SetBasicAuth("user", "wrong")
+	server.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, "192.0.2.2:1234", next().Data.(map[string]any)["from"])
+
+	request("/denied", "pass")
+	request("/denied", "pass")
+	p = next()
+	assert.Equal(t, webhook.EventRequestDenied, p.Event)
+	assert.Equal(t, "user", p.Data.(map[string]any)["user"])
+
+	request("/allowed", "pass")
+	request("/allowed", "pass")
+	assert.Equal(t, webhook.EventDeviceLoginFailed, next().Event)
+
+	client.err = errors.New("connection refused")
+	request("/allowed", "pass")
+	assert.Equal(t, webhook.EventDeviceUnreachable, next().Event)
+
+	assert.Empty(t, received)
+}
+
+func TestServerMetrics(t *testing.T) {
+	client := &FailingClient{err: fmt.Errorf("login: %w", keenetic.ErrAuthFailed)}
+	server := NewEntrypoint(Options{
+		Device:     device.Device{Tag: "metrics", Users: []device.User{{Name: "user", Client: client}}},
+		ListenAddr: ":9090",
+		BasicAuth:  map[string]string{"user": "pass"},
+	})
+
+	request := func(pass string) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.SetBasicAuth("user", pass)
+		server.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	request("wrong")
+	request("pass")
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.Auth.WithLabelValues(":9090", "metrics", metrics.AuthFailure)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.Auth.WithLabelValues(":9090", "metrics", metrics.AuthSuccess)))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.Requests.WithLabelValues(":9090", "metrics", "", "GET", "401")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.Requests.WithLabelValues(":9090", "metrics", "user", "GET", "502")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.UpstreamErrors.WithLabelValues(":9090", "metrics", device.ErrorClassLogin)))
+
+	for i := 0; i < 10; i++ {
+		server.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(fmt.Sprintf("X%d", i), "/", nil))
+	}
+	assert.Equal(t, 10.0, testutil.ToFloat64(metrics.Requests.WithLabelValues(":9090", "metrics", "", "other", "401")))
+}
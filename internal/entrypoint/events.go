@@ -0,0 +1,55 @@
+package entrypoint
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const eventsKeepAlive = 30 * time.Second
+
+// events streams the presence events of the entrypoint device as
+// server-sent events. The stream ends when the poller stops, e.g. on reload,
+// and clients are expected to reconnect.
+func (e *Entrypoint) events(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming is not supported"))
+		return
+	}
+
+	events, unsubscribe := e.Options.Presence.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	e.log.Debug().Str("from", r.RemoteAddr).Msg("events stream opened")
+	defer e.log.Debug().Str("from", r.RemoteAddr).Msg("events stream closed")
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			if ev.Device != e.Options.Device.Tag {
+				continue
+			}
+			data, _ := json.Marshal(ev)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Event, data)
+		}
+		flusher.Flush()
+	}
+}
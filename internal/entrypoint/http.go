@@ -4,15 +4,37 @@ import (
 	"bytes"
 	"context"
 	"io"
+	"mime"
 	"net/http"
+	"regexp"
 	"strings"
 	"time"
 
 	"github.com/mazzz1y/router-auth-gw/internal/device"
+	"github.com/mazzz1y/router-auth-gw/internal/metrics"
+	"github.com/mazzz1y/router-auth-gw/internal/webhook"
 	"golang.org/x/net/html"
 )
 
-const timeout = 30 * time.Second
+const (
+	timeout = 30 * time.Second
+	// deviceEventInterval limits the device webhook events, which would
+	// otherwise fire for every request while the device is down.
+	deviceEventInterval = 5 * time.Minute
+	// clientEventInterval limits the auth_failed and request_denied events
+	// per client address, e.g. of a client guessing passwords.
+	clientEventInterval = time.Minute
+)
+
+var (
+	cookiePathRegexp = regexp.MustCompile(`(?i)(;\s*path=)([^;]*)`)
+	linkAttributes   = map[string]struct{}{
+		"href":       {},
+		"src":        {},
+		"action":     {},
+		"formaction": {},
+	}
+)
 
 func (e *Entrypoint) httpRequest(w http.ResponseWriter, r *http.Request, c device.ClientWrapper) {
 	uri := r.URL.RequestURI()
@@ -31,6 +53,7 @@ func (e *Entrypoint) httpRequest(w http.ResponseWriter, r *http.Request, c devic
 	if err != nil {
 		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
 		e.log.Error().Err(err).Str("uri", uri).Msg("request to backend failed")
+		e.deviceFailed(r, err)
 		return
 	}
 	defer resp.Body.Close()
@@ -41,13 +64,30 @@ func (e *Entrypoint) httpRequest(w http.ResponseWriter, r *http.Request, c devic
 	}
 }
 
+// deviceFailed counts a failed device request and emits its webhook event.
+// Requests canceled by the client are not the fault of the device.
+func (e *Entrypoint) deviceFailed(r *http.Request, err error) {
+	if r.Context().Err() != nil {
+		return
+	}
+
+	class := device.ErrorClass(err)
+	metrics.UpstreamErrors.WithLabelValues(e.Options.ListenAddr, e.Options.Device.Tag, class).Inc()
+
+	event := webhook.EventDeviceUnreachable
+	if class == device.ErrorClassLogin {
+		event = webhook.EventDeviceLoginFailed
+	}
+	e.Options.Webhooks.EmitLimited(event, e.Options.Device.Tag, "", e.requestEvent(r, err), deviceEventInterval)
+}
+
 func (e *Entrypoint) forwardResponse(resp *http.Response, w http.ResponseWriter) error {
 	for key, values := range resp.Header {
 		if strings.EqualFold(key, "Host") || strings.EqualFold(key, "Content-Length") {
 			continue
 		}
 		for _, value := range values {
-			w.Header().Add(key, value)
+			w.Header().Add(key, e.rewriteHeader(key, value))
 		}
 	}
 
@@ -62,31 +102,84 @@ func (e *Entrypoint) forwardResponse(resp *http.Response, w http.ResponseWriter)
 }
 
 func (e *Entrypoint) processBody(resp *http.Response) ([]byte, error) {
-	if e.isAuthEnabled() && resp.Header.Get("Content-Type") == "text/html" {
-		return manifestFix(resp.Body)
+	fixManifest := e.isAuthEnabled() && resp.Header.Get("Content-Type") == "text/html"
+	addPrefix := e.Options.Prefix != "" && isHtml(resp)
+	if !fixManifest && !addPrefix {
+		return io.ReadAll(resp.Body)
 	}
-	return io.ReadAll(resp.Body)
-}
 
-// Enable the HTTP crossorigin attribute to allow cookies and headers for manifest requests when the service is behind authentication.
-// This prevents 401 errors, non-functional PWAs, and CSRF issues with forward authentication.
-func manifestFix(r io.Reader) ([]byte, error) {
-	doc, err := html.Parse(r)
+	doc, err := html.Parse(resp.Body)
 	if err != nil {
 		return nil, err
 	}
 
+	if fixManifest {
+		fixManifestLink(doc)
+	}
+	if addPrefix {
+		prefixLinks(doc, e.Options.Prefix)
+	}
+
+	return renderHtml(doc)
+}
+
+func (e *Entrypoint) rewriteHeader(key, value string) string {
+	if e.Options.Prefix == "" {
+		return value
+	}
+
+	switch {
+	case strings.EqualFold(key, "Location"):
+		return prefixPath(e.Options.Prefix, value)
+	case strings.EqualFold(key, "Set-Cookie"):
+		return cookiePathRegexp.ReplaceAllStringFunc(value, func(attr string) string {
+			m := cookiePathRegexp.FindStringSubmatch(attr)
+			return m[1] + prefixPath(e.Options.Prefix, m[2])
+		})
+	}
+
+	return value
+}
+
+// fixManifestLink enables the HTTP crossorigin attribute to allow cookies and headers for manifest requests when the service is behind authentication.
+// This prevents 401 errors, non-functional PWAs, and CSRF issues with forward authentication.
+func fixManifestLink(doc *html.Node) bool {
 	htmlNode := findHtmlElement(doc, "html")
 	if htmlNode != nil {
 		headNode := findHtmlElement(htmlNode, "head")
 		if headNode != nil {
-			if modifyManifestLink(headNode) {
-				return renderHtml(doc)
+			return modifyManifestLink(headNode)
+		}
+	}
+	return false
+}
+
+// Add the mount prefix to absolute links, so the device UI keeps working under a sub-path.
+func prefixLinks(n *html.Node, prefix string) {
+	if n.Type == html.ElementNode {
+		for i, attr := range n.Attr {
+			if _, ok := linkAttributes[attr.Key]; ok {
+				n.Attr[i].Val = prefixPath(prefix, attr.Val)
 			}
 		}
 	}
 
-	return renderHtml(doc)
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		prefixLinks(c, prefix)
+	}
+}
+
+func prefixPath(prefix, path string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") ||
+		path == prefix || strings.HasPrefix(path, prefix+"/") {
+		return path
+	}
+	return prefix + path
+}
+
+func isHtml(resp *http.Response) bool {
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return err == nil && mediaType == "text/html"
 }
 
 func findHtmlElement(n *html.Node, tagName string) *html.Node {
@@ -0,0 +1,104 @@
+package entrypoint
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mazzz1y/router-auth-gw/internal/metrics"
+)
+
+const recorderContextKey = contextKey("recorder")
+
+// metricsRecorder captures the response status and the authenticated user
+// of a request for the metrics.
+type metricsRecorder struct {
+	http.ResponseWriter
+	status int
+	user   string
+}
+
+func (rec *metricsRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *metricsRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+func (rec *metricsRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack is used by WebSocket upgrades.
+func (rec *metricsRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := rec.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijacking is not supported")
+	}
+	if rec.status == 0 {
+		rec.status = http.StatusSwitchingProtocols
+	}
+	return h.Hijack()
+}
+
+func (rec *metricsRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+func (e *Entrypoint) metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &metricsRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), recorderContextKey, rec)))
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		method, status := methodLabel(r.Method), strconv.Itoa(rec.status)
+		metrics.Requests.WithLabelValues(e.Options.ListenAddr, e.Options.Device.Tag, rec.user, method, status).Inc()
+		metrics.RequestDuration.WithLabelValues(e.Options.ListenAddr, e.Options.Device.Tag, method, status).
+			Observe(time.Since(start).Seconds())
+	})
+}
+
+// methodLabel returns the method of a request for the labels. Methods are
+// sent by unauthenticated clients, so unknown ones are grouped as "other" to
+// bound the number of series.
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "other"
+}
+
+// recordAuth counts the authentication outcome and labels the request
+// metrics with the user.
+func (e *Entrypoint) recordAuth(r *http.Request, identity string, err error) {
+	result := metrics.AuthSuccess
+	switch {
+	case err != nil:
+		result = metrics.AuthFailure
+	case identity == "":
+		result = metrics.AuthAnonymous
+	}
+	metrics.Auth.WithLabelValues(e.Options.ListenAddr, e.Options.Device.Tag, result).Inc()
+
+	if rec, ok := r.Context().Value(recorderContextKey).(*metricsRecorder); ok {
+		rec.user = identity
+	}
+}
@@ -1,28 +1,35 @@
 package entrypoint
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
-	"net/url"
-	"strings"
 
 	"github.com/mazzz1y/router-auth-gw/internal/device"
+	"github.com/mazzz1y/router-auth-gw/internal/webhook"
+	"github.com/mazzz1y/router-auth-gw/pkg/keenetic"
 )
 
 func (e *Entrypoint) authenticateMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		client, err := e.authenticate(r)
+		client, identity, err := e.authenticate(r)
+		e.recordAuth(r, identity, err)
 		if err != nil {
 			e.log.Warn().
 				Err(err).
 				Str("from", r.RemoteAddr).
 				Str("uri", r.URL.RequestURI()).
 				Msg("authentication failed")
+			e.Options.Webhooks.EmitLimited(webhook.EventAuthFailed, e.Options.Device.Tag, remoteIP(r), e.requestEvent(r, err), clientEventInterval)
 			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 			return
 		}
 		ctx := context.WithValue(r.Context(), clientContextKey, client)
+		ctx = context.WithValue(ctx, identityContextKey, identity)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	}
 }
@@ -34,16 +41,16 @@ func (e *Entrypoint) reqAllowedMiddleware(next http.HandlerFunc) http.HandlerFun
 			err = fmt.Errorf("method not allowed")
 		}
 
-		uri := r.URL.RequestURI()
-		if len(e.Options.AllowedEndpoints) > 0 && !isURIInSlice(e.Options.AllowedEndpoints, uri) {
+		if len(e.Options.AllowedEndpoints) > 0 && !isPathInSlice(e.Options.AllowedEndpoints, r.URL.Path) {
 			err = fmt.Errorf("uri not allowed")
 		}
 
 		if err != nil {
 			e.log.Info().
 				Str("from", r.RemoteAddr).
-				Str("uri", uri).
+				Str("uri", r.URL.RequestURI()).
 				Msg("request not allowed")
+			e.Options.Webhooks.EmitLimited(webhook.EventRequestDenied, e.Options.Device.Tag, remoteIP(r), e.requestEvent(r, err), clientEventInterval)
 			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
 			return
 		}
@@ -51,63 +58,135 @@ func (e *Entrypoint) reqAllowedMiddleware(next http.HandlerFunc) http.HandlerFun
 	}
 }
 
-func (e *Entrypoint) authenticate(r *http.Request) (device.ClientWrapper, error) {
-	uri := r.URL.RequestURI()
+// remoteIP is the address of the client without the port, the client events
+// are limited per address.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// requestEvent is the webhook data of the events of a request.
+type requestEvent struct {
+	Entrypoint string `json:"entrypoint"`
+	From       string `json:"from"`
+	Method     string `json:"method"`
+	URI        string `json:"uri"`
+	User       string `json:"user,omitempty"`
+	Error      string `json:"error"`
+}
+
+func (e *Entrypoint) requestEvent(r *http.Request, err error) requestEvent {
+	identity, _ := r.Context().Value(identityContextKey).(string)
+	return requestEvent{
+		Entrypoint: e.Options.ListenAddr,
+		From:       r.RemoteAddr,
+		Method:     r.Method,
+		URI:        r.URL.RequestURI(),
+		User:       identity,
+		Error:      err.Error(),
+	}
+}
+
+func (e *Entrypoint) rciPolicyMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if e.Options.RCIPolicy.IsEmpty() || !isWriteMethod(r.Method) || !keenetic.IsRCIPath(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			e.log.Error().Err(err).Str("uri", r.URL.RequestURI()).Msg("failed to read request body")
+			return
+		}
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		if err := e.Options.RCIPolicy.Check(r.URL.Path, body); err != nil {
+			e.log.Info().
+				Err(err).
+				Str("from", r.RemoteAddr).
+				Str("uri", r.URL.RequestURI()).
+				Msg("rci command not allowed")
+
+			var cmdErr *keenetic.CommandError
+			if errors.As(err, &cmdErr) {
+				http.Error(w, cmdErr.Error(), http.StatusForbidden)
+			} else {
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			}
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
 
-	bypass := (len(e.Options.BypassAuthEndpoints) > 0 && isURIInSlice(e.Options.BypassAuthEndpoints, uri)) ||
-		isURIBypassed(uri)
+// authenticate returns the device client for the request and the name of
+// the authenticated user, which is empty for bypassed and anonymous requests.
+func (e *Entrypoint) authenticate(r *http.Request) (device.ClientWrapper, string, error) {
+	bypass := isPathInSlice(e.Options.BypassAuthEndpoints, r.URL.Path) || isPathBypassed(r.URL.Path)
 
-	if bypass {
-		return e.Options.Device.Users[0].Client, nil
+	if bypass && e.Options.BypassUser != "" {
+		if client, ok := e.deviceUser(e.Options.BypassUser); ok {
+			return client, "", nil
+		}
 	}
 
 	if e.Options.ForwardAuthHeader != "" {
 		return e.forwardAuth(r)
 	}
 
+	var identity string
 	if len(e.Options.BasicAuth) > 0 {
-		if err := e.basicAuth(r); err != nil {
-			return nil, err
+		user, err := e.basicAuth(r)
+		if err != nil {
+			return nil, "", err
 		}
+		identity = user
 	}
 
-	if len(e.Options.Device.Users) > 0 {
-		return e.Options.Device.Users[0].Client, nil
+	if client, ok := e.defaultUser(); ok {
+		return client, identity, nil
 	}
 
-	return nil, fmt.Errorf("no valid authentication method found")
+	return nil, "", fmt.Errorf("no valid authentication method found")
 }
 
-func (e *Entrypoint) forwardAuth(r *http.Request) (device.ClientWrapper, error) {
+func (e *Entrypoint) forwardAuth(r *http.Request) (device.ClientWrapper, string, error) {
 	user := r.Header.Get(e.Options.ForwardAuthHeader)
 	if user == "" {
-		return nil, fmt.Errorf("missing forward auth header: %s", e.Options.ForwardAuthHeader)
+		return nil, "", fmt.Errorf("missing forward auth header: %s", e.Options.ForwardAuthHeader)
 	}
 
 	client, ok := e.client(user)
 	if !ok {
-		return nil, fmt.Errorf("user not found for forward auth header: %s", user)
+		return nil, "", fmt.Errorf("user not found for forward auth header: %s", user)
 	}
 
-	return client, nil
+	return client, user, nil
 }
 
-func (e *Entrypoint) basicAuth(r *http.Request) error {
+func (e *Entrypoint) basicAuth(r *http.Request) (string, error) {
 	user, pass, ok := r.BasicAuth()
 	if !ok {
-		return fmt.Errorf("basic auth credentials not provided")
+		return "", fmt.Errorf("basic auth credentials not provided")
 	}
 
 	storedPass, exists := e.Options.BasicAuth[user]
 	if !exists {
-		return fmt.Errorf("basic auth user not found: %s", user)
+		return "", fmt.Errorf("basic auth user not found: %s", user)
 	}
 
 	if storedPass != pass {
-		return fmt.Errorf("invalid password for user: %s", user)
+		return "", fmt.Errorf("invalid password for user: %s", user)
 	}
 
-	return nil
+	return user, nil
 }
 
 func (e *Entrypoint) client(name string) (device.ClientWrapper, bool) {
@@ -115,6 +194,22 @@ func (e *Entrypoint) client(name string) (device.ClientWrapper, bool) {
 		name = e.Options.ForwardAuthMapping[name]
 	}
 
+	return e.deviceUser(name)
+}
+
+func (e *Entrypoint) defaultUser() (device.ClientWrapper, bool) {
+	if e.Options.DefaultUser != "" {
+		return e.deviceUser(e.Options.DefaultUser)
+	}
+
+	if len(e.Options.Device.Users) > 0 {
+		return e.Options.Device.Users[0].Client, true
+	}
+
+	return nil, false
+}
+
+func (e *Entrypoint) deviceUser(name string) (device.ClientWrapper, bool) {
 	for _, user := range e.Options.Device.Users {
 		if user.Name == name {
 			return user.Client, true
@@ -124,18 +219,19 @@ func (e *Entrypoint) client(name string) (device.ClientWrapper, bool) {
 	return nil, false
 }
 
-func isURIBypassed(uri string) bool {
-	return strings.HasSuffix(uri, "/favicon.ico")
+func isWriteMethod(method string) bool {
+	return method != http.MethodGet && method != http.MethodHead && method != http.MethodOptions
 }
 
-func isURIInSlice(endpoints []string, uri string) bool {
-	parsedURL, err := url.Parse(uri)
-	if err != nil {
-		return false
-	}
+// isPathBypassed reports whether the path is served without authentication
+// when a bypass user is set.
+func isPathBypassed(path string) bool {
+	return path == "/favicon.ico"
+}
 
+func isPathInSlice(endpoints []string, path string) bool {
 	for _, endpoint := range endpoints {
-		if parsedURL.Path == endpoint {
+		if path == endpoint {
 			return true
 		}
 	}
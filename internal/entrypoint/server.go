@@ -0,0 +1,380 @@
+package entrypoint
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// Server is a single listener serving entrypoints selected by the Host
+// header and mounted under path prefixes.
+type Server struct {
+	Router
+	log        zerolog.Logger
+	ListenAddr string
+	TLSConfig  *tls.Config
+	hosts      []*virtualHost
+	srv        *http.Server
+	listener   net.Listener
+
+	// active holds the server whose routes are used for requests,
+	// it differs from the server itself after Replace. inflight counts the
+	// requests served with the routes of a server.
+	active   atomic.Pointer[Server]
+	inflight atomic.Int64
+	mu       sync.Mutex
+	retired  []*Server
+}
+
+// Router serves one or more entrypoints mounted under path prefixes.
+type Router struct {
+	mounts     []mount
+	subdomains map[string]*Router
+}
+
+type virtualHost struct {
+	pattern string
+	router  *Router
+}
+
+type mount struct {
+	prefix     string
+	entrypoint *Entrypoint
+}
+
+func NewServer(listenAddr string) *Server {
+	s := &Server{
+		log:        log.With().Str("entrypoint", listenAddr).Logger(),
+		ListenAddr: listenAddr,
+	}
+
+	s.srv = &http.Server{Addr: listenAddr, Handler: s}
+	// Hijacked WebSocket connections are not tracked by http.Server,
+	// close them as soon as the shutdown begins.
+	s.srv.RegisterOnShutdown(s.closeWebsockets)
+	s.active.Store(s)
+	return s
+}
+
+// Host returns the router for requests whose Host header matches the pattern.
+// Patterns are either exact host names or wildcards like "*.example.com"
+// matching a single subdomain label.
+func (s *Server) Host(pattern string) *Router {
+	pattern = strings.ToLower(pattern)
+	for _, h := range s.hosts {
+		if h.pattern == pattern {
+			return h.router
+		}
+	}
+
+	h := &virtualHost{pattern: pattern, router: &Router{}}
+	s.hosts = append(s.hosts, h)
+	sort.SliceStable(s.hosts, func(i, j int) bool {
+		return hostPriority(s.hosts[i].pattern) > hostPriority(s.hosts[j].pattern)
+	})
+	return h.router
+}
+
+// Start serves requests until the server is shut down, in which case
+// http.ErrServerClosed is returned.
+func (s *Server) Start() error {
+	if err := s.Listen(); err != nil {
+		return err
+	}
+	return s.Serve()
+}
+
+// Listen validates the entrypoints and binds the listen address, so errors
+// can be reported before serving in the background.
+func (s *Server) Listen() error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+
+	l, err := net.Listen("tcp", s.ListenAddr)
+	if err != nil {
+		return err
+	}
+
+	s.listener = l
+	return nil
+}
+
+// ListenOn validates the entrypoints and serves on an already bound
+// listener, e.g. one taken over from the previous server of the address.
+func (s *Server) ListenOn(l net.Listener) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+
+	s.listener = l
+	return nil
+}
+
+// Listener returns the bound listener, nil before Listen.
+func (s *Server) Listener() net.Listener {
+	return s.listener
+}
+
+// Close closes the bound listener of a server that was never served.
+func (s *Server) Close() error {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Close()
+}
+
+// Serve serves requests on the bound listener until the server is shut down.
+func (s *Server) Serve() error {
+	s.srv.TLSConfig = s.TLSConfig
+
+	s.log.Info().Bool("tls", s.TLSConfig != nil).Msg("listener started")
+	if s.TLSConfig != nil {
+		return s.srv.ServeTLS(s.listener, "", "")
+	}
+	return s.srv.Serve(s.listener)
+}
+
+// Replace atomically switches the server to the routes of next while keeping
+// the listener and its connections. Requests already in flight finish with
+// the previous routes.
+func (s *Server) Replace(next *Server) error {
+	if err := next.Validate(); err != nil {
+		return err
+	}
+
+	prev := s.active.Swap(next)
+
+	// The routes replaced by earlier reloads are dropped once their requests
+	// are done, so that they don't keep the previous devices alive.
+	s.mu.Lock()
+	s.retired = slices.DeleteFunc(s.retired, func(r *Server) bool { return r.inflight.Load() == 0 })
+	s.retired = append(s.retired, prev)
+	s.mu.Unlock()
+	return nil
+}
+
+// Shutdown stops accepting connections, closes WebSockets and waits for
+// in-flight requests until the context is done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.srv.Shutdown(ctx)
+	s.log.Info().Err(err).Msg("listener stopped")
+	return err
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	active := s.active.Load()
+	active.inflight.Add(1)
+	defer active.inflight.Add(-1)
+
+	active.route(w, cleanRequest(r))
+}
+
+// cleanRequest returns the request with a cleaned path. Devices resolve "."
+// and ".." segments themselves, so routing and policies have to see the path
+// the device will act on.
+func cleanRequest(r *http.Request) *http.Request {
+	p := cleanPath(r.URL.Path)
+	if p == r.URL.Path {
+		return r
+	}
+
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.URL = new(url.URL)
+	*r2.URL = *r.URL
+	r2.URL.Path = p
+	r2.URL.RawPath = ""
+	return r2
+}
+
+// cleanPath returns the canonical path, keeping a trailing slash like
+// http.ServeMux does.
+func cleanPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	if p[0] != '/' {
+		p = "/" + p
+	}
+	np := path.Clean(p)
+	if p[len(p)-1] == '/' && np != "/" {
+		np += "/"
+	}
+	return np
+}
+
+func (s *Server) route(w http.ResponseWriter, r *http.Request) {
+	host := requestHost(r)
+	for _, h := range s.hosts {
+		label, ok := matchHost(h.pattern, host)
+		if !ok {
+			continue
+		}
+
+		router := h.router
+		if len(router.subdomains) > 0 {
+			if router, ok = router.subdomains[label]; !ok {
+				http.NotFound(w, r)
+				return
+			}
+		}
+
+		router.ServeHTTP(w, r)
+		return
+	}
+
+	s.Router.ServeHTTP(w, r)
+}
+
+// Validate checks all entrypoints of the server.
+func (s *Server) Validate() error {
+	for _, e := range s.entrypoints() {
+		if err := e.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) closeWebsockets() {
+	s.mu.Lock()
+	entrypoints := s.active.Load().entrypoints()
+	for _, r := range s.retired {
+		entrypoints = append(entrypoints, r.entrypoints()...)
+	}
+	s.mu.Unlock()
+
+	for _, e := range entrypoints {
+		e.closeWebsockets()
+	}
+}
+
+func (s *Server) entrypoints() []*Entrypoint {
+	res := s.Router.entrypoints()
+	for _, h := range s.hosts {
+		res = append(res, h.router.entrypoints()...)
+	}
+	return res
+}
+
+// Subdomain returns the router for a subdomain label of a wildcard host.
+func (rt *Router) Subdomain(label string) *Router {
+	if rt.subdomains == nil {
+		rt.subdomains = make(map[string]*Router)
+	}
+
+	label = strings.ToLower(label)
+	if _, ok := rt.subdomains[label]; !ok {
+		rt.subdomains[label] = &Router{}
+	}
+	return rt.subdomains[label]
+}
+
+// Mount serves the entrypoint under the prefix. The prefix is stripped from
+// incoming requests and added back to links, redirects and cookie paths.
+func (rt *Router) Mount(prefix string, e *Entrypoint) {
+	prefix = normalizePrefix(prefix)
+	e.Options.Prefix = prefix
+	if prefix != "" {
+		e.log = e.log.With().Str("prefix", prefix).Logger()
+	}
+
+	rt.mounts = append(rt.mounts, mount{prefix: prefix, entrypoint: e})
+	sort.SliceStable(rt.mounts, func(i, j int) bool {
+		return len(rt.mounts[i].prefix) > len(rt.mounts[j].prefix)
+	})
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for _, m := range rt.mounts {
+		if m.prefix == "" {
+			m.entrypoint.ServeHTTP(w, r)
+			return
+		}
+
+		if r.URL.Path == m.prefix {
+			http.Redirect(w, r, m.prefix+"/", http.StatusMovedPermanently)
+			return
+		}
+
+		if strings.HasPrefix(r.URL.Path, m.prefix+"/") {
+			m.entrypoint.ServeHTTP(w, stripPrefix(r, m.prefix))
+			return
+		}
+	}
+
+	http.NotFound(w, r)
+}
+
+func (rt *Router) entrypoints() []*Entrypoint {
+	var res []*Entrypoint
+	for _, m := range rt.mounts {
+		res = append(res, m.entrypoint)
+	}
+	for _, sub := range rt.subdomains {
+		res = append(res, sub.entrypoints()...)
+	}
+	return res
+}
+
+// matchHost reports whether the host matches the pattern and returns
+// the subdomain label matched by a wildcard.
+func matchHost(pattern, host string) (string, bool) {
+	if !strings.HasPrefix(pattern, "*.") {
+		return "", pattern == host
+	}
+
+	suffix := pattern[1:]
+	label := strings.TrimSuffix(host, suffix)
+	if label == host || label == "" || strings.Contains(label, ".") {
+		return "", false
+	}
+
+	return label, true
+}
+
+// Exact hosts are matched before wildcards, longer wildcards before shorter.
+func hostPriority(pattern string) int {
+	if strings.HasPrefix(pattern, "*.") {
+		return len(pattern)
+	}
+	return len(pattern) + 1<<16
+}
+
+func requestHost(r *http.Request) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+func normalizePrefix(prefix string) string {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return ""
+	}
+	return "/" + prefix
+}
+
+func stripPrefix(r *http.Request, prefix string) *http.Request {
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.URL = new(url.URL)
+	*r2.URL = *r.URL
+	r2.URL.Path = strings.TrimPrefix(r.URL.Path, prefix)
+	r2.URL.RawPath = strings.TrimPrefix(r.URL.RawPath, prefix)
+	return r2
+}
@@ -0,0 +1,92 @@
+package entrypoint
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/mazzz1y/router-auth-gw/internal/device"
+)
+
+// WOLHost is a host that can be woken by the users, or by anyone who can
+// access the entrypoint if there are no users.
+type WOLHost struct {
+	MAC   string
+	Users []string
+}
+
+func (e *Entrypoint) wake(w http.ResponseWriter, r *http.Request, d device.Driver) {
+	var req struct {
+		Host string `json:"host"`
+	}
+	if r.URL.Query().Has("host") {
+		req.Host = r.URL.Query().Get("host")
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, errors.New("invalid request body"))
+		return
+	}
+
+	name, host, ok := e.wolHost(req.Host)
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("unknown host"))
+		return
+	}
+
+	if !e.wolAllowed(r, name, host) {
+		writeError(w, http.StatusForbidden, errors.New("not allowed to wake this host"))
+		return
+	}
+	if !e.policyAllows(w, r, wakeCommand, nil) {
+		return
+	}
+
+	identity, _ := r.Context().Value(identityContextKey).(string)
+	if err := d.Wake(r.Context(), host.MAC); err != nil {
+		e.deviceError(w, r, err)
+		return
+	}
+
+	e.log.Info().
+		Str("from", r.RemoteAddr).
+		Str("user", identity).
+		Str("host", name).
+		Msg("wake-on-lan sent")
+	writeJSON(w, http.StatusOK, map[string]string{"status": "sent", "host": name, "mac": host.MAC})
+}
+
+// wolAllowed reports whether the user of the request may wake the host.
+func (e *Entrypoint) wolAllowed(r *http.Request, name string, host WOLHost) bool {
+	identity, _ := r.Context().Value(identityContextKey).(string)
+	if len(host.Users) == 0 || slices.Contains(host.Users, identity) {
+		return true
+	}
+
+	e.log.Warn().
+		Str("from", r.RemoteAddr).
+		Str("user", identity).
+		Str("host", name).
+		Msg("wake-on-lan not allowed")
+	return false
+}
+
+// wolHost looks up a host by its name or MAC address.
+func (e *Entrypoint) wolHost(key string) (string, WOLHost, bool) {
+	if host, ok := e.Options.WOLHosts[key]; ok {
+		return key, host, true
+	}
+
+	mac, err := net.ParseMAC(key)
+	if err != nil {
+		return "", WOLHost{}, false
+	}
+	for name, host := range e.Options.WOLHosts {
+		if strings.EqualFold(host.MAC, mac.String()) {
+			return name, host, true
+		}
+	}
+
+	return "", WOLHost{}, false
+}
@@ -2,6 +2,7 @@ package entrypoint
 
 import (
 	"github.com/mazzz1y/router-auth-gw/internal/device"
+	"github.com/mazzz1y/router-auth-gw/internal/metrics"
 	"golang.org/x/net/websocket"
 	"io"
 	"net/http"
@@ -13,17 +14,47 @@ func (e *Entrypoint) wsRequest(w http.ResponseWriter, r *http.Request, c device.
 	if err != nil {
 		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 		e.log.Error().Err(err).Msg("failed to establish websocket connection")
+		metrics.UpstreamErrors.WithLabelValues(e.Options.ListenAddr, e.Options.Device.Tag, device.ErrorClass(err)).Inc()
 		return
 	}
 	defer conn.Close()
 
 	websocket.Handler(func(ws *websocket.Conn) {
+		e.trackWebsocket(ws, true)
+		defer e.trackWebsocket(ws, false)
+
+		sessions := metrics.WebsocketSessions.WithLabelValues(e.Options.ListenAddr, e.Options.Device.Tag)
+		sessions.Inc()
+		defer sessions.Dec()
+
 		defer ws.Close()
 		go io.Copy(ws, conn)
 		io.Copy(conn, ws)
 	}).ServeHTTP(w, r)
 }
 
+func (e *Entrypoint) trackWebsocket(ws *websocket.Conn, active bool) {
+	e.wsMu.Lock()
+	defer e.wsMu.Unlock()
+
+	if active {
+		e.wsConns[ws] = struct{}{}
+	} else {
+		delete(e.wsConns, ws)
+	}
+}
+
+// closeWebsockets sends a close frame to all active WebSocket clients.
+func (e *Entrypoint) closeWebsockets() {
+	e.wsMu.Lock()
+	defer e.wsMu.Unlock()
+
+	for ws := range e.wsConns {
+		ws.Close()
+		delete(e.wsConns, ws)
+	}
+}
+
 func isWSRequest(r *http.Request) bool {
 	return strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade") &&
 		strings.ToLower(r.Header.Get("Upgrade")) == "websocket"
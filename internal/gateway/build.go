@@ -0,0 +1,248 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+
+	"github.com/mazzz1y/router-auth-gw/internal/action"
+	"github.com/mazzz1y/router-auth-gw/internal/config"
+	"github.com/mazzz1y/router-auth-gw/internal/device"
+	"github.com/mazzz1y/router-auth-gw/internal/drift"
+	"github.com/mazzz1y/router-auth-gw/internal/entrypoint"
+	"github.com/mazzz1y/router-auth-gw/internal/mqtt"
+	"github.com/mazzz1y/router-auth-gw/internal/presence"
+	"github.com/mazzz1y/router-auth-gw/internal/schedule"
+	"github.com/mazzz1y/router-auth-gw/internal/telemetry"
+	"github.com/mazzz1y/router-auth-gw/internal/webhook"
+	"github.com/mazzz1y/router-auth-gw/pkg/keenetic"
+	"github.com/rs/zerolog/log"
+)
+
+// builder creates the entrypoint servers of a configuration.
+type builder struct {
+	cfg       *config.Config
+	dm        *device.Manager
+	actions   map[string]*action.Action
+	scheduler *schedule.Scheduler
+	presence  *presence.Poller
+	webhooks  *webhook.Dispatcher
+	mqtt      *mqtt.Bridge
+	telemetry *telemetry.Exporter
+}
+
+func newBuilder(cfg *config.Config, dm *device.Manager) (builder, error) {
+	webhooks, err := webhook.NewDispatcher(webhooksConfig(cfg))
+	if err != nil {
+		return builder{}, err
+	}
+	actions, err := action.New(cfg.Actions, dm)
+	if err != nil {
+		return builder{}, err
+	}
+	scheduler, err := schedule.New(cfg.Schedules, cfg.ScheduleStateFile, dm, actions)
+	if err != nil {
+		return builder{}, err
+	}
+	poller, err := presence.New(cfg.Presence, dm, webhooks)
+	if err != nil {
+		return builder{}, err
+	}
+	bridge, err := mqtt.New(cfg.MQTT, dm, actions)
+	if err != nil {
+		return builder{}, err
+	}
+	exporter, err := telemetry.New(cfg.Telemetry, dm)
+	if err != nil {
+		return builder{}, err
+	}
+	return builder{cfg: cfg, dm: dm, actions: actions, scheduler: scheduler, presence: poller, webhooks: webhooks, mqtt: bridge, telemetry: exporter}, nil
+}
+
+// webhooksConfig is the webhooks configuration with the deprecated
+// webhook_url of drift and presence as unsigned endpoints of their events.
+func webhooksConfig(cfg *config.Config) config.WebhooksConfig {
+	wc := cfg.Webhooks
+	wc.Endpoints = slices.Clone(wc.Endpoints)
+
+	legacy := []struct {
+		path   string
+		url    string
+		events []string
+	}{
+		{"drift.webhook_url", cfg.Drift.WebhookURL, []string{drift.EventDrift, drift.EventResolved}},
+		{"presence.webhook_url", cfg.Presence.WebhookURL, []string{presence.EventJoin, presence.EventLeave}},
+	}
+	for _, l := range legacy {
+		if l.url == "" {
+			continue
+		}
+		log.Warn().Str("option", l.path).Msg("webhook_url is deprecated, configure the url in webhooks.endpoints")
+
+		i := slices.IndexFunc(wc.Endpoints, func(e config.WebhookConfig) bool { return e.URL == l.url })
+		switch {
+		case i < 0:
+			wc.Endpoints = append(wc.Endpoints, config.WebhookConfig{URL: l.url, Events: l.events})
+		case len(wc.Endpoints[i].Events) > 0:
+			wc.Endpoints[i].Events = append(slices.Clone(wc.Endpoints[i].Events), l.events...)
+		}
+	}
+	return wc
+}
+
+// newServer creates the server of an entrypoint. TLS is configured only with
+// withTLS, servers without it are used to replace the routes of running ones.
+func (b builder) newServer(ctx context.Context, entryCfg config.EntrypointConfig, withTLS bool) (*entrypoint.Server, *http.Server, error) {
+	server := entrypoint.NewServer(entryCfg.Listen)
+
+	var challengeServer *http.Server
+	if withTLS && entryCfg.TLS.Enabled() {
+		tlsConfig, cs, err := newTLSConfig(ctx, entryCfg.TLS)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: failed to configure tls: %w", entryCfg.Listen, err)
+		}
+		server.TLSConfig = tlsConfig
+		challengeServer = cs
+	}
+
+	if err := b.mountRoute(&server.Router, entryCfg.Listen, entryCfg.RouteConfig); err != nil {
+		return nil, nil, err
+	}
+
+	for _, h := range entryCfg.Hosts {
+		router := server.Host(h.Host)
+
+		if h.BySubdomain() {
+			if len(h.Devices) == 0 {
+				return nil, nil, fmt.Errorf("%s: %s: devices are required for wildcard hosts without device_tag and mounts", h.Host, entryCfg.Listen)
+			}
+			for _, tag := range h.Devices {
+				route := h.RouteConfig
+				route.DeviceTag = tag
+				if err := b.mountRoute(router.Subdomain(tag), entryCfg.Listen, route); err != nil {
+					return nil, nil, fmt.Errorf("%s: %w", h.Host, err)
+				}
+			}
+			continue
+		}
+		if h.DeviceTag == "" && len(h.Mounts) == 0 {
+			return nil, nil, fmt.Errorf("%s: %s: device_tag or mounts are required for non-wildcard hosts", h.Host, entryCfg.Listen)
+		}
+
+		if err := b.mountRoute(router, entryCfg.Listen, h.RouteConfig); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", h.Host, err)
+		}
+	}
+
+	if err := server.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", entryCfg.Listen, err)
+	}
+
+	return server, challengeServer, nil
+}
+
+func (b builder) mountRoute(router *entrypoint.Router, listen string, route config.RouteConfig) error {
+	if route.DeviceTag != "" {
+		e, err := b.newEntrypoint(listen, route, route.DeviceTag, route.BypassUser, route.DefaultUser)
+		if err != nil {
+			return err
+		}
+		router.Mount("/", e)
+	}
+
+	for _, m := range route.Mounts {
+		e, err := b.newEntrypoint(listen, route, m.DeviceTag, m.BypassUser, m.DefaultUser)
+		if err != nil {
+			return err
+		}
+		router.Mount(m.Prefix, e)
+	}
+
+	return nil
+}
+
+func (b builder) newEntrypoint(listen string, route config.RouteConfig, deviceTag, bypassUser, defaultUser string) (*entrypoint.Entrypoint, error) {
+	d, ok := b.dm.Devices[deviceTag]
+	if !ok {
+		return nil, fmt.Errorf("%s: \"%s\" device not found", listen, deviceTag)
+	}
+
+	rciPolicy := keenetic.Policy{
+		Allow: route.RCIPolicy.Allow,
+		Deny:  route.RCIPolicy.Deny,
+	}
+	if !rciPolicy.IsEmpty() && d.Type != "keenetic" {
+		return nil, fmt.Errorf("%s: rci_policy is only supported for keenetic devices", listen)
+	}
+
+	// Actions of other devices are served by the entrypoints of those devices.
+	actions := make(map[string]*action.Action)
+	for _, name := range route.Actions {
+		a, ok := b.actions[name]
+		if !ok {
+			return nil, fmt.Errorf("%s: action %q not found", listen, name)
+		}
+		if a.DeviceTag == deviceTag {
+			actions[name] = a
+		}
+	}
+
+	var scheduler *schedule.Scheduler
+	if route.Schedules {
+		scheduler = b.scheduler
+	}
+
+	var poller *presence.Poller
+	if route.Events {
+		if !b.presence.Polls(deviceTag) {
+			return nil, fmt.Errorf("%s: events require presence polling of %q", listen, deviceTag)
+		}
+		poller = b.presence
+	}
+
+	if bypassUser == "" {
+		bypassUser = route.BypassUser
+	}
+	if defaultUser == "" {
+		defaultUser = route.DefaultUser
+	}
+
+	return entrypoint.NewEntrypoint(entrypoint.Options{
+		Device:              d,
+		ListenAddr:          listen,
+		ForwardAuthHeader:   route.ForwardAuth.Header,
+		ForwardAuthMapping:  route.ForwardAuth.Mapping,
+		BasicAuth:           route.BasicAuthMap(),
+		AllowedEndpoints:    route.AllowedEndpoints,
+		BypassAuthEndpoints: route.BypassAuthEndpoints,
+		BypassUser:          bypassUser,
+		DefaultUser:         defaultUser,
+		OnlyGet:             route.ReadOnly,
+		RCIPolicy:           rciPolicy,
+		API:                 route.API,
+		WOL:                 route.WOL,
+		WOLHosts:            b.wolHosts(deviceTag),
+		Actions:             actions,
+		Schedules:           scheduler,
+		Presence:            poller,
+		Webhooks:            b.webhooks,
+	}), nil
+}
+
+// wolHosts returns the Wake-on-LAN hosts reachable through the device.
+func (b builder) wolHosts(deviceTag string) map[string]entrypoint.WOLHost {
+	hosts := make(map[string]entrypoint.WOLHost)
+	for name, h := range b.cfg.WOLHosts {
+		if h.DeviceTag != "" && h.DeviceTag != deviceTag {
+			continue
+		}
+		mac, err := net.ParseMAC(h.MAC)
+		if err != nil {
+			continue
+		}
+		hosts[name] = entrypoint.WOLHost{MAC: mac.String(), Users: h.Users}
+	}
+	return hosts
+}
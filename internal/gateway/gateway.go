@@ -0,0 +1,495 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/mazzz1y/router-auth-gw/internal/backup"
+	"github.com/mazzz1y/router-auth-gw/internal/config"
+	"github.com/mazzz1y/router-auth-gw/internal/device"
+	"github.com/mazzz1y/router-auth-gw/internal/drift"
+	"github.com/mazzz1y/router-auth-gw/internal/entrypoint"
+	"github.com/mazzz1y/router-auth-gw/internal/mqtt"
+	"github.com/mazzz1y/router-auth-gw/internal/presence"
+	"github.com/mazzz1y/router-auth-gw/internal/schedule"
+	"github.com/mazzz1y/router-auth-gw/internal/telemetry"
+	"github.com/mazzz1y/router-auth-gw/internal/webhook"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultShutdownTimeout = 30 * time.Second
+	logoutTimeout          = 10 * time.Second
+)
+
+// Gateway runs the entrypoint servers of a configuration.
+type Gateway struct {
+	ctx   context.Context
+	errCh chan error
+
+	mu        sync.Mutex
+	cfg       *config.Config
+	devices   *device.Manager
+	scheduler *schedule.Scheduler
+	backups   *backup.Backuper
+	drift     *drift.Monitor
+	presence  *presence.Poller
+	webhooks  *webhook.Dispatcher
+	mqtt      *mqtt.Bridge
+	telemetry *telemetry.Exporter
+	metrics   *metricsServer
+	listeners map[string]*listener
+	running   bool
+}
+
+// listener is a running entrypoint server together with everything bound
+// to its lifetime.
+type listener struct {
+	cfg               config.EntrypointConfig
+	server            *entrypoint.Server
+	challengeServer   *http.Server
+	challengeListener net.Listener
+	cancel            context.CancelFunc
+}
+
+// New creates devices and servers for the configuration without starting
+// them. The context bounds background tasks such as certificate reloads.
+func New(ctx context.Context, cfg *config.Config) (*Gateway, error) {
+	dm, err := device.NewDeviceManager(cfg.Devices)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := newBuilder(cfg, dm)
+	if err != nil {
+		return nil, err
+	}
+
+	backups, err := backup.New(cfg.Backups, dm)
+	if err != nil {
+		return nil, err
+	}
+
+	driftMonitor, err := drift.New(cfg.Drift, dm, b.webhooks)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &Gateway{
+		ctx:       ctx,
+		errCh:     make(chan error, 1),
+		cfg:       cfg,
+		devices:   dm,
+		scheduler: b.scheduler,
+		backups:   backups,
+		drift:     driftMonitor,
+		presence:  b.presence,
+		webhooks:  b.webhooks,
+		mqtt:      b.mqtt,
+		telemetry: b.telemetry,
+		metrics:   newMetricsServer(cfg.Metrics),
+		listeners: make(map[string]*listener),
+	}
+
+	if err := checkListeners(cfg); err != nil {
+		return nil, err
+	}
+
+	for _, entryCfg := range cfg.Entrypoints {
+		l, err := g.newListener(b, entryCfg)
+		if err != nil {
+			g.closeListeners()
+			return nil, err
+		}
+		g.listeners[entryCfg.Listen] = l
+	}
+
+	return g, nil
+}
+
+// Run serves requests until the context is canceled or a listener fails,
+// then drains in-flight requests and optionally logs out of the devices.
+func (g *Gateway) Run(ctx context.Context) error {
+	g.mu.Lock()
+	for _, l := range g.listeners {
+		if err := g.start(l); err != nil {
+			g.mu.Unlock()
+			g.shutdown()
+			return err
+		}
+	}
+	if err := g.startMetrics(g.metrics); err != nil {
+		g.mu.Unlock()
+		g.shutdown()
+		return err
+	}
+	g.scheduler.Start(ctx)
+	g.backups.Start(ctx)
+	g.drift.Start(ctx)
+	g.presence.Start(ctx)
+	g.webhooks.Start(ctx)
+	g.mqtt.Start(ctx)
+	g.telemetry.Start(ctx)
+	g.running = true
+	g.mu.Unlock()
+
+	var err error
+	select {
+	case <-ctx.Done():
+		log.Info().Msg("shutting down")
+	case err = <-g.errCh:
+		log.Error().Err(err).Msg("listener failed, shutting down")
+	}
+
+	g.shutdown()
+	return err
+}
+
+// Reload applies a new configuration. Listeners with the same address and TLS
+// settings are kept and get the new routes atomically, changed listeners are
+// restarted, and device sessions are kept for unchanged credentials. On error
+// the running configuration stays untouched.
+func (g *Gateway) Reload(cfg *config.Config) error {
+	if err := checkListeners(cfg); err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	dm, err := g.devices.Reload(cfg.Devices)
+	if err != nil {
+		return err
+	}
+
+	b, err := newBuilder(cfg, dm)
+	if err != nil {
+		return err
+	}
+
+	backups, err := backup.New(cfg.Backups, dm)
+	if err != nil {
+		return err
+	}
+
+	driftMonitor, err := drift.New(cfg.Drift, dm, b.webhooks)
+	if err != nil {
+		return err
+	}
+
+	var (
+		updated   = make(map[string]*entrypoint.Server)
+		restarted = make(map[string]*listener)
+	)
+
+	abort := func(err error) error {
+		for _, l := range restarted {
+			l.close()
+		}
+		return err
+	}
+
+	for _, entryCfg := range cfg.Entrypoints {
+		prev, ok := g.listeners[entryCfg.Listen]
+		if ok && reflect.DeepEqual(prev.cfg.TLS, entryCfg.TLS) {
+			server, _, err := b.newServer(g.ctx, entryCfg, false)
+			if err == nil {
+				err = server.Validate()
+			}
+			if err != nil {
+				return abort(err)
+			}
+			updated[entryCfg.Listen] = server
+			continue
+		}
+
+		l, err := g.newListener(b, entryCfg)
+		if err != nil {
+			return abort(err)
+		}
+		restarted[entryCfg.Listen] = l
+	}
+
+	// The restarted listeners are bound before anything is stopped, taking
+	// over the sockets of the listeners they replace, so that a listener
+	// that can't be bound leaves the running configuration untouched.
+	if g.running {
+		for addr, l := range restarted {
+			if err := g.bind(l, g.listeners[addr]); err != nil {
+				return abort(err)
+			}
+		}
+	}
+
+	for addr, l := range g.listeners {
+		if _, ok := updated[addr]; ok {
+			continue
+		}
+
+		g.stop(l)
+		delete(g.listeners, addr)
+		if _, ok := restarted[addr]; !ok {
+			log.Info().Str("entrypoint", addr).Msg("entrypoint removed")
+		}
+	}
+
+	for addr, server := range updated {
+		l := g.listeners[addr]
+		// The server is validated above, Replace doesn't fail.
+		l.server.Replace(server)
+		l.cfg = entryCfgByListen(cfg, addr)
+		log.Debug().Str("entrypoint", addr).Msg("entrypoint routes updated")
+	}
+
+	for addr, l := range restarted {
+		if g.running {
+			g.serve(l)
+		}
+		g.listeners[addr] = l
+	}
+
+	// The previous scheduler is stopped first, so that the last runs it
+	// records are taken over by the new one.
+	g.scheduler.Stop()
+	b.scheduler.Inherit(g.scheduler)
+	g.backups.Stop()
+	g.drift.Stop()
+	driftMonitor.Inherit(g.drift)
+	g.presence.Stop()
+	b.presence.Inherit(g.presence)
+	g.webhooks.Stop()
+	b.webhooks.Inherit(g.webhooks)
+	g.mqtt.Stop()
+	// The metrics of the previous exporter are unregistered before the new
+	// one registers them.
+	g.telemetry.Stop()
+	if g.running {
+		b.scheduler.Start(g.ctx)
+		backups.Start(g.ctx)
+		driftMonitor.Start(g.ctx)
+		b.presence.Start(g.ctx)
+		b.webhooks.Start(g.ctx)
+		b.mqtt.Start(g.ctx)
+		b.telemetry.Start(g.ctx)
+	}
+
+	if !reflect.DeepEqual(g.cfg.Metrics, cfg.Metrics) {
+		g.stopMetrics()
+		g.metrics = newMetricsServer(cfg.Metrics)
+		if g.running {
+			if err := g.startMetrics(g.metrics); err != nil {
+				log.Error().Err(err).Msg("failed to start metrics listener")
+			}
+		}
+	}
+
+	g.cfg, g.devices = cfg, dm
+	g.scheduler, g.backups, g.drift, g.presence = b.scheduler, backups, driftMonitor, b.presence
+	g.webhooks, g.mqtt, g.telemetry = b.webhooks, b.mqtt, b.telemetry
+	log.Info().Msg("configuration reloaded")
+	return nil
+}
+
+func (g *Gateway) newListener(b builder, entryCfg config.EntrypointConfig) (*listener, error) {
+	ctx, cancel := context.WithCancel(g.ctx)
+
+	server, challengeServer, err := b.newServer(ctx, entryCfg, true)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	return &listener{
+		cfg:             entryCfg,
+		server:          server,
+		challengeServer: challengeServer,
+		cancel:          cancel,
+	}, nil
+}
+
+// start binds the listener and serves it in the background.
+func (g *Gateway) start(l *listener) error {
+	if err := g.bind(l, nil); err != nil {
+		return err
+	}
+	g.serve(l)
+	return nil
+}
+
+// bind binds the listener and its challenge server. Addresses bound by prev,
+// the listener it replaces, are taken over from prev.
+func (g *Gateway) bind(l, prev *listener) error {
+	var err error
+	if prev != nil && prev.server.Listener() != nil {
+		var ln net.Listener
+		if ln, err = dupListener(prev.server.Listener()); err == nil {
+			if err = l.server.ListenOn(ln); err != nil {
+				ln.Close()
+			}
+		}
+	} else {
+		err = l.server.Listen()
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", l.server.ListenAddr, err)
+	}
+
+	if l.challengeServer == nil {
+		return nil
+	}
+	if prev != nil && prev.challengeListener != nil && prev.challengeServer.Addr == l.challengeServer.Addr {
+		l.challengeListener, err = dupListener(prev.challengeListener)
+	} else {
+		l.challengeListener, err = net.Listen("tcp", l.challengeServer.Addr)
+	}
+	if err != nil {
+		l.server.Close()
+		return fmt.Errorf("%s: %w", l.challengeServer.Addr, err)
+	}
+	return nil
+}
+
+// serve serves the bound listener in the background. Serving errors are
+// reported to Run.
+func (g *Gateway) serve(l *listener) {
+	go func() {
+		if err := l.server.Serve(); !errors.Is(err, http.ErrServerClosed) {
+			g.reportError(fmt.Errorf("%s: %w", l.server.ListenAddr, err))
+		}
+	}()
+
+	if l.challengeServer != nil {
+		go func() {
+			log.Info().Str("listen", l.challengeServer.Addr).Msg("acme http-01 listener started")
+			if err := l.challengeServer.Serve(l.challengeListener); !errors.Is(err, http.ErrServerClosed) {
+				g.reportError(fmt.Errorf("%s: %w", l.challengeServer.Addr, err))
+			}
+		}()
+	}
+}
+
+// close releases a listener that was never served.
+func (l *listener) close() {
+	l.cancel()
+	l.server.Close()
+	if l.challengeListener != nil {
+		l.challengeListener.Close()
+	}
+}
+
+// dupListener returns a listener on a duplicate of the socket of ln, which
+// stays bound when ln is closed.
+func dupListener(ln net.Listener) (net.Listener, error) {
+	tl, ok := ln.(*net.TCPListener)
+	if !ok {
+		return nil, fmt.Errorf("can't take over a %T", ln)
+	}
+	f, err := tl.File()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return net.FileListener(f)
+}
+
+// stop drains the listener, it is used for listeners removed by a reload.
+func (g *Gateway) stop(l *listener) {
+	ctx, cancel := context.WithTimeout(context.Background(), g.shutdownTimeout())
+	defer cancel()
+
+	l.cancel()
+	l.server.Shutdown(ctx)
+	if l.challengeServer != nil {
+		l.challengeServer.Shutdown(ctx)
+	}
+}
+
+func (g *Gateway) stopMetrics() {
+	ctx, cancel := context.WithTimeout(context.Background(), g.shutdownTimeout())
+	defer cancel()
+	g.metrics.stop(ctx)
+}
+
+func (g *Gateway) reportError(err error) {
+	select {
+	case g.errCh <- err:
+	default:
+	}
+}
+
+func (g *Gateway) shutdown() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.scheduler.Stop()
+	g.backups.Stop()
+	g.drift.Stop()
+	// Ends the event streams, so that listeners don't wait for them.
+	g.presence.Stop()
+	g.mqtt.Stop()
+	g.telemetry.Stop()
+
+	var wg sync.WaitGroup
+	for _, l := range g.listeners {
+		wg.Add(1)
+		go func(l *listener) {
+			defer wg.Done()
+			g.stop(l)
+		}(l)
+	}
+	wg.Wait()
+	g.stopMetrics()
+	// Stopped after the listeners, so that the events of draining requests
+	// are queued.
+	g.webhooks.Stop()
+
+	if g.cfg.Shutdown.LogoutDevices {
+		ctx, cancel := context.WithTimeout(context.Background(), logoutTimeout)
+		defer cancel()
+
+		if err := g.devices.Logout(ctx); err != nil {
+			log.Warn().Err(err).Msg("failed to log out of devices")
+		} else {
+			log.Info().Msg("logged out of devices")
+		}
+	}
+}
+
+func (g *Gateway) closeListeners() {
+	for _, l := range g.listeners {
+		l.cancel()
+	}
+}
+
+func (g *Gateway) shutdownTimeout() time.Duration {
+	if g.cfg.Shutdown.Timeout > 0 {
+		return g.cfg.Shutdown.Timeout
+	}
+	return defaultShutdownTimeout
+}
+
+func checkListeners(cfg *config.Config) error {
+	seen := make(map[string]bool)
+	for _, e := range cfg.Entrypoints {
+		if seen[e.Listen] {
+			return fmt.Errorf("%s: duplicate listen address", e.Listen)
+		}
+		seen[e.Listen] = true
+	}
+	return nil
+}
+
+func entryCfgByListen(cfg *config.Config, listen string) config.EntrypointConfig {
+	for _, e := range cfg.Entrypoints {
+		if e.Listen == listen {
+			return e
+		}
+	}
+	return config.EntrypointConfig{}
+}
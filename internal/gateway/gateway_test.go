@@ -0,0 +1,289 @@
+package gateway
+
+import (
+	"context"
+	"io"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mazzz1y/router-auth-gw/internal/config"
+	"github.com/mazzz1y/router-auth-gw/internal/device"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func testConfig(listen string) *config.Config {
+	return &config.Config{
+		Entrypoints: []config.EntrypointConfig{{
+			Listen:      listen,
+			RouteConfig: config.RouteConfig{DeviceTag: "router"},
+		}},
+		Devices: []config.DeviceConfig{{
+			Tag:   "router",
+			Type:  "keenetic",
+			URL:   "http://127.0.0.1:1",
+			Users: []config.UserConfig{{Username: "admin", Password: "pass"}},
+		}},
+		Shutdown: config.ShutdownConfig{Timeout: time.Second},
+	}
+}
+
+func TestNew(t *testing.T) {
+	t.Run("Unknown device", func(t *testing.T) {
+		cfg := testConfig(freeAddr(t))
+		cfg.Entrypoints[0].DeviceTag = "missing"
+
+		_, err := New(context.Background(), cfg)
+		assert.ErrorContains(t, err, "device not found")
+	})
+
+	t.Run("Bypass endpoints without bypass user", func(t *testing.T) {
+		cfg := testConfig(freeAddr(t))
+		cfg.Entrypoints[0].BasicAuth = []config.BasicAuthConfig{{Username: "user", Password: "pass"}}
+		cfg.Entrypoints[0].BypassAuthEndpoints = []string{"/public"}
+
+		_, err := New(context.Background(), cfg)
+		assert.ErrorContains(t, err, "bypass_user")
+	})
+
+	t.Run("Host without device", func(t *testing.T) {
+		cfg := testConfig(freeAddr(t))
+		cfg.Entrypoints[0].Hosts = []config.HostConfig{{Host: "router.example.com"}}
+
+		_, err := New(context.Background(), cfg)
+		assert.ErrorContains(t, err, "device_tag or mounts are required")
+	})
+
+	t.Run("Wildcard host without devices", func(t *testing.T) {
+		cfg := testConfig(freeAddr(t))
+		cfg.Entrypoints[0].Hosts = []config.HostConfig{{Host: "*.routers.example.com"}}
+
+		_, err := New(context.Background(), cfg)
+		assert.ErrorContains(t, err, "devices are required")
+	})
+
+	t.Run("Actions of other devices", func(t *testing.T) {
+		cfg := testConfig(freeAddr(t))
+		cfg.Devices = append(cfg.Devices, config.DeviceConfig{
+			Tag:   "ap",
+			Type:  "keenetic",
+			URL:   "http://127.0.0.1:2",
+			Users: []config.UserConfig{{Username: "admin", Password: "pass"}},
+		})
+		cfg.Actions = map[string]config.ActionConfig{
+			"reboot":    {DeviceTag: "router", Path: "/rci/system/reboot"},
+			"ap-reboot": {DeviceTag: "ap", Path: "/rci/system/reboot"},
+		}
+		cfg.Entrypoints[0].Actions = []string{"reboot", "ap-reboot"}
+
+		dm, err := device.NewDeviceManager(cfg.Devices)
+		require.NoError(t, err)
+		b, err := newBuilder(cfg, dm)
+		require.NoError(t, err)
+		e, err := b.newEntrypoint("test", cfg.Entrypoints[0].RouteConfig, "router", "", "")
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"reboot"}, slices.Collect(maps.Keys(e.Options.Actions)))
+	})
+}
+
+func TestRun(t *testing.T) {
+	t.Run("Graceful shutdown", func(t *testing.T) {
+		addr := freeAddr(t)
+		gw, err := New(context.Background(), testConfig(addr))
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() { done <- gw.Run(ctx) }()
+
+		assert.Eventually(t, func() bool {
+			resp, err := http.Get("http://" + addr + "/favicon.ico")
+			if err != nil {
+				return false
+			}
+			resp.Body.Close()
+			return true
+		}, time.Second, 10*time.Millisecond)
+
+		cancel()
+		assert.NoError(t, <-done)
+
+		_, err = http.Get("http://" + addr + "/")
+		assert.Error(t, err)
+	})
+
+	t.Run("Listener failure", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer l.Close()
+
+		gw, err := New(context.Background(), testConfig(l.Addr().String()))
+		require.NoError(t, err)
+
+		assert.Error(t, gw.Run(context.Background()))
+	})
+}
+
+func TestReload(t *testing.T) {
+	kept, removed, added := freeAddr(t), freeAddr(t), freeAddr(t)
+
+	cfg := testConfig(kept)
+	cfg.Entrypoints = append(cfg.Entrypoints, config.EntrypointConfig{
+		Listen:      removed,
+		RouteConfig: config.RouteConfig{DeviceTag: "router"},
+	})
+
+	gw, err := New(context.Background(), cfg)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go gw.Run(ctx)
+
+	status := func(addr string) int {
+		resp, err := http.Get("http://" + addr + "/favicon.ico")
+		if err != nil {
+			return 0
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	assert.Eventually(t, func() bool { return status(kept) != 0 && status(removed) != 0 }, time.Second, 10*time.Millisecond)
+	assert.NotEqual(t, http.StatusUnauthorized, status(kept))
+
+	prevClient := gw.devices.Devices["router"].Users[0].Client
+
+	next := testConfig(kept)
+	next.Entrypoints[0].BasicAuth = []config.BasicAuthConfig{{Username: "user", Password: "pass"}}
+	next.Entrypoints = append(next.Entrypoints, config.EntrypointConfig{
+		Listen:      added,
+		RouteConfig: config.RouteConfig{DeviceTag: "router"},
+	})
+	require.NoError(t, gw.Reload(next))
+
+	assert.Equal(t, http.StatusUnauthorized, status(kept))
+	assert.Equal(t, 0, status(removed))
+	assert.NotEqual(t, 0, status(added))
+	assert.Same(t, prevClient, gw.devices.Devices["router"].Users[0].Client)
+
+	t.Run("Invalid configuration is not applied", func(t *testing.T) {
+		invalid := testConfig(kept)
+		invalid.Entrypoints[0].DeviceTag = "missing"
+
+		assert.Error(t, gw.Reload(invalid))
+		assert.Equal(t, http.StatusUnauthorized, status(kept))
+		assert.NotEqual(t, 0, status(added))
+	})
+
+	t.Run("Restarted listener takes over the address", func(t *testing.T) {
+		restarted := testConfig(kept)
+		// Restarts the listener without enabling TLS.
+		restarted.Entrypoints[0].TLS.MinVersion = "1.2"
+		restarted.Entrypoints = append(restarted.Entrypoints, next.Entrypoints[1])
+		require.NoError(t, gw.Reload(restarted))
+
+		code := status(kept)
+		assert.NotEqual(t, 0, code)
+		assert.NotEqual(t, http.StatusUnauthorized, code)
+	})
+
+	t.Run("Listener that can't be bound is not applied", func(t *testing.T) {
+		busy, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer busy.Close()
+
+		failed := testConfig(kept)
+		failed.Entrypoints[0].BasicAuth = []config.BasicAuthConfig{{Username: "user", Password: "pass"}}
+		failed.Entrypoints = append(failed.Entrypoints, config.EntrypointConfig{
+			Listen:      busy.Addr().String(),
+			RouteConfig: config.RouteConfig{DeviceTag: "router"},
+		})
+
+		assert.ErrorContains(t, gw.Reload(failed), busy.Addr().String())
+		assert.NotEqual(t, http.StatusUnauthorized, status(kept))
+		assert.NotEqual(t, 0, status(added))
+	})
+}
+
+func TestMetrics(t *testing.T) {
+	addr, metricsAddr := freeAddr(t), freeAddr(t)
+	cfg := testConfig(addr)
+	cfg.Metrics = config.MetricsConfig{Listen: metricsAddr}
+
+	gw, err := New(context.Background(), cfg)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go gw.Run(ctx)
+
+	scrape := func(path string) string {
+		resp, err := http.Get("http://" + metricsAddr + path)
+		if err != nil {
+			return ""
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return ""
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	assert.Eventually(t, func() bool {
+		resp, err := http.Get("http://" + addr + "/rci/show/version")
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusBadGateway
+	}, time.Second, 10*time.Millisecond)
+
+	body := scrape("/metrics")
+	assert.Contains(t, body, `router_auth_gw_http_requests_total{device="router",entrypoint="`+addr+`",method="GET",status="502",user=""}`)
+	assert.Contains(t, body, `router_auth_gw_auth_total{device="router",entrypoint="`+addr+`",result="anonymous"}`)
+	assert.Contains(t, body, `router_auth_gw_upstream_errors_total{class="connection_refused",device="router",entrypoint="`+addr+`"}`)
+	assert.Contains(t, body, "go_goroutines")
+
+	next := testConfig(addr)
+	next.Metrics = config.MetricsConfig{Listen: metricsAddr, Path: "/prometheus"}
+	next.Telemetry = config.TelemetryConfig{Enabled: true}
+	require.NoError(t, gw.Reload(next))
+	assert.Empty(t, scrape("/metrics"))
+	assert.Contains(t, scrape("/prometheus"), "router_auth_gw_http_requests_total")
+	assert.Eventually(t, func() bool {
+		return strings.Contains(scrape("/prometheus"), `router_auth_gw_router_up{device="router"} 0`)
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestWebhooksConfig(t *testing.T) {
+	cfg := testConfig(freeAddr(t))
+	cfg.Webhooks.Endpoints = []config.WebhookConfig{
+		{URL: "https://hooks.example.com/all"},
+		{URL: "https://hooks.example.com/auth", Events: []string{"auth_failed"}},
+	}
+	cfg.Drift.WebhookURL = "https://hooks.example.com/drift"
+	cfg.Presence.WebhookURL = "https://hooks.example.com/auth"
+
+	assert.Equal(t, []config.WebhookConfig{
+		{URL: "https://hooks.example.com/all"},
+		{URL: "https://hooks.example.com/auth", Events: []string{"auth_failed", "host_join", "host_leave"}},
+		{URL: "https://hooks.example.com/drift", Events: []string{"config_drift", "config_drift_resolved"}},
+	}, webhooksConfig(cfg).Endpoints)
+	assert.Len(t, cfg.Webhooks.Endpoints[1].Events, 1, "the configuration is not changed")
+}
@@ -0,0 +1,65 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/mazzz1y/router-auth-gw/internal/config"
+	"github.com/mazzz1y/router-auth-gw/internal/metrics"
+	"github.com/rs/zerolog/log"
+)
+
+const defaultMetricsPath = "/metrics"
+
+// metricsServer serves the Prometheus metrics on its own listener.
+type metricsServer struct {
+	cfg config.MetricsConfig
+	srv *http.Server
+}
+
+// newMetricsServer returns nil if metrics are disabled.
+func newMetricsServer(cfg config.MetricsConfig) *metricsServer {
+	if !cfg.Enabled() {
+		return nil
+	}
+
+	path := cfg.Path
+	if path == "" {
+		path = defaultMetricsPath
+	}
+	mux := http.NewServeMux()
+	mux.Handle(path, metrics.Handler())
+
+	return &metricsServer{cfg: cfg, srv: &http.Server{Addr: cfg.Listen, Handler: mux}}
+}
+
+// startMetrics binds the metrics listener and serves it in the background.
+// Serving errors are reported to Run.
+func (g *Gateway) startMetrics(m *metricsServer) error {
+	if m == nil {
+		return nil
+	}
+
+	l, err := net.Listen("tcp", m.cfg.Listen)
+	if err != nil {
+		return fmt.Errorf("%s: %w", m.cfg.Listen, err)
+	}
+
+	go func() {
+		log.Info().Str("listen", m.cfg.Listen).Msg("metrics listener started")
+		if err := m.srv.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+			g.reportError(fmt.Errorf("%s: %w", m.cfg.Listen, err))
+		}
+	}()
+	return nil
+}
+
+func (m *metricsServer) stop(ctx context.Context) {
+	if m == nil {
+		return
+	}
+	m.srv.Shutdown(ctx)
+}
@@ -0,0 +1,82 @@
+package gateway
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+
+	"github.com/mazzz1y/router-auth-gw/internal/certs"
+	"github.com/mazzz1y/router-auth-gw/internal/config"
+)
+
+// newTLSConfig returns the TLS config of an entrypoint and, for ACME with
+// HTTP-01 enabled, the server answering the challenges.
+func newTLSConfig(ctx context.Context, cfg config.TLSConfig) (*tls.Config, *http.Server, error) {
+	minVersion, err := certs.ParseVersion(cfg.MinVersion)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cipherSuites, err := certs.ParseCipherSuites(cfg.CipherSuites)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var tlsConfig *tls.Config
+	var challengeServer *http.Server
+	if cfg.ACME.Enabled() {
+		tlsConfig, challengeServer, err = newACMETLSConfig(cfg)
+	} else {
+		tlsConfig, err = newFileTLSConfig(ctx, cfg)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tlsConfig.MinVersion = minVersion
+	tlsConfig.CipherSuites = cipherSuites
+	return tlsConfig, challengeServer, nil
+}
+
+func newFileTLSConfig(ctx context.Context, cfg config.TLSConfig) (*tls.Config, error) {
+	var pairs []certs.Pair
+	for _, c := range cfg.AllCertificates() {
+		pairs = append(pairs, certs.Pair{CertFile: c.CertFile, KeyFile: c.KeyFile})
+	}
+
+	store, err := certs.NewStore(pairs)
+	if err != nil {
+		return nil, err
+	}
+	go store.Watch(ctx)
+
+	return &tls.Config{GetCertificate: store.GetCertificate}, nil
+}
+
+func newACMETLSConfig(cfg config.TLSConfig) (*tls.Config, *http.Server, error) {
+	if len(cfg.AllCertificates()) > 0 {
+		return nil, nil, fmt.Errorf("acme can't be combined with certificate files")
+	}
+
+	m, err := certs.NewACMEManager(certs.ACMEOptions{
+		Domains:      cfg.ACME.Domains,
+		Email:        cfg.ACME.Email,
+		DirectoryURL: cfg.ACME.DirectoryURL,
+		CacheDir:     cfg.ACME.CacheDir,
+		CACertFile:   cfg.ACME.CACertFile,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var challengeServer *http.Server
+	if cfg.ACME.HTTPListen != "" {
+		challengeServer = &http.Server{
+			Addr:    cfg.ACME.HTTPListen,
+			Handler: m.HTTPHandler(nil),
+		}
+	}
+
+	return certs.ACMETLSConfig(m), challengeServer, nil
+}
@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Namespace prefixes the names of all metrics.
+const Namespace = "router_auth_gw"
+
+// Auth results.
+const (
+	AuthSuccess   = "success"
+	AuthAnonymous = "anonymous"
+	AuthFailure   = "failure"
+)
+
+// Device login results.
+const (
+	LoginSuccess = "success"
+	LoginFailure = "failure"
+)
+
+// Registry holds the gateway metrics and the Go runtime and process
+// collectors.
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+var (
+	Requests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "http_requests_total",
+		Help:      "Requests handled by the entrypoints.",
+	}, []string{"entrypoint", "device", "user", "method", "status"})
+
+	RequestDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time to handle requests of the entrypoints.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"entrypoint", "device", "method", "status"})
+
+	Auth = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "auth_total",
+		Help:      "Authentication outcomes of the entrypoints: success, anonymous or failure.",
+	}, []string{"entrypoint", "device", "result"})
+
+	DeviceLogins = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "device_logins_total",
+		Help:      "Logins of the gateway to the devices, made when a session is missing or expired.",
+	}, []string{"device", "user", "result"})
+
+	WebsocketSessions = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: Namespace,
+		Name:      "websocket_sessions",
+		Help:      "Active WebSocket sessions proxied to the devices.",
+	}, []string{"entrypoint", "device"})
+
+	UpstreamErrors = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Name:      "upstream_errors_total",
+		Help:      "Failed requests to the devices by error class.",
+	}, []string{"entrypoint", "device", "class"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,89 @@
+package mqtt
+
+import (
+	"fmt"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	operationTimeout  = 10 * time.Second
+	disconnectTimeout = 250 // milliseconds
+)
+
+// Client is the part of an MQTT client used by the bridge.
+type Client interface {
+	Publish(topic string, retained bool, payload []byte) error
+	Subscribe(topic string, handler func(topic string, payload []byte, retained bool)) error
+	Disconnect()
+}
+
+// options are the connection settings of a client. OnConnect is called after
+// every connection, including reconnects.
+type options struct {
+	Broker    string
+	ClientID  string
+	Username  string
+	Password  string
+	WillTopic string
+	OnConnect func(Client)
+}
+
+type pahoClient struct {
+	c paho.Client
+}
+
+// dial connects to the broker in the background, retrying until it succeeds
+// and reconnecting when the connection is lost.
+func dial(o options) Client {
+	opts := paho.NewClientOptions().
+		AddBroker(o.Broker).
+		SetClientID(o.ClientID).
+		SetUsername(o.Username).
+		SetPassword(o.Password).
+		SetWill(o.WillTopic, payloadOffline, 1, true).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetOrderMatters(false)
+
+	c := &pahoClient{}
+	opts.SetOnConnectHandler(func(paho.Client) {
+		log.Info().Str("broker", o.Broker).Msg("connected to mqtt broker")
+		o.OnConnect(c)
+	})
+	opts.SetConnectionLostHandler(func(_ paho.Client, err error) {
+		log.Warn().Err(err).Str("broker", o.Broker).Msg("mqtt connection lost")
+	})
+
+	c.c = paho.NewClient(opts)
+	c.c.Connect()
+	return c
+}
+
+func (c *pahoClient) Publish(topic string, retained bool, payload []byte) error {
+	// Retained state is published again on connect, there is no need to
+	// queue it while the connection is down.
+	if !c.c.IsConnectionOpen() {
+		return fmt.Errorf("not connected")
+	}
+	return wait(c.c.Publish(topic, 1, retained, payload))
+}
+
+func (c *pahoClient) Subscribe(topic string, handler func(topic string, payload []byte, retained bool)) error {
+	return wait(c.c.Subscribe(topic, 1, func(_ paho.Client, m paho.Message) {
+		handler(m.Topic(), m.Payload(), m.Retained())
+	}))
+}
+
+func (c *pahoClient) Disconnect() {
+	c.c.Disconnect(disconnectTimeout)
+}
+
+func wait(t paho.Token) error {
+	if !t.WaitTimeout(operationTimeout) {
+		return fmt.Errorf("mqtt operation timed out")
+	}
+	return t.Error()
+}
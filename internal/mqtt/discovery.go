@@ -0,0 +1,134 @@
+package mqtt
+
+import (
+	"regexp"
+	"sort"
+)
+
+var invalidIDChars = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// discovery is a Home Assistant MQTT discovery payload.
+type discovery struct {
+	Name              string          `json:"name"`
+	UniqueID          string          `json:"unique_id"`
+	ObjectID          string          `json:"object_id,omitempty"`
+	StateTopic        string          `json:"state_topic,omitempty"`
+	ValueTemplate     string          `json:"value_template,omitempty"`
+	CommandTopic      string          `json:"command_topic,omitempty"`
+	PayloadPress      string          `json:"payload_press,omitempty"`
+	DeviceClass       string          `json:"device_class,omitempty"`
+	StateClass        string          `json:"state_class,omitempty"`
+	Icon              string          `json:"icon,omitempty"`
+	AvailabilityTopic string          `json:"availability_topic"`
+	Device            discoveryDevice `json:"device"`
+}
+
+type discoveryDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Model        string   `json:"model,omitempty"`
+	SWVersion    string   `json:"sw_version,omitempty"`
+	Manufacturer string   `json:"manufacturer,omitempty"`
+}
+
+// discoveryMessage is a discovery payload with its config topic.
+type discoveryMessage struct {
+	topic   string
+	payload discovery
+}
+
+// discoveryMessages returns the entities of every device: reachability, the
+// number of clients and the WAN state, and a button for every action.
+func (b *Bridge) discoveryMessages() []discoveryMessage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	devices := make(map[string]discoveryDevice)
+	for _, d := range b.devices {
+		state := b.states[d.Tag]
+		devices[d.Tag] = discoveryDevice{
+			Identifiers:  []string{nodeID(d.Tag)},
+			Name:         d.Tag,
+			Model:        state.Model,
+			SWVersion:    state.Firmware,
+			Manufacturer: manufacturer(d.Type),
+		}
+	}
+
+	var msgs []discoveryMessage
+	add := func(component, tag, object string, p discovery) {
+		p.UniqueID = nodeID(tag) + "_" + object
+		p.ObjectID = p.UniqueID
+		p.AvailabilityTopic = b.availabilityTopic()
+		p.Device = devices[tag]
+		msgs = append(msgs, discoveryMessage{
+			topic:   b.discoveryPrefix + "/" + component + "/" + nodeID(tag) + "/" + object + "/config",
+			payload: p,
+		})
+	}
+
+	for _, d := range b.devices {
+		stateTopic := b.deviceTopic(d.Tag, "state")
+		add("binary_sensor", d.Tag, "reachable", discovery{
+			Name:          "Reachable",
+			StateTopic:    stateTopic,
+			ValueTemplate: "{{ 'ON' if value_json.reachable else 'OFF' }}",
+			DeviceClass:   "connectivity",
+		})
+		add("sensor", d.Tag, "clients", discovery{
+			Name:          "Clients",
+			StateTopic:    stateTopic,
+			ValueTemplate: "{{ value_json.clients | default(0) }}",
+			StateClass:    "measurement",
+			Icon:          "mdi:devices",
+		})
+		add("binary_sensor", d.Tag, "wan", discovery{
+			Name:          "WAN",
+			StateTopic:    stateTopic,
+			ValueTemplate: "{{ 'ON' if value_json.wan_up else 'OFF' }}",
+			DeviceClass:   "connectivity",
+			Icon:          "mdi:wan",
+		})
+	}
+
+	names := make([]string, 0, len(b.actions))
+	for name := range b.actions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		tag := b.actions[name].DeviceTag
+		if _, ok := devices[tag]; !ok {
+			devices[tag] = discoveryDevice{Identifiers: []string{nodeID(tag)}, Name: tag}
+		}
+		add("button", tag, "action_"+invalidIDChars.ReplaceAllString(name, "_"), discovery{
+			Name:         name,
+			CommandTopic: b.prefix + "/action/" + name + "/run",
+			PayloadPress: "{}",
+		})
+	}
+
+	return msgs
+}
+
+func (b *Bridge) publishDiscovery(c Client) {
+	for _, m := range b.discoveryMessages() {
+		b.publishJSON(c, m.topic, m.payload)
+	}
+}
+
+// nodeID returns the Home Assistant node ID of a device, which may only
+// contain letters, digits, "_" and "-".
+func nodeID(tag string) string {
+	return "router_auth_gw_" + invalidIDChars.ReplaceAllString(tag, "_")
+}
+
+func manufacturer(deviceType string) string {
+	switch deviceType {
+	case "keenetic":
+		return "Keenetic"
+	case "glinet":
+		return "GL.iNet"
+	}
+	return ""
+}
@@ -0,0 +1,366 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mazzz1y/router-auth-gw/internal/action"
+	"github.com/mazzz1y/router-auth-gw/internal/config"
+	"github.com/mazzz1y/router-auth-gw/internal/device"
+	"github.com/mazzz1y/router-auth-gw/internal/worker"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultInterval        = time.Minute
+	defaultTopicPrefix     = "router-auth-gw"
+	defaultDiscoveryPrefix = "homeassistant"
+	pollTimeout            = 30 * time.Second
+	actionTimeout          = time.Minute
+
+	payloadOnline  = "online"
+	payloadOffline = "offline"
+)
+
+// State is the status of a device, published to <prefix>/<device>/state.
+type State struct {
+	Reachable bool      `json:"reachable"`
+	Model     string    `json:"model,omitempty"`
+	Firmware  string    `json:"firmware,omitempty"`
+	Clients   *int      `json:"clients,omitempty"`
+	WANUp     *bool     `json:"wan_up,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// ActionResult is published to <prefix>/action/<name>/result after a command.
+type ActionResult struct {
+	Status int    `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Bridge publishes the status of the devices to an MQTT broker and runs
+// actions on command messages.
+type Bridge struct {
+	cfg             config.MQTTConfig
+	prefix          string
+	discoveryPrefix string
+	interval        time.Duration
+	devices         []device.Device
+	actions         map[string]*action.Action
+	dial            func(options) Client
+
+	mu     sync.Mutex
+	client Client
+	states map[string]State
+	group  worker.Group
+}
+
+// New creates the bridge of the configuration. Without a broker Start does
+// nothing.
+func New(cfg config.MQTTConfig, dm *device.Manager, actions map[string]*action.Action) (*Bridge, error) {
+	b := &Bridge{
+		cfg:             cfg,
+		prefix:          strings.TrimRight(cfg.TopicPrefix, "/"),
+		discoveryPrefix: strings.TrimRight(cfg.DiscoveryPrefix, "/"),
+		interval:        cfg.Interval,
+		actions:         make(map[string]*action.Action),
+		dial:            dial,
+		states:          make(map[string]State),
+	}
+	if !cfg.Enabled() {
+		return b, nil
+	}
+	if b.prefix == "" {
+		b.prefix = defaultTopicPrefix
+	}
+	if b.discoveryPrefix == "" {
+		b.discoveryPrefix = defaultDiscoveryPrefix
+	}
+	if b.interval <= 0 {
+		b.interval = defaultInterval
+	}
+	if b.cfg.ClientID == "" {
+		hostname, _ := os.Hostname()
+		b.cfg.ClientID = "router-auth-gw-" + hostname
+	}
+
+	devices, err := dm.Select(cfg.Devices)
+	if err != nil {
+		return nil, fmt.Errorf("mqtt: %w", err)
+	}
+	b.devices = devices
+
+	for _, name := range cfg.Actions {
+		a, ok := actions[name]
+		if !ok {
+			return nil, fmt.Errorf("mqtt: action %q not found", name)
+		}
+		if len(a.Users) > 0 && !a.AllowMQTT {
+			return nil, fmt.Errorf("mqtt: action %q is restricted to users, set allow_mqtt to run it by mqtt", name)
+		}
+		b.actions[name] = a
+	}
+
+	return b, nil
+}
+
+// Start connects to the broker and polls the devices every interval.
+func (b *Bridge) Start(ctx context.Context) {
+	if !b.cfg.Enabled() {
+		return
+	}
+
+	ctx = b.group.Start(ctx)
+	client := b.dial(options{
+		Broker:    b.cfg.Broker,
+		ClientID:  b.cfg.ClientID,
+		Username:  b.cfg.Username,
+		Password:  b.cfg.Password,
+		WillTopic: b.availabilityTopic(),
+		OnConnect: func(c Client) { b.connected(ctx, c) },
+	})
+
+	b.mu.Lock()
+	b.client = client
+	b.mu.Unlock()
+
+	b.group.Go(func() { worker.Every(ctx, b.interval, b.poll) })
+}
+
+// Stop stops polling, waits for running commands and disconnects, marking
+// the gateway offline.
+func (b *Bridge) Stop() {
+	if !b.group.Stop() {
+		return
+	}
+
+	b.mu.Lock()
+	client := b.client
+	b.mu.Unlock()
+
+	if err := client.Publish(b.availabilityTopic(), true, []byte(payloadOffline)); err != nil {
+		log.Debug().Err(err).Msg("failed to publish mqtt availability")
+	}
+	client.Disconnect()
+}
+
+// connected subscribes to the commands and publishes the availability,
+// discovery payloads and last states, which the broker may have lost.
+func (b *Bridge) connected(ctx context.Context, c Client) {
+	commands := b.prefix + "/action/+/run"
+	if err := c.Subscribe(commands, func(topic string, payload []byte, retained bool) {
+		b.command(ctx, c, topic, payload, retained)
+	}); err != nil {
+		log.Error().Err(err).Str("topic", commands).Msg("failed to subscribe to mqtt topic")
+	}
+
+	if b.cfg.Discovery {
+		// Home Assistant announces restarts here, it needs the discovery
+		// payloads again unless they are retained by the broker.
+		status := b.discoveryPrefix + "/status"
+		if err := c.Subscribe(status, func(_ string, payload []byte, _ bool) {
+			if string(payload) == payloadOnline {
+				b.publishDiscovery(c)
+			}
+		}); err != nil {
+			log.Error().Err(err).Str("topic", status).Msg("failed to subscribe to mqtt topic")
+		}
+	}
+
+	b.publish(c, b.availabilityTopic(), []byte(payloadOnline))
+	if b.cfg.Discovery {
+		b.publishDiscovery(c)
+	}
+
+	b.mu.Lock()
+	states := make(map[string]State, len(b.states))
+	for tag, s := range b.states {
+		states[tag] = s
+	}
+	b.mu.Unlock()
+	for tag, s := range states {
+		b.publishJSON(c, b.deviceTopic(tag, "state"), s)
+	}
+}
+
+// poll reads the status of every device and publishes it.
+func (b *Bridge) poll(ctx context.Context) {
+	b.mu.Lock()
+	c := b.client
+	b.mu.Unlock()
+
+	identified := false
+	for _, d := range b.devices {
+		state, hosts, wan := readStatus(ctx, d)
+		if ctx.Err() != nil {
+			return
+		}
+
+		b.mu.Lock()
+		prev := b.states[d.Tag]
+		b.states[d.Tag] = state
+		b.mu.Unlock()
+		if state.Reachable && (state.Model != prev.Model || state.Firmware != prev.Firmware) {
+			identified = true
+		}
+
+		b.publishJSON(c, b.deviceTopic(d.Tag, "state"), state)
+		if hosts != nil {
+			b.publishJSON(c, b.deviceTopic(d.Tag, "clients"), hosts)
+		}
+		if wan != nil {
+			b.publishJSON(c, b.deviceTopic(d.Tag, "wan"), wan)
+		}
+	}
+
+	// The discovery payloads name the model and firmware of the devices.
+	if identified && b.cfg.Discovery {
+		b.publishDiscovery(c)
+	}
+}
+
+// command runs the action of a <prefix>/action/<name>/run message. The
+// payload is an optional JSON object of params. Messages carry no user, the
+// users of an action are not checked. Retained messages are ignored, the
+// broker delivers them again on every connection.
+func (b *Bridge) command(ctx context.Context, c Client, topic string, payload []byte, retained bool) {
+	name := strings.TrimSuffix(strings.TrimPrefix(topic, b.prefix+"/action/"), "/run")
+	l := log.With().Str("action", name).Logger()
+
+	if retained {
+		l.Warn().Msg("ignoring retained mqtt command")
+		return
+	}
+
+	a, ok := b.actions[name]
+	if !ok {
+		l.Warn().Msg("mqtt command for unknown action")
+		return
+	}
+
+	params := make(map[string]string)
+	if len(strings.TrimSpace(string(payload))) > 0 {
+		if err := json.Unmarshal(payload, &params); err != nil {
+			l.Warn().Err(err).Msg("invalid mqtt command payload")
+			b.publishResult(c, name, ActionResult{Error: "payload must be a JSON object of string params"})
+			return
+		}
+	}
+
+	// Commands are not started once Stop waits for the running ones.
+	b.group.Go(func() {
+		ctx, cancel := context.WithTimeout(ctx, actionTimeout)
+		defer cancel()
+
+		res, err := a.Run(ctx, params)
+		if err != nil {
+			l.Error().Err(err).Msg("mqtt command failed")
+			b.publishResult(c, name, ActionResult{Error: err.Error()})
+			return
+		}
+		l.Info().Int("status", res.StatusCode).Msg("mqtt command completed")
+		b.publishResult(c, name, ActionResult{Status: res.StatusCode})
+	})
+}
+
+func (b *Bridge) publishResult(c Client, name string, res ActionResult) {
+	data, _ := json.Marshal(res)
+	if err := c.Publish(b.prefix+"/action/"+name+"/result", false, data); err != nil {
+		log.Error().Err(err).Str("action", name).Msg("failed to publish mqtt command result")
+	}
+}
+
+func (b *Bridge) publishJSON(c Client, topic string, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Error().Err(err).Str("topic", topic).Msg("failed to encode mqtt payload")
+		return
+	}
+	b.publish(c, topic, data)
+}
+
+// publish sends a retained message.
+func (b *Bridge) publish(c Client, topic string, payload []byte) {
+	if err := c.Publish(topic, true, payload); err != nil {
+		log.Debug().Err(err).Str("topic", topic).Msg("failed to publish mqtt message")
+	}
+}
+
+func (b *Bridge) availabilityTopic() string {
+	return b.prefix + "/status"
+}
+
+func (b *Bridge) deviceTopic(tag, name string) string {
+	return b.prefix + "/" + tag + "/" + name
+}
+
+// readStatus reads the identity, online hosts and WAN state of a device. The
+// device is reachable if its identity can be read, hosts and WAN are nil if
+// they can't be read.
+func readStatus(ctx context.Context, d device.Device) (State, []device.Host, []device.WAN) {
+	state := State{Time: time.Now().UTC()}
+
+	driver, err := newDriver(d)
+	if err != nil {
+		state.Error = err.Error()
+		return state, nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, pollTimeout)
+	defer cancel()
+
+	identity, err := driver.Identity(ctx)
+	if err != nil {
+		state.Error = err.Error()
+		return state, nil, nil
+	}
+	state.Reachable = true
+	state.Model, state.Firmware = identity.Model, identity.Firmware
+
+	l := log.With().Str("device", d.Tag).Logger()
+
+	hosts, err := driver.Hosts(ctx)
+	if err != nil && !errors.Is(err, device.ErrNotSupported) {
+		l.Warn().Err(err).Msg("failed to list hosts")
+	}
+	var online []device.Host
+	if err == nil {
+		online = make([]device.Host, 0, len(hosts))
+		for _, h := range hosts {
+			if h.Online {
+				online = append(online, h)
+			}
+		}
+		clients := len(online)
+		state.Clients = &clients
+	}
+
+	wan, err := driver.WAN(ctx)
+	if err != nil && !errors.Is(err, device.ErrNotSupported) {
+		l.Warn().Err(err).Msg("failed to read wan state")
+	}
+	if err == nil {
+		up := false
+		for _, w := range wan {
+			up = up || w.Up
+		}
+		state.WANUp = &up
+	}
+
+	return state, online, wan
+}
+
+func newDriver(d device.Device) (device.Driver, error) {
+	client, err := d.User("")
+	if err != nil {
+		return nil, err
+	}
+	return device.NewDriver(client)
+}
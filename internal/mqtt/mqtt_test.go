@@ -0,0 +1,245 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/mazzz1y/router-auth-gw/internal/action"
+	"github.com/mazzz1y/router-auth-gw/internal/config"
+	"github.com/mazzz1y/router-auth-gw/internal/device"
+	"github.com/mazzz1y/router-auth-gw/internal/device/devicetest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type message struct {
+	payload  string
+	retained bool
+}
+
+type mockClient struct {
+	mu           sync.Mutex
+	messages     map[string]message
+	subs         map[string]func(string, []byte, bool)
+	disconnected bool
+}
+
+func (m *mockClient) Publish(topic string, retained bool, payload []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages[topic] = message{payload: string(payload), retained: retained}
+	return nil
+}
+
+func (m *mockClient) Subscribe(topic string, handler func(string, []byte, bool)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.subs[topic] = handler
+	return nil
+}
+
+func (m *mockClient) Disconnect() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.disconnected = true
+}
+
+func (m *mockClient) message(topic string) (message, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	msg, ok := m.messages[topic]
+	return msg, ok
+}
+
+func TestBridge(t *testing.T) {
+	dev := &devicetest.Client{
+		Info: device.Identity{Model: "Giga", Firmware: "4.2"},
+		Clients: []device.Host{
+			{MAC: "aa:bb:cc:dd:ee:01", Hostname: "pc", Online: true},
+			{MAC: "aa:bb:cc:dd:ee:02", Hostname: "phone"},
+		},
+		WANs: []device.WAN{{Interface: "PPPoE0", Up: true, IP: "203.0.113.1"}},
+	}
+	dm := &device.Manager{Devices: map[string]device.Device{
+		"home.router": {Tag: "home.router", Type: "keenetic", Users: []device.User{{Name: "admin", Client: dev}}},
+	}}
+	actions, err := action.New(map[string]config.ActionConfig{
+		"reboot": {DeviceTag: "home.router", Method: "POST", Path: "/rci/system/reboot"},
+	}, dm)
+	require.NoError(t, err)
+
+	_, err = New(config.MQTTConfig{Broker: "tcp://broker:1883", Actions: []string{"missing"}}, dm, actions)
+	assert.EqualError(t, err, `mqtt: action "missing" not found`)
+
+	restricted, err := action.New(map[string]config.ActionConfig{
+		"reboot": {DeviceTag: "home.router", Path: "/rci/system/reboot", Users: []string{"alice"}},
+	}, dm)
+	require.NoError(t, err)
+	_, err = New(config.MQTTConfig{Broker: "tcp://broker:1883", Actions: []string{"reboot"}}, dm, restricted)
+	assert.EqualError(t, err, `mqtt: action "reboot" is restricted to users, set allow_mqtt to run it by mqtt`)
+	restricted["reboot"].AllowMQTT = true
+	_, err = New(config.MQTTConfig{Broker: "tcp://broker:1883", Actions: []string{"reboot"}}, dm, restricted)
+	assert.NoError(t, err)
+
+	b, err := New(config.MQTTConfig{Broker: "tcp://broker:1883", Actions: []string{"reboot"}, Discovery: true}, dm, actions)
+	require.NoError(t, err)
+
+	client := &mockClient{messages: make(map[string]message), subs: make(map[string]func(string, []byte, bool))}
+	var opts options
+	b.dial = func(o options) Client {
+		opts = o
+		o.OnConnect(client)
+		return client
+	}
+
+	b.Start(context.Background())
+	assert.Equal(t, "router-auth-gw/status", opts.WillTopic)
+
+	assert.Eventually(t, func() bool {
+		_, ok := client.message("router-auth-gw/home.router/wan")
+		return ok
+	}, 5*time.Second, 10*time.Millisecond)
+
+	msg, _ := client.message("router-auth-gw/status")
+	assert.Equal(t, message{payload: "online", retained: true}, msg)
+
+	msg, _ = client.message("router-auth-gw/home.router/state")
+	assert.True(t, msg.retained)
+	var state State
+	require.NoError(t, json.Unmarshal([]byte(msg.payload), &state))
+	assert.True(t, state.Reachable)
+	assert.Equal(t, "Giga", state.Model)
+	assert.Equal(t, 1, *state.Clients)
+	assert.True(t, *state.WANUp)
+
+	msg, _ = client.message("router-auth-gw/home.router/clients")
+	assert.JSONEq(t, `[{"mac":"aa:bb:cc:dd:ee:01","hostname":"pc","online":true}]`, msg.payload)
+
+	msg, ok := client.message("homeassistant/binary_sensor/router_auth_gw_home_router/reachable/config")
+	require.True(t, ok)
+	var d discovery
+	require.NoError(t, json.Unmarshal([]byte(msg.payload), &d))
+	assert.Equal(t, "router_auth_gw_home_router_reachable", d.UniqueID)
+	assert.Equal(t, "router-auth-gw/home.router/state", d.StateTopic)
+	assert.Equal(t, "router-auth-gw/status", d.AvailabilityTopic)
+	assert.Equal(t, discoveryDevice{
+		Identifiers:  []string{"router_auth_gw_home_router"},
+		Name:         "home.router",
+		Model:        "Giga",
+		SWVersion:    "4.2",
+		Manufacturer: "Keenetic",
+	}, d.Device)
+
+	msg, ok = client.message("homeassistant/button/router_auth_gw_home_router/action_reboot/config")
+	require.True(t, ok)
+	assert.Contains(t, msg.payload, `"command_topic":"router-auth-gw/action/reboot/run"`)
+
+	run := client.subs["router-auth-gw/action/+/run"]
+	require.NotNil(t, run)
+	run("router-auth-gw/action/reboot/run", []byte(`{"unknown": "x"}`), false)
+	assert.Eventually(t, func() bool {
+		msg, _ := client.message("router-auth-gw/action/reboot/result")
+		return msg.payload == `{"error":"param \"unknown\": unknown param"}`
+	}, 5*time.Second, 10*time.Millisecond)
+
+	run("router-auth-gw/action/reboot/run", nil, true)
+	run("router-auth-gw/action/reboot/run", nil, false)
+	assert.Eventually(t, func() bool {
+		msg, _ := client.message("router-auth-gw/action/reboot/result")
+		return msg.payload == `{"status":200}`
+	}, 5*time.Second, 10*time.Millisecond)
+
+	b.Stop()
+	msg, _ = client.message("router-auth-gw/status")
+	assert.Equal(t, "offline", msg.payload)
+	assert.True(t, client.disconnected)
+
+	reboots := slices.DeleteFunc(dev.Requests(), func(r string) bool { return r != "POST /rci/system/reboot" })
+	assert.Len(t, reboots, 1, "retained commands don't run")
+}
+
+// TestBrokerIntegration runs the bridge against a real broker, e.g. with
+// MQTT_TEST_BROKER=tcp://localhost:1883 and a local mosquitto.
+func TestBrokerIntegration(t *testing.T) {
+	broker := os.Getenv("MQTT_TEST_BROKER")
+	if broker == "" {
+		t.Skip("MQTT_TEST_BROKER is not set")
+	}
+
+	dev := &devicetest.Client{
+		Info:    device.Identity{Model: "Giga", Firmware: "4.2"},
+		Clients: []device.Host{{MAC: "aa:bb:cc:dd:ee:01", Hostname: "pc", Online: true}},
+	}
+	dm := &device.Manager{Devices: map[string]device.Device{
+		"router": {Tag: "router", Type: "keenetic", Users: []device.User{{Name: "admin", Client: dev}}},
+	}}
+	actions, err := action.New(map[string]config.ActionConfig{
+		"reboot": {DeviceTag: "router", Path: "/rci/system/reboot"},
+	}, dm)
+	require.NoError(t, err)
+
+	prefix := fmt.Sprintf("router-auth-gw-test-%d", time.Now().UnixNano())
+	b, err := New(config.MQTTConfig{
+		Broker:      broker,
+		ClientID:    prefix,
+		TopicPrefix: prefix,
+		Interval:    100 * time.Millisecond,
+		Actions:     []string{"reboot"},
+	}, dm, actions)
+	require.NoError(t, err)
+
+	var (
+		mu       sync.Mutex
+		received = make(map[string]string)
+	)
+	sub := paho.NewClient(paho.NewClientOptions().AddBroker(broker).SetClientID(prefix + "-sub"))
+	require.NoError(t, wait(sub.Connect()))
+	defer sub.Disconnect(disconnectTimeout)
+	require.NoError(t, wait(sub.Subscribe(prefix+"/#", 1, func(_ paho.Client, m paho.Message) {
+		mu.Lock()
+		defer mu.Unlock()
+		received[m.Topic()] = string(m.Payload())
+	})))
+	payload := func(topic string) string {
+		mu.Lock()
+		defer mu.Unlock()
+		return received[topic]
+	}
+
+	b.Start(context.Background())
+
+	assert.Eventually(t, func() bool {
+		return payload(prefix+"/status") == payloadOnline && payload(prefix+"/router/clients") != ""
+	}, 10*time.Second, 50*time.Millisecond)
+	assert.JSONEq(t, `[{"mac":"aa:bb:cc:dd:ee:01","hostname":"pc","online":true}]`, payload(prefix+"/router/clients"))
+
+	require.NoError(t, wait(sub.Publish(prefix+"/action/reboot/run", 1, false, "")))
+	assert.Eventually(t, func() bool {
+		return payload(prefix+"/action/reboot/result") == `{"status":200}`
+	}, 10*time.Second, 50*time.Millisecond)
+	assert.Contains(t, dev.Requests(), "POST /rci/system/reboot")
+
+	b.Stop()
+	assert.Eventually(t, func() bool {
+		return payload(prefix+"/status") == payloadOffline
+	}, 10*time.Second, 50*time.Millisecond)
+
+	// Clear the retained messages of the test.
+	mu.Lock()
+	topics := make([]string, 0, len(received))
+	for topic := range received {
+		topics = append(topics, topic)
+	}
+	mu.Unlock()
+	for _, topic := range topics {
+		_ = wait(sub.Publish(topic, 1, true, ""))
+	}
+}
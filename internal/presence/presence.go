@@ -0,0 +1,241 @@
+package presence
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mazzz1y/router-auth-gw/internal/config"
+	"github.com/mazzz1y/router-auth-gw/internal/device"
+	"github.com/mazzz1y/router-auth-gw/internal/webhook"
+	"github.com/mazzz1y/router-auth-gw/internal/worker"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultInterval = 30 * time.Second
+	pollTimeout     = 30 * time.Second
+	subscriberQueue = 64
+)
+
+// Event names.
+const (
+	EventJoin  = "host_join"
+	EventLeave = "host_leave"
+)
+
+// Event is a LAN host that came online or went offline.
+type Event struct {
+	Event    string    `json:"event"`
+	Device   string    `json:"device"`
+	MAC      string    `json:"mac"`
+	IP       string    `json:"ip,omitempty"`
+	Hostname string    `json:"hostname,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// Poller periodically lists the online hosts of the devices and emits events
+// for the changes.
+type Poller struct {
+	interval time.Duration
+	devices  []device.Device
+	hooks    *webhook.Dispatcher
+
+	mu      sync.Mutex
+	online  map[string]map[string]device.Host
+	subs    map[chan Event]struct{}
+	stopped bool
+	group   worker.Group
+}
+
+// New creates the poller of the configuration, events are emitted to hooks,
+// which may be nil. A disabled poller polls no devices.
+func New(cfg config.PresenceConfig, dm *device.Manager, hooks *webhook.Dispatcher) (*Poller, error) {
+	p := &Poller{
+		interval: cfg.Interval,
+		hooks:    hooks,
+		online:   make(map[string]map[string]device.Host),
+		subs:     make(map[chan Event]struct{}),
+	}
+	if !cfg.Enabled {
+		return p, nil
+	}
+	if p.interval <= 0 {
+		p.interval = defaultInterval
+	}
+
+	devices, err := dm.Select(cfg.Devices)
+	if err != nil {
+		return nil, fmt.Errorf("presence: %w", err)
+	}
+	p.devices = devices
+
+	return p, nil
+}
+
+// Polls reports whether the hosts of the device are polled.
+func (p *Poller) Polls(tag string) bool {
+	for _, d := range p.devices {
+		if d.Tag == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Inherit takes over the online hosts of a previous poller, so that a reload
+// doesn't emit events for hosts that didn't change.
+func (p *Poller) Inherit(prev *Poller) {
+	prev.mu.Lock()
+	defer prev.mu.Unlock()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for tag, hosts := range prev.online {
+		p.online[tag] = hosts
+	}
+}
+
+// Start polls every device in the background.
+func (p *Poller) Start(ctx context.Context) {
+	if len(p.devices) == 0 {
+		return
+	}
+
+	ctx = p.group.Start(ctx)
+	for _, d := range p.devices {
+		p.group.Go(func() {
+			worker.Every(ctx, p.interval, func(ctx context.Context) { p.poll(ctx, d) })
+		})
+	}
+}
+
+// Stop stops polling and ends all subscriptions.
+func (p *Poller) Stop() {
+	p.group.Stop()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stopped = true
+	for ch := range p.subs {
+		close(ch)
+		delete(p.subs, ch)
+	}
+}
+
+// Subscribe returns a channel of all events and a function to unsubscribe.
+// Events are dropped for subscribers that don't keep up. The channel is
+// closed when the poller stops.
+func (p *Poller) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, subscriberQueue)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopped {
+		close(ch)
+		return ch, func() {}
+	}
+	p.subs[ch] = struct{}{}
+
+	return ch, func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if _, ok := p.subs[ch]; ok {
+			close(ch)
+			delete(p.subs, ch)
+		}
+	}
+}
+
+// poll lists the hosts of the device and emits the changes. The first poll
+// of a device only records the online hosts.
+func (p *Poller) poll(ctx context.Context, d device.Device) {
+	l := log.With().Str("device", d.Tag).Logger()
+
+	hosts, err := listHosts(ctx, d)
+	if err != nil {
+		l.Error().Err(err).Msg("failed to list hosts")
+		return
+	}
+
+	online := make(map[string]device.Host, len(hosts))
+	for _, h := range hosts {
+		if h.Online {
+			online[h.MAC] = h
+		}
+	}
+
+	p.mu.Lock()
+	prev, polled := p.online[d.Tag]
+	p.online[d.Tag] = online
+	p.mu.Unlock()
+
+	if !polled {
+		return
+	}
+
+	now := time.Now().UTC()
+	var events []Event
+	for mac, h := range online {
+		if _, ok := prev[mac]; !ok {
+			events = append(events, newEvent(EventJoin, d.Tag, h, now))
+		}
+	}
+	for mac, h := range prev {
+		if _, ok := online[mac]; !ok {
+			events = append(events, newEvent(EventLeave, d.Tag, h, now))
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].MAC < events[j].MAC })
+
+	for _, e := range events {
+		p.emit(e)
+	}
+}
+
+func (p *Poller) emit(e Event) {
+	l := log.With().
+		Str("device", e.Device).
+		Str("mac", e.MAC).
+		Str("ip", e.IP).
+		Str("hostname", e.Hostname).
+		Logger()
+	if e.Event == EventJoin {
+		l.Info().Msg("host joined")
+	} else {
+		l.Info().Msg("host left")
+	}
+
+	p.mu.Lock()
+	for ch := range p.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+	p.mu.Unlock()
+
+	p.hooks.Emit(e.Event, e.Device, e)
+}
+
+func newEvent(name, tag string, h device.Host, t time.Time) Event {
+	return Event{Event: name, Device: tag, MAC: h.MAC, IP: h.IP, Hostname: h.Hostname, Time: t}
+}
+
+func listHosts(ctx context.Context, d device.Device) ([]device.Host, error) {
+	client, err := d.User("")
+	if err != nil {
+		return nil, err
+	}
+
+	driver, err := device.NewDriver(client)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, pollTimeout)
+	defer cancel()
+	return driver.Hosts(ctx)
+}
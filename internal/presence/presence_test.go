@@ -0,0 +1,95 @@
+package presence
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mazzz1y/router-auth-gw/internal/config"
+	"github.com/mazzz1y/router-auth-gw/internal/device"
+	"github.com/mazzz1y/router-auth-gw/internal/device/devicetest"
+	"github.com/mazzz1y/router-auth-gw/internal/webhook"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPoller(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		posted []Event
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p struct{ Data Event }
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&p))
+		mu.Lock()
+		posted = append(posted, p.Data)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	hooks, err := webhook.NewDispatcher(config.WebhooksConfig{Endpoints: []config.WebhookConfig{{URL: server.URL}}})
+	require.NoError(t, err)
+	hooks.Start(context.Background())
+	defer hooks.Stop()
+
+	pc := device.Host{MAC: "aa:bb:cc:dd:ee:01", IP: "192.168.1.10", Hostname: "pc", Online: true}
+	phone := device.Host{MAC: "aa:bb:cc:dd:ee:02", IP: "192.168.1.11", Hostname: "phone", Online: true}
+
+	client := &devicetest.Client{Clients: []device.Host{pc, {MAC: "aa:bb:cc:dd:ee:03"}}}
+	dm := &device.Manager{Devices: map[string]device.Device{
+		"router": {Tag: "router", Users: []device.User{{Name: "admin", Client: client}}},
+	}}
+
+	_, err = New(config.PresenceConfig{Enabled: true, Devices: []string{"missing"}}, dm, nil)
+	assert.EqualError(t, err, `presence: device "missing" not found`)
+
+	p, err := New(config.PresenceConfig{Enabled: true}, dm, hooks)
+	require.NoError(t, err)
+	assert.True(t, p.Polls("router"))
+
+	events, unsubscribe := p.Subscribe()
+	defer unsubscribe()
+
+	ctx := context.Background()
+	d := dm.Devices["router"]
+
+	p.poll(ctx, d)
+	assert.Empty(t, events)
+
+	client.SetClients([]device.Host{phone})
+	p.poll(ctx, d)
+
+	var received []Event
+	for range 2 {
+		received = append(received, <-events)
+	}
+	assert.Equal(t, EventLeave, received[0].Event)
+	assert.Equal(t, "pc", received[0].Hostname)
+	assert.Equal(t, EventJoin, received[1].Event)
+	assert.Equal(t, "router", received[1].Device)
+	assert.Equal(t, "aa:bb:cc:dd:ee:02", received[1].MAC)
+	assert.Equal(t, "192.168.1.11", received[1].IP)
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return assert.ObjectsAreEqual(received, posted)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	reloaded, err := New(config.PresenceConfig{Enabled: true}, dm, nil)
+	require.NoError(t, err)
+	reloaded.Inherit(p)
+	reloadedEvents, unsubscribeReloaded := reloaded.Subscribe()
+	defer unsubscribeReloaded()
+	reloaded.poll(ctx, d)
+	assert.Empty(t, reloadedEvents)
+
+	p.Stop()
+	_, ok := <-events
+	assert.False(t, ok)
+}
@@ -0,0 +1,428 @@
+package schedule
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mazzz1y/router-auth-gw/internal/action"
+	"github.com/mazzz1y/router-auth-gw/internal/config"
+	"github.com/mazzz1y/router-auth-gw/internal/device"
+	"github.com/mazzz1y/router-auth-gw/internal/worker"
+	"github.com/robfig/cron/v3"
+	"github.com/rs/zerolog/log"
+)
+
+// Missed run policies.
+const (
+	MissedRunSkip    = "skip"
+	MissedRunRunOnce = "run_once"
+)
+
+const runTimeout = time.Minute
+
+// Status is the state of a schedule as shown by /_gw/api/schedules.
+type Status struct {
+	Name         string     `json:"name"`
+	Cron         string     `json:"cron"`
+	Device       string     `json:"device"`
+	Action       string     `json:"action,omitempty"`
+	Request      string     `json:"request,omitempty"`
+	Running      bool       `json:"running"`
+	NextRun      *time.Time `json:"next_run,omitempty"`
+	LastRun      *time.Time `json:"last_run,omitempty"`
+	LastDuration string     `json:"last_duration,omitempty"`
+	LastStatus   int        `json:"last_status,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+}
+
+// Scheduler runs the configured schedules until it is stopped.
+type Scheduler struct {
+	jobs      []*job
+	stateFile string
+
+	mu    sync.Mutex
+	group worker.Group
+}
+
+type job struct {
+	name      string
+	spec      string
+	device    string
+	action    string
+	request   string
+	schedule  cron.Schedule
+	jitter    time.Duration
+	missedRun string
+	run       func(ctx context.Context) (int, error)
+
+	// Guarded by Scheduler.mu. next is the jittered time of the pending
+	// run scheduled at pending. A schedule changed by a reload while the
+	// previous one was pending has no missed runs.
+	pending time.Time
+	next    time.Time
+	last    runState
+	running bool
+	changed bool
+}
+
+// runState is the result of the last run, it is persisted in the state file.
+type runState struct {
+	Scheduled time.Time     `json:"scheduled"`
+	Started   time.Time     `json:"started"`
+	Duration  time.Duration `json:"duration"`
+	Status    int           `json:"status,omitempty"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// Parse parses a standard 5-field cron expression or a descriptor such as
+// "@daily" or "@every 1h".
+func Parse(spec string) (cron.Schedule, error) {
+	return cron.ParseStandard(spec)
+}
+
+// New creates the schedules of the configuration without starting them.
+func New(cfg map[string]config.ScheduleConfig, stateFile string, dm *device.Manager, actions map[string]*action.Action) (*Scheduler, error) {
+	s := &Scheduler{stateFile: stateFile}
+	for name, c := range cfg {
+		j, err := newJob(name, c, dm, actions)
+		if err != nil {
+			return nil, fmt.Errorf("schedule %q: %w", name, err)
+		}
+		s.jobs = append(s.jobs, j)
+	}
+	sort.Slice(s.jobs, func(i, k int) bool { return s.jobs[i].name < s.jobs[k].name })
+	return s, nil
+}
+
+func newJob(name string, c config.ScheduleConfig, dm *device.Manager, actions map[string]*action.Action) (*job, error) {
+	schedule, err := Parse(c.Cron)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cron expression: %w", err)
+	}
+
+	j := &job{
+		name:      name,
+		spec:      c.Cron,
+		schedule:  schedule,
+		jitter:    c.Jitter,
+		missedRun: c.MissedRun,
+	}
+
+	switch j.missedRun {
+	case "":
+		j.missedRun = MissedRunSkip
+	case MissedRunSkip, MissedRunRunOnce:
+	default:
+		return nil, fmt.Errorf("unsupported missed_run %q", c.MissedRun)
+	}
+
+	if c.Action != "" {
+		if c.Path != "" {
+			return nil, errors.New("action and path can't be combined")
+		}
+
+		a, ok := actions[c.Action]
+		if !ok {
+			return nil, fmt.Errorf("action %q not found", c.Action)
+		}
+		if _, err := a.Params(c.Params); err != nil {
+			return nil, err
+		}
+
+		j.device, j.action = a.DeviceTag, a.Name
+		j.run = func(ctx context.Context) (int, error) {
+			res, err := a.Run(ctx, c.Params)
+			if err != nil {
+				return 0, err
+			}
+			return res.StatusCode, nil
+		}
+		return j, nil
+	}
+
+	if c.Path == "" {
+		return nil, errors.New("action or path is required")
+	}
+
+	d, ok := dm.Devices[c.DeviceTag]
+	if !ok {
+		return nil, fmt.Errorf("device %q not found", c.DeviceTag)
+	}
+	client, err := d.User(c.User)
+	if err != nil {
+		return nil, err
+	}
+
+	method := strings.ToUpper(c.Method)
+	if method == "" {
+		method = http.MethodPost
+	}
+
+	j.device, j.request = c.DeviceTag, method+" "+c.Path
+	j.run = func(ctx context.Context) (int, error) {
+		res, err := client.Request(ctx, method, c.Path, c.Body)
+		if err != nil {
+			return 0, err
+		}
+		defer res.Body.Close()
+		io.Copy(io.Discard, res.Body)
+		return res.StatusCode, nil
+	}
+	return j, nil
+}
+
+// Inherit takes over the last runs of the schedules of a previous scheduler,
+// so that a reload doesn't reset them. Pending runs of unchanged schedules
+// keep their jittered time, changed schedules start over from their new spec.
+func (s *Scheduler) Inherit(prev *Scheduler) {
+	prev.mu.Lock()
+	jobs := make(map[string]job, len(prev.jobs))
+	for _, j := range prev.jobs {
+		jobs[j.name] = job{spec: j.spec, jitter: j.jitter, pending: j.pending, next: j.next, last: j.last}
+	}
+	prev.mu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, j := range s.jobs {
+		p, ok := jobs[j.name]
+		if !ok {
+			continue
+		}
+		j.last = p.last
+		if p.spec == j.spec && p.jitter == j.jitter {
+			j.pending, j.next = p.pending, p.next
+		} else {
+			j.changed = !p.pending.IsZero()
+		}
+	}
+}
+
+// Start runs the schedules in the background. Requests are canceled with
+// ctx, not by Stop.
+func (s *Scheduler) Start(ctx context.Context) {
+	s.loadState()
+
+	runCtx := ctx
+	ctx = s.group.Start(ctx)
+	for _, j := range s.jobs {
+		s.group.Go(func() { s.loop(ctx, runCtx, j) })
+	}
+
+	if len(s.jobs) > 0 {
+		log.Info().Int("schedules", len(s.jobs)).Msg("scheduler started")
+	}
+}
+
+// Stop stops the schedules and waits for running requests to finish, so
+// that a reload doesn't fail them and the next scheduler inherits their
+// results.
+func (s *Scheduler) Stop() {
+	s.group.Stop()
+}
+
+// Status returns the state of all schedules sorted by name.
+func (s *Scheduler) Status() []Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := make([]Status, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		st := Status{
+			Name:    j.name,
+			Cron:    j.spec,
+			Device:  j.device,
+			Action:  j.action,
+			Request: j.request,
+			Running: j.running,
+		}
+		if !j.next.IsZero() {
+			next := j.next
+			st.NextRun = &next
+		}
+		if !j.last.Started.IsZero() {
+			started := j.last.Started
+			st.LastRun = &started
+			st.LastDuration = j.last.Duration.String()
+			st.LastStatus = j.last.Status
+			st.LastError = j.last.Error
+		}
+		res = append(res, st)
+	}
+	return res
+}
+
+// loop waits for the runs of the job until ctx is canceled, the requests are
+// made with runCtx.
+func (s *Scheduler) loop(ctx, runCtx context.Context, j *job) {
+	s.mu.Lock()
+	last, scheduled, at, changed := j.last.Scheduled, j.pending, j.next, j.changed
+	s.mu.Unlock()
+
+	if scheduled.IsZero() && !last.IsZero() && !changed {
+		now := time.Now()
+		if missed := j.schedule.Next(last); missed.Before(now) {
+			l := log.With().Str("schedule", j.name).Time("missed", missed).Logger()
+			switch {
+			case now.Before(missed.Add(j.jitter)):
+				// Still within the jitter of the run, it isn't missed.
+				scheduled, at = missed, now.Add(rand.N(missed.Add(j.jitter).Sub(now)))
+			case j.missedRun == MissedRunRunOnce:
+				l.Info().Msg("running missed schedule")
+				s.run(runCtx, j, missed)
+			default:
+				l.Info().Msg("skipping missed schedule")
+			}
+		}
+	}
+
+	for {
+		if scheduled.IsZero() {
+			scheduled = j.schedule.Next(time.Now())
+			at = scheduled
+			if j.jitter > 0 {
+				at = at.Add(rand.N(j.jitter))
+			}
+		}
+
+		s.mu.Lock()
+		j.pending, j.next = scheduled, at
+		s.mu.Unlock()
+
+		timer := time.NewTimer(time.Until(at))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		s.run(runCtx, j, scheduled)
+		scheduled = time.Time{}
+	}
+}
+
+func (s *Scheduler) run(ctx context.Context, j *job, scheduled time.Time) {
+	s.mu.Lock()
+	j.running = true
+	s.mu.Unlock()
+
+	started := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, runTimeout)
+	status, err := j.run(ctx)
+	cancel()
+
+	if err == nil && status >= http.StatusBadRequest {
+		err = fmt.Errorf("device responded with status %d", status)
+	}
+
+	st := runState{
+		Scheduled: scheduled,
+		Started:   started,
+		Duration:  time.Since(started),
+		Status:    status,
+	}
+
+	l := log.With().
+		Str("schedule", j.name).
+		Str("device", j.device).
+		Int("status", status).
+		Dur("duration", st.Duration).
+		Logger()
+	if err != nil {
+		st.Error = err.Error()
+		l.Error().Err(err).Msg("scheduled run failed")
+	} else {
+		l.Info().Msg("scheduled run completed")
+	}
+
+	s.mu.Lock()
+	j.running = false
+	j.last = st
+	s.mu.Unlock()
+
+	s.saveState()
+}
+
+func (s *Scheduler) loadState() {
+	if s.stateFile == "" {
+		return
+	}
+
+	data, err := os.ReadFile(s.stateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		log.Warn().Err(err).Msg("failed to read schedule state")
+		return
+	}
+
+	var state map[string]runState
+	if err := json.Unmarshal(data, &state); err != nil {
+		log.Warn().Err(err).Msg("failed to parse schedule state")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, j := range s.jobs {
+		if l, ok := state[j.name]; ok && j.last.Scheduled.IsZero() {
+			j.last = l
+		}
+	}
+}
+
+// saveState writes the last runs to the state file. The file is replaced
+// atomically, so a crash never leaves a truncated state behind.
+func (s *Scheduler) saveState() {
+	if s.stateFile == "" {
+		return
+	}
+
+	s.mu.Lock()
+	state := make(map[string]runState, len(s.jobs))
+	for _, j := range s.jobs {
+		if !j.last.Scheduled.IsZero() {
+			state[j.name] = j.last
+		}
+	}
+	s.mu.Unlock()
+
+	if err := writeFile(s.stateFile, state); err != nil {
+		log.Warn().Err(err).Msg("failed to save schedule state")
+	}
+}
+
+func writeFile(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,264 @@
+package schedule
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mazzz1y/router-auth-gw/internal/action"
+	"github.com/mazzz1y/router-auth-gw/internal/config"
+	"github.com/mazzz1y/router-auth-gw/internal/device"
+	"golang.org/x/net/websocket"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockClient struct {
+	mu       sync.Mutex
+	requests []string
+	status   int
+	done     chan struct{}
+}
+
+func (m *mockClient) Request(ctx context.Context, method, endpoint, _ string) (*http.Response, error) {
+	m.mu.Lock()
+	m.requests = append(m.requests, method+" "+endpoint)
+	m.mu.Unlock()
+
+	if m.done != nil {
+		m.done <- struct{}{}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		StatusCode: m.status,
+		Body:       io.NopCloser(strings.NewReader(`{}`)),
+	}, nil
+}
+
+func (m *mockClient) Websocket() (*websocket.Conn, error) {
+	return nil, nil
+}
+
+func newManager(client device.ClientWrapper) *device.Manager {
+	return &device.Manager{Devices: map[string]device.Device{
+		"router": {Tag: "router", Users: []device.User{{Name: "admin", Client: client}}},
+	}}
+}
+
+func TestNew(t *testing.T) {
+	dm := newManager(&mockClient{status: http.StatusOK})
+	actions, err := action.New(map[string]config.ActionConfig{
+		"guest-wifi": {
+			DeviceTag: "router",
+			Path:      "/rci/interface/{{ .iface }}",
+			Params:    map[string]config.ActionParamConfig{"iface": {Required: true}},
+		},
+	}, dm)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		cfg      config.ScheduleConfig
+		expected string
+	}{
+		{"Invalid cron", config.ScheduleConfig{Cron: "0 4 *", Path: "/rci/system/reboot", DeviceTag: "router"}, "invalid cron expression"},
+		{"Unknown action", config.ScheduleConfig{Cron: "@daily", Action: "missing"}, `action "missing" not found`},
+		{"Missing param", config.ScheduleConfig{Cron: "@daily", Action: "guest-wifi"}, `param "iface": required`},
+		{"No target", config.ScheduleConfig{Cron: "@daily"}, "action or path is required"},
+		{"Unknown user", config.ScheduleConfig{Cron: "@daily", Path: "/rci/system/reboot", DeviceTag: "router", User: "bob"}, `user "bob" not found`},
+		{"Missed run policy", config.ScheduleConfig{Cron: "@daily", Action: "guest-wifi", MissedRun: "always"}, `unsupported missed_run "always"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(map[string]config.ScheduleConfig{"job": tt.cfg}, "", dm, actions)
+			assert.ErrorContains(t, err, tt.expected)
+		})
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	client := &mockClient{status: http.StatusOK, done: make(chan struct{}, 1)}
+	s, err := New(map[string]config.ScheduleConfig{
+		"reboot": {Cron: "@every 1s", DeviceTag: "router", Path: "/rci/system/reboot"},
+	}, "", newManager(client), nil)
+	require.NoError(t, err)
+
+	status := s.Status()
+	require.Len(t, status, 1)
+	assert.Equal(t, "POST /rci/system/reboot", status[0].Request)
+	assert.Nil(t, status[0].LastRun)
+
+	s.Start(context.Background())
+	select {
+	case <-client.done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("schedule didn't run")
+	}
+	s.Stop()
+
+	status = s.Status()
+	assert.NotNil(t, status[0].LastRun)
+	assert.NotNil(t, status[0].NextRun)
+	assert.Equal(t, http.StatusOK, status[0].LastStatus)
+	assert.Empty(t, status[0].LastError)
+
+	t.Run("Stop lets the running request finish", func(t *testing.T) {
+		client := &mockClient{status: http.StatusOK, done: make(chan struct{})}
+		s, err := New(map[string]config.ScheduleConfig{
+			"reboot": {Cron: "@every 1s", DeviceTag: "router", Path: "/rci/system/reboot"},
+		}, "", newManager(client), nil)
+		require.NoError(t, err)
+
+		s.Start(context.Background())
+		require.Eventually(t, func() bool { return s.Status()[0].Running }, 3*time.Second, 10*time.Millisecond)
+
+		stopped := make(chan struct{})
+		go func() {
+			s.Stop()
+			close(stopped)
+		}()
+		assert.Never(t, func() bool {
+			select {
+			case <-stopped:
+				return true
+			default:
+				return false
+			}
+		}, 100*time.Millisecond, 10*time.Millisecond, "Stop waits for the request")
+
+		<-client.done
+		<-stopped
+		status := s.Status()
+		assert.Equal(t, http.StatusOK, status[0].LastStatus)
+		assert.Empty(t, status[0].LastError)
+	})
+}
+
+func TestMissedRun(t *testing.T) {
+	for _, policy := range []string{MissedRunSkip, MissedRunRunOnce} {
+		t.Run(policy, func(t *testing.T) {
+			stateFile := filepath.Join(t.TempDir(), "state.json")
+			state := map[string]runState{"reboot": {Scheduled: time.Now().Add(-2 * time.Hour)}}
+			data, err := json.Marshal(state)
+			require.NoError(t, err)
+			require.NoError(t, os.WriteFile(stateFile, data, 0o600))
+
+			client := &mockClient{status: http.StatusServiceUnavailable}
+			s, err := New(map[string]config.ScheduleConfig{
+				"reboot": {Cron: "0 * * * *", DeviceTag: "router", Path: "/rci/system/reboot", MissedRun: policy},
+			}, stateFile, newManager(client), nil)
+			require.NoError(t, err)
+
+			s.Start(context.Background())
+			require.Eventually(t, func() bool { return s.Status()[0].NextRun != nil }, time.Second, 10*time.Millisecond)
+			s.Stop()
+
+			if policy == MissedRunSkip {
+				assert.Empty(t, client.requests)
+				return
+			}
+
+			assert.Equal(t, []string{"POST /rci/system/reboot"}, client.requests)
+			assert.Equal(t, "device responded with status 503", s.Status()[0].LastError)
+
+			data, err = os.ReadFile(stateFile)
+			require.NoError(t, err)
+			require.NoError(t, json.Unmarshal(data, &state))
+			assert.Equal(t, http.StatusServiceUnavailable, state["reboot"].Status)
+		})
+	}
+
+	t.Run("Within jitter", func(t *testing.T) {
+		client := &mockClient{status: http.StatusOK}
+		s, err := New(map[string]config.ScheduleConfig{
+			"reboot": {Cron: "0 * * * *", Jitter: 3 * time.Hour, DeviceTag: "router", Path: "/rci/system/reboot"},
+		}, "", newManager(client), nil)
+		require.NoError(t, err)
+
+		last := time.Now().Add(-2 * time.Hour)
+		s.jobs[0].last = runState{Scheduled: last}
+		pending := s.jobs[0].schedule.Next(last)
+
+		start := time.Now()
+		s.Start(context.Background())
+		require.Eventually(t, func() bool { return s.Status()[0].NextRun != nil }, time.Second, 10*time.Millisecond)
+		s.Stop()
+
+		assert.Empty(t, client.requests)
+		assert.Equal(t, pending, s.jobs[0].pending, "the run is pending, not skipped")
+		assert.WithinRange(t, *s.Status()[0].NextRun, start, pending.Add(3*time.Hour))
+	})
+}
+
+func TestInherit(t *testing.T) {
+	dm := newManager(&mockClient{status: http.StatusOK})
+	cfg := map[string]config.ScheduleConfig{
+		"reboot": {Cron: "@daily", DeviceTag: "router", Path: "/rci/system/reboot"},
+	}
+
+	prev, err := New(cfg, "", dm, nil)
+	require.NoError(t, err)
+	prev.run(context.Background(), prev.jobs[0], time.Now())
+
+	s, err := New(cfg, "", dm, nil)
+	require.NoError(t, err)
+	s.Inherit(prev)
+	assert.Equal(t, prev.Status(), s.Status())
+
+	t.Run("Pending run", func(t *testing.T) {
+		cfg := map[string]config.ScheduleConfig{
+			"reboot": {Cron: "@daily", Jitter: time.Hour, DeviceTag: "router", Path: "/rci/system/reboot"},
+		}
+		prev, err := New(cfg, "", dm, nil)
+		require.NoError(t, err)
+		prev.Start(context.Background())
+		require.Eventually(t, func() bool { return prev.Status()[0].NextRun != nil }, time.Second, 10*time.Millisecond)
+		prev.Stop()
+
+		s, err := New(cfg, "", dm, nil)
+		require.NoError(t, err)
+		s.Inherit(prev)
+		s.Start(context.Background())
+		defer s.Stop()
+		assert.Equal(t, prev.Status()[0].NextRun, s.Status()[0].NextRun, "the jittered time is kept")
+
+		cfg["reboot"] = config.ScheduleConfig{Cron: "@daily", Jitter: 2 * time.Hour, DeviceTag: "router", Path: "/rci/system/reboot"}
+		changed, err := New(cfg, "", dm, nil)
+		require.NoError(t, err)
+		changed.Inherit(prev)
+		assert.Nil(t, changed.Status()[0].NextRun, "changed schedules are rescheduled")
+	})
+
+	t.Run("Changed schedule has no missed run", func(t *testing.T) {
+		client := &mockClient{status: http.StatusOK}
+		dm := newManager(client)
+		cfg := map[string]config.ScheduleConfig{
+			"reboot": {Cron: "0 * * * *", DeviceTag: "router", Path: "/rci/system/reboot", MissedRun: MissedRunRunOnce},
+		}
+		prev, err := New(cfg, "", dm, nil)
+		require.NoError(t, err)
+		prev.jobs[0].last = runState{Scheduled: time.Now().Add(-2 * time.Hour)}
+		prev.jobs[0].pending = time.Now().Add(time.Hour)
+
+		cfg["reboot"] = config.ScheduleConfig{Cron: "30 * * * *", DeviceTag: "router", Path: "/rci/system/reboot", MissedRun: MissedRunRunOnce}
+		s, err := New(cfg, "", dm, nil)
+		require.NoError(t, err)
+		s.Inherit(prev)
+		s.Start(context.Background())
+		require.Eventually(t, func() bool { return s.Status()[0].NextRun != nil }, time.Second, 10*time.Millisecond)
+		s.Stop()
+
+		assert.Empty(t, client.requests)
+	})
+}
@@ -0,0 +1,229 @@
+package shell
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/term"
+)
+
+// rootCommands are completed in addition to the RCI tree, which contains
+// only configuration commands.
+var rootCommands = []string{"exit", "no", "show"}
+
+// Client runs CLI commands on a device, it is implemented by keenetic.Client.
+type Client interface {
+	Parse(ctx context.Context, command string) (json.RawMessage, error)
+	Request(ctx context.Context, method, endpoint, body string) (*http.Response, error)
+}
+
+// Shell is an interactive CLI of a Keenetic device over RCI.
+type Shell struct {
+	ctx    context.Context
+	client Client
+	out    io.Writer
+
+	treeOnce sync.Once
+	tree     map[string][]string
+}
+
+func New(ctx context.Context, client Client) *Shell {
+	return &Shell{
+		ctx:    ctx,
+		client: client,
+		out:    io.Discard,
+	}
+}
+
+// Run reads commands from the terminal until "exit" or EOF. The terminal
+// keeps the history of the session, Tab completes commands.
+func (s *Shell) Run(rw io.ReadWriter, prompt string) error {
+	t := term.NewTerminal(rw, prompt)
+	t.AutoCompleteCallback = s.Complete
+	s.out = t
+
+	for {
+		line, err := t.ReadLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		line = strings.TrimSpace(line)
+		switch line {
+		case "":
+			continue
+		case "exit", "quit":
+			return nil
+		}
+
+		if err := s.Exec(t, line); err != nil {
+			fmt.Fprintf(t, "error: %v\n", err)
+		}
+	}
+}
+
+// Exec runs a command and writes the formatted response.
+func (s *Shell) Exec(w io.Writer, command string) error {
+	res, err := s.client.Parse(s.ctx, command)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(Format(res))
+	return err
+}
+
+// Complete is a term.Terminal AutoCompleteCallback completing the word
+// before the cursor from the RCI command tree of the device.
+func (s *Shell) Complete(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' {
+		return "", 0, false
+	}
+
+	prefix := line[:pos]
+	words := strings.Fields(prefix)
+	partial := ""
+	if len(words) > 0 && !strings.HasSuffix(prefix, " ") {
+		partial = words[len(words)-1]
+		words = words[:len(words)-1]
+	}
+
+	var candidates []string
+	for _, c := range s.children(words) {
+		if strings.HasPrefix(c, partial) {
+			candidates = append(candidates, c)
+		}
+	}
+
+	switch len(candidates) {
+	case 0:
+		return "", 0, false
+	case 1:
+		completion := candidates[0][len(partial):] + " "
+		return prefix + completion + line[pos:], pos + len(completion), true
+	}
+
+	if common := commonPrefix(candidates); len(common) > len(partial) {
+		completion := common[len(partial):]
+		return prefix + completion + line[pos:], pos + len(completion), true
+	}
+
+	fmt.Fprintln(s.out, strings.Join(candidates, "  "))
+	return "", 0, false
+}
+
+// children returns the commands which can follow the words. The tree is
+// read from the device on the first completion and kept for the session,
+// there is no completion if it can't be read.
+func (s *Shell) children(words []string) []string {
+	s.treeOnce.Do(func() {
+		tree, err := s.readTree()
+		if err != nil {
+			return
+		}
+		tree[""] = append(tree[""], rootCommands...)
+		sort.Strings(tree[""])
+		s.tree = tree
+	})
+
+	if len(words) > 0 && words[0] == "no" {
+		words = words[1:]
+	}
+	return s.tree[strings.Join(words, " ")]
+}
+
+// readTree returns the child commands of every node of the RCI tree by the
+// words before them.
+func (s *Shell) readTree() (map[string][]string, error) {
+	res, err := s.client.Request(s.ctx, http.MethodGet, "/rci/", "")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+
+	var root map[string]any
+	if err := json.NewDecoder(res.Body).Decode(&root); err != nil {
+		return nil, fmt.Errorf("failed to decode command tree: %w", err)
+	}
+
+	tree := make(map[string][]string)
+	walkTree(tree, nil, root)
+	return tree, nil
+}
+
+func walkTree(tree map[string][]string, words []string, node map[string]any) {
+	names := make([]string, 0, len(node))
+	for name, child := range node {
+		names = append(names, name)
+		if m, ok := child.(map[string]any); ok {
+			walkTree(tree, append(words[:len(words):len(words)], name), m)
+		}
+	}
+	sort.Strings(names)
+	tree[strings.Join(words, " ")] = names
+}
+
+type status struct {
+	Status  string `json:"status"`
+	Code    string `json:"code"`
+	Ident   string `json:"ident"`
+	Message string `json:"message"`
+}
+
+// Format prints the messages of a parse response as text and the rest of it
+// as indented JSON.
+func Format(res json.RawMessage) []byte {
+	var buf bytes.Buffer
+
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(res, &obj); err == nil && (obj["status"] != nil || obj["prompt"] != nil) {
+		var statuses []status
+		if err := json.Unmarshal(obj["status"], &statuses); err == nil {
+			for _, st := range statuses {
+				if st.Status == "error" {
+					fmt.Fprintf(&buf, "error: %s\n", st.Message)
+				} else {
+					fmt.Fprintf(&buf, "%s\n", st.Message)
+				}
+			}
+			delete(obj, "status")
+		}
+		delete(obj, "prompt")
+
+		if len(obj) == 0 {
+			return buf.Bytes()
+		}
+		res, _ = json.Marshal(obj)
+	}
+
+	if err := json.Indent(&buf, res, "", "  "); err != nil {
+		buf.Write(res)
+	}
+	buf.WriteByte('\n')
+
+	return buf.Bytes()
+}
+
+func commonPrefix(s []string) string {
+	prefix := s[0]
+	for _, v := range s[1:] {
+		for !strings.HasPrefix(v, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
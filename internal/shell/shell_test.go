@@ -0,0 +1,110 @@
+package shell
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/mazzz1y/router-auth-gw/internal/device/devicetest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testTree = `{"interface":{},"ip":{"dhcp":{"host":[],"pool":{}},"dns":{},"hotspot":{},"http":{}},"system":{"hostname":"kn"}}`
+
+type mockClient struct {
+	devicetest.Client
+	commands []string
+}
+
+func (m *mockClient) Parse(_ context.Context, command string) (json.RawMessage, error) {
+	m.commands = append(m.commands, command)
+	if command == "show version" {
+		return json.RawMessage(`{"model":"Giga","title":"4.1.7"}`), nil
+	}
+	return json.RawMessage(`{"prompt":"(config)","status":[{"status":"error","message":"no such command"}]}`), nil
+}
+
+func TestComplete(t *testing.T) {
+	client := &mockClient{Client: devicetest.Client{Responses: map[string]string{"GET /rci/": testTree}}}
+	s := New(context.Background(), client)
+	var out bytes.Buffer
+	s.out = &out
+
+	tests := []struct {
+		line    string
+		newLine string
+		ok      bool
+	}{
+		{line: "sy", newLine: "system ", ok: true},
+		{line: "ip h", newLine: "ip h", ok: false},
+		{line: "ip ho", newLine: "ip hotspot ", ok: true},
+		{line: "no ip d", newLine: "no ip d", ok: false},
+		{line: "ip dh", newLine: "ip dhcp ", ok: true},
+		{line: "ip dhcp p", newLine: "ip dhcp pool ", ok: true},
+		{line: "sh", newLine: "show ", ok: true},
+		{line: "interface x", newLine: "", ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			newLine, newPos, ok := s.Complete(tt.line, len(tt.line), '\t')
+			assert.Equal(t, tt.ok, ok)
+			if ok {
+				assert.Equal(t, tt.newLine, newLine)
+				assert.Equal(t, len(tt.newLine), newPos)
+			}
+		})
+	}
+
+	assert.Contains(t, out.String(), "hotspot  http")
+	assert.Contains(t, out.String(), "dhcp  dns")
+	assert.Empty(t, client.commands)
+	assert.Equal(t, []string{"GET /rci/"}, client.Requests(), "the tree is read once")
+
+	t.Run("Other keys", func(t *testing.T) {
+		_, _, ok := s.Complete("sy", 2, 'a')
+		assert.False(t, ok)
+	})
+
+	t.Run("Common prefix", func(t *testing.T) {
+		s.tree["ip"] = []string{"hotspot", "host"}
+		newLine, _, ok := s.Complete("ip h", 4, '\t')
+		assert.True(t, ok)
+		assert.Equal(t, "ip ho", newLine)
+	})
+
+	t.Run("Unreadable tree", func(t *testing.T) {
+		s := New(context.Background(), &mockClient{})
+		_, _, ok := s.Complete("sy", 2, '\t')
+		assert.False(t, ok)
+		_, _, ok = s.Complete("sh", 2, '\t')
+		assert.False(t, ok)
+	})
+}
+
+func TestFormat(t *testing.T) {
+	assert.Equal(t, "error: no such command\n",
+		string(Format(json.RawMessage(`{"prompt":"(config)","status":[{"status":"error","message":"no such command"}]}`))))
+
+	assert.Equal(t, "{\n  \"model\": \"Giga\"\n}\n", string(Format(json.RawMessage(`{"model":"Giga"}`))))
+}
+
+func TestRun(t *testing.T) {
+	client := &mockClient{}
+	s := New(context.Background(), client)
+
+	var out bytes.Buffer
+	rw := struct {
+		io.Reader
+		io.Writer
+	}{strings.NewReader("show version\r\rsystem foo\rexit\r"), &out}
+
+	assert.NoError(t, s.Run(rw, "> "))
+	assert.Equal(t, []string{"show version", "system foo"}, client.commands)
+	assert.Contains(t, out.String(), `"model": "Giga"`)
+	assert.Contains(t, out.String(), "error: no such command")
+}
@@ -0,0 +1,222 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mazzz1y/router-auth-gw/internal/config"
+	"github.com/mazzz1y/router-auth-gw/internal/device"
+	"github.com/mazzz1y/router-auth-gw/internal/metrics"
+	"github.com/mazzz1y/router-auth-gw/internal/worker"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultInterval = time.Minute
+	scrapeTimeout   = 30 * time.Second
+	subsystem       = "router"
+)
+
+func newDesc(name, help string, labels ...string) *prometheus.Desc {
+	return prometheus.NewDesc(prometheus.BuildFQName(metrics.Namespace, subsystem, name), help, append([]string{"device"}, labels...), nil)
+}
+
+var (
+	upDesc              = newDesc("up", "Whether the last scrape of the device succeeded.")
+	scrapeDurationDesc  = newDesc("scrape_duration_seconds", "Duration of the last scrape of the device.")
+	infoDesc            = newDesc("info", "Model and firmware of the device.", "model", "firmware")
+	cpuLoadDesc         = newDesc("cpu_load_percent", "CPU load of the device.")
+	loadAverageDesc     = newDesc("load1", "1 minute load average of the device.")
+	memoryTotalDesc     = newDesc("memory_total_bytes", "Total memory of the device.")
+	memoryAvailableDesc = newDesc("memory_available_bytes", "Free memory of the device, including buffers and cache.")
+	uptimeDesc          = newDesc("uptime_seconds", "Uptime of the device.")
+	rxBytesDesc         = newDesc("interface_receive_bytes_total", "Bytes received by an interface of the device.", "interface")
+	txBytesDesc         = newDesc("interface_transmit_bytes_total", "Bytes transmitted by an interface of the device.", "interface")
+	wifiClientsDesc     = newDesc("wifi_clients", "Wi-Fi clients of an access point of the device.", "interface")
+	wanUpDesc           = newDesc("wan_up", "Whether a WAN connection of the device is up.", "interface")
+)
+
+// sample is the result of the last scrape of a device. Identity and WAN are
+// kept from the scrape if they could be read.
+type sample struct {
+	up        bool
+	duration  time.Duration
+	identity  *device.Identity
+	telemetry device.Telemetry
+	wan       []device.WAN
+}
+
+// Exporter periodically scrapes the devices and exposes the results as
+// Prometheus metrics with the gateway metrics.
+type Exporter struct {
+	interval time.Duration
+	devices  []device.Device
+
+	mu      sync.Mutex
+	samples map[string]sample
+	group   worker.Group
+}
+
+// New creates the exporter of the configuration. A disabled exporter has no
+// devices.
+func New(cfg config.TelemetryConfig, dm *device.Manager) (*Exporter, error) {
+	e := &Exporter{
+		interval: cfg.Interval,
+		samples:  make(map[string]sample),
+	}
+	if !cfg.Enabled {
+		return e, nil
+	}
+	if e.interval <= 0 {
+		e.interval = defaultInterval
+	}
+
+	devices, err := dm.Select(cfg.Devices)
+	if err != nil {
+		return nil, fmt.Errorf("telemetry: %w", err)
+	}
+	e.devices = devices
+
+	return e, nil
+}
+
+// Start registers the metrics and scrapes every device in the background.
+func (e *Exporter) Start(ctx context.Context) {
+	if len(e.devices) == 0 {
+		return
+	}
+	if err := metrics.Registry.Register(e); err != nil {
+		log.Error().Err(err).Msg("failed to register telemetry metrics")
+		return
+	}
+
+	ctx = e.group.Start(ctx)
+	for _, d := range e.devices {
+		e.group.Go(func() {
+			worker.Every(ctx, e.interval, func(ctx context.Context) { e.scrape(ctx, d) })
+		})
+	}
+}
+
+// Stop stops scraping and unregisters the metrics.
+func (e *Exporter) Stop() {
+	if e.group.Stop() {
+		metrics.Registry.Unregister(e)
+	}
+}
+
+// scrape reads the telemetry, identity and WAN state of a device. The device
+// is up if its telemetry can be read.
+func (e *Exporter) scrape(ctx context.Context, d device.Device) {
+	start := time.Now()
+	l := log.With().Str("device", d.Tag).Logger()
+
+	ctx, cancel := context.WithTimeout(ctx, scrapeTimeout)
+	defer cancel()
+
+	var s sample
+	driver, err := newDriver(d)
+	if err == nil {
+		s.telemetry, err = driver.Telemetry(ctx)
+	}
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return
+	}
+	if err != nil {
+		l.Warn().Err(err).Msg("failed to scrape device telemetry")
+	} else {
+		s.up = true
+
+		if identity, err := driver.Identity(ctx); err == nil {
+			s.identity = &identity
+		} else {
+			l.Warn().Err(err).Msg("failed to read device identity")
+		}
+		s.wan, err = driver.WAN(ctx)
+		if err != nil && !errors.Is(err, device.ErrNotSupported) {
+			l.Warn().Err(err).Msg("failed to read wan state")
+		}
+	}
+	s.duration = time.Since(start)
+
+	e.mu.Lock()
+	e.samples[d.Tag] = s
+	e.mu.Unlock()
+}
+
+// Describe implements prometheus.Collector.
+func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{
+		upDesc, scrapeDurationDesc, infoDesc, cpuLoadDesc, loadAverageDesc, memoryTotalDesc,
+		memoryAvailableDesc, uptimeDesc, rxBytesDesc, txBytesDesc, wifiClientsDesc, wanUpDesc,
+	} {
+		ch <- d
+	}
+}
+
+// Collect implements prometheus.Collector with the results of the last
+// scrapes, devices are not scraped by Collect.
+func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	gauge := func(desc *prometheus.Desc, v float64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v, labels...)
+	}
+	counter := func(desc *prometheus.Desc, v float64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, v, labels...)
+	}
+
+	for tag, s := range e.samples {
+		gauge(upDesc, boolValue(s.up), tag)
+		gauge(scrapeDurationDesc, s.duration.Seconds(), tag)
+		if !s.up {
+			continue
+		}
+
+		if s.identity != nil {
+			gauge(infoDesc, 1, tag, s.identity.Model, s.identity.Firmware)
+		}
+
+		t := s.telemetry
+		if t.CPULoad != nil {
+			gauge(cpuLoadDesc, *t.CPULoad, tag)
+		}
+		if t.LoadAverage != nil {
+			gauge(loadAverageDesc, *t.LoadAverage, tag)
+		}
+		gauge(memoryTotalDesc, float64(t.MemoryTotal), tag)
+		gauge(memoryAvailableDesc, float64(t.MemoryAvailable), tag)
+		gauge(uptimeDesc, float64(t.Uptime), tag)
+
+		for _, i := range t.Interfaces {
+			counter(rxBytesDesc, float64(i.RxBytes), tag, i.Interface)
+			counter(txBytesDesc, float64(i.TxBytes), tag, i.Interface)
+		}
+		for ap, n := range t.WirelessClients {
+			gauge(wifiClientsDesc, float64(n), tag, ap)
+		}
+		for _, w := range s.wan {
+			gauge(wanUpDesc, boolValue(w.Up), tag, w.Interface)
+		}
+	}
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func newDriver(d device.Device) (device.Driver, error) {
+	client, err := d.User("")
+	if err != nil {
+		return nil, err
+	}
+	return device.NewDriver(client)
+}
@@ -0,0 +1,101 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/mazzz1y/router-auth-gw/internal/config"
+	"github.com/mazzz1y/router-auth-gw/internal/device"
+	"github.com/mazzz1y/router-auth-gw/internal/device/devicetest"
+	"github.com/mazzz1y/router-auth-gw/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newClient() *devicetest.Client {
+	cpuLoad := 12.5
+	return &devicetest.Client{
+		Info: device.Identity{Model: "Giga", Firmware: "4.2"},
+		WANs: []device.WAN{{Interface: "PPPoE0", Up: true}, {Interface: "UsbLte0"}},
+		Stats: &device.Telemetry{
+			CPULoad:         &cpuLoad,
+			MemoryTotal:     1024,
+			MemoryAvailable: 512,
+			Uptime:          3600,
+			Interfaces:      []device.InterfaceTraffic{{Interface: "PPPoE0", RxBytes: 1000, TxBytes: 200}},
+			WirelessClients: map[string]int{"WifiMaster0/AccessPoint0": 2},
+		},
+	}
+}
+
+func TestExporter(t *testing.T) {
+	remote := newClient()
+	remote.Err = errors.New("connection refused")
+	dm := &device.Manager{Devices: map[string]device.Device{
+		"home":   {Tag: "home", Users: []device.User{{Name: "admin", Client: newClient()}}},
+		"remote": {Tag: "remote", Users: []device.User{{Name: "admin", Client: remote}}},
+	}}
+
+	_, err := New(config.TelemetryConfig{Enabled: true, Devices: []string{"missing"}}, dm)
+	assert.EqualError(t, err, `telemetry: device "missing" not found`)
+
+	e, err := New(config.TelemetryConfig{Enabled: true}, dm)
+	require.NoError(t, err)
+	for _, d := range e.devices {
+		e.scrape(context.Background(), d)
+	}
+
+	expected := `
+# HELP router_auth_gw_router_cpu_load_percent CPU load of the device.
+# TYPE router_auth_gw_router_cpu_load_percent gauge
+router_auth_gw_router_cpu_load_percent{device="home"} 12.5
+# HELP router_auth_gw_router_info Model and firmware of the device.
+# TYPE router_auth_gw_router_info gauge
+router_auth_gw_router_info{device="home",firmware="4.2",model="Giga"} 1
+# HELP router_auth_gw_router_interface_receive_bytes_total Bytes received by an interface of the device.
+# TYPE router_auth_gw_router_interface_receive_bytes_total counter
+router_auth_gw_router_interface_receive_bytes_total{device="home",interface="PPPoE0"} 1000
+# HELP router_auth_gw_router_interface_transmit_bytes_total Bytes transmitted by an interface of the device.
+# TYPE router_auth_gw_router_interface_transmit_bytes_total counter
+router_auth_gw_router_interface_transmit_bytes_total{device="home",interface="PPPoE0"} 200
+# HELP router_auth_gw_router_memory_available_bytes Free memory of the device, including buffers and cache.
+# TYPE router_auth_gw_router_memory_available_bytes gauge
+router_auth_gw_router_memory_available_bytes{device="home"} 512
+# HELP router_auth_gw_router_memory_total_bytes Total memory of the device.
+# TYPE router_auth_gw_router_memory_total_bytes gauge
+router_auth_gw_router_memory_total_bytes{device="home"} 1024
+# HELP router_auth_gw_router_up Whether the last scrape of the device succeeded.
+# TYPE router_auth_gw_router_up gauge
+router_auth_gw_router_up{device="home"} 1
+router_auth_gw_router_up{device="remote"} 0
+# HELP router_auth_gw_router_uptime_seconds Uptime of the device.
+# TYPE router_auth_gw_router_uptime_seconds gauge
+router_auth_gw_router_uptime_seconds{device="home"} 3600
+# HELP router_auth_gw_router_wan_up Whether a WAN connection of the device is up.
+# TYPE router_auth_gw_router_wan_up gauge
+router_auth_gw_router_wan_up{device="home",interface="PPPoE0"} 1
+router_auth_gw_router_wan_up{device="home",interface="UsbLte0"} 0
+# HELP router_auth_gw_router_wifi_clients Wi-Fi clients of an access point of the device.
+# TYPE router_auth_gw_router_wifi_clients gauge
+router_auth_gw_router_wifi_clients{device="home",interface="WifiMaster0/AccessPoint0"} 2
+`
+	// The scrape duration varies, all other metrics are compared.
+	assert.NoError(t, testutil.CollectAndCompare(e, strings.NewReader(expected),
+		"router_auth_gw_router_cpu_load_percent", "router_auth_gw_router_info",
+		"router_auth_gw_router_interface_receive_bytes_total", "router_auth_gw_router_interface_transmit_bytes_total",
+		"router_auth_gw_router_memory_available_bytes", "router_auth_gw_router_memory_total_bytes",
+		"router_auth_gw_router_up", "router_auth_gw_router_uptime_seconds",
+		"router_auth_gw_router_wan_up", "router_auth_gw_router_wifi_clients",
+	))
+	assert.Equal(t, 2, testutil.CollectAndCount(e, "router_auth_gw_router_scrape_duration_seconds"))
+
+	e.Start(context.Background())
+	assert.Error(t, metrics.Registry.Register(e), "metrics are registered while running")
+	e.Stop()
+	assert.NoError(t, metrics.Registry.Register(e), "metrics are unregistered by Stop")
+	metrics.Registry.Unregister(e)
+}
@@ -0,0 +1,504 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mazzz1y/router-auth-gw/internal/config"
+	"github.com/mazzz1y/router-auth-gw/internal/worker"
+	"github.com/rs/zerolog/log"
+)
+
+// Event names of the gateway, drift and presence events are named by their
+// packages.
+const (
+	EventAuthFailed        = "auth_failed"
+	EventRequestDenied     = "request_denied"
+	EventDeviceLoginFailed = "device_login_failed"
+	EventDeviceUnreachable = "device_unreachable"
+)
+
+// Headers of the posted payloads.
+const (
+	IDHeader        = "X-Webhook-ID"
+	EventHeader     = "X-Webhook-Event"
+	SignatureHeader = "X-Webhook-Signature"
+)
+
+const (
+	timeout            = 10 * time.Second
+	defaultMaxAttempts = 10
+	minBackoff         = 5 * time.Second
+	maxBackoff         = 30 * time.Minute
+	maxQueue           = 1000
+	maxLimited         = 1000
+	idleWait           = time.Hour
+)
+
+// Payload is the body posted to the endpoints. The ID is the same for all
+// endpoints and retries, receivers can use it to drop duplicates.
+type Payload struct {
+	ID     string    `json:"id"`
+	Event  string    `json:"event"`
+	Device string    `json:"device,omitempty"`
+	Time   time.Time `json:"time"`
+	Data   any       `json:"data,omitempty"`
+}
+
+type endpoint struct {
+	secret string
+	events []string
+}
+
+func (e endpoint) accepts(event string) bool {
+	if len(e.events) == 0 {
+		return true
+	}
+	for _, name := range e.events {
+		if name == event {
+			return true
+		}
+	}
+	return false
+}
+
+// delivery is a payload queued for an endpoint, it is stored as
+// <queue_dir>/<id>.json.
+type delivery struct {
+	ID       string          `json:"id"`
+	URL      string          `json:"url"`
+	Event    string          `json:"event"`
+	EventID  string          `json:"event_id"`
+	Payload  json.RawMessage `json:"payload"`
+	Attempts int             `json:"attempts"`
+	Next     time.Time       `json:"next"`
+
+	// saved is false until the delivery loop writes it to the queue
+	// directory.
+	saved bool
+}
+
+// Dispatcher posts events to the configured endpoints in the background.
+// Failed deliveries are retried with exponential backoff until they succeed
+// or run out of attempts.
+type Dispatcher struct {
+	endpoints   map[string]endpoint
+	order       []string
+	dir         string
+	maxAttempts int
+	backoff     time.Duration
+	client      *http.Client
+
+	mu      sync.Mutex
+	queue   []*delivery
+	limited map[string]time.Time // until when a key is limited
+	next    *Dispatcher
+	wake    chan struct{}
+	group   worker.Group
+}
+
+// NewDispatcher creates the dispatcher of the configuration and loads the
+// deliveries left in the queue directory. Without endpoints events are
+// dropped.
+func NewDispatcher(cfg config.WebhooksConfig) (*Dispatcher, error) {
+	d := &Dispatcher{
+		endpoints:   make(map[string]endpoint),
+		dir:         cfg.QueueDir,
+		maxAttempts: cfg.MaxAttempts,
+		backoff:     minBackoff,
+		client:      &http.Client{Timeout: timeout},
+		limited:     make(map[string]time.Time),
+		wake:        make(chan struct{}, 1),
+	}
+	if d.maxAttempts <= 0 {
+		d.maxAttempts = defaultMaxAttempts
+	}
+	for _, e := range cfg.Endpoints {
+		d.endpoints[e.URL] = endpoint{secret: e.Secret, events: e.Events}
+		d.order = append(d.order, e.URL)
+	}
+
+	if err := d.load(); err != nil {
+		return nil, fmt.Errorf("webhooks: failed to load queue: %w", err)
+	}
+	return d, nil
+}
+
+// Inherit takes over the queue of a previous dispatcher, which forwards the
+// events emitted after the reload, e.g. by draining requests.
+func (d *Dispatcher) Inherit(prev *Dispatcher) {
+	prev.mu.Lock()
+	defer prev.mu.Unlock()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// The queue loaded from the same directory is the one of prev.
+	if prev.dir == d.dir {
+		d.queue = nil
+	}
+	for _, dl := range prev.queue {
+		if prev.dir != d.dir {
+			prev.remove(dl)
+			d.save(dl)
+		}
+		d.queue = append(d.queue, dl)
+	}
+	for k, v := range prev.limited {
+		d.limited[k] = v
+	}
+	prev.queue = nil
+	prev.next = d
+	d.notify()
+}
+
+// Start delivers the queued events in the background.
+func (d *Dispatcher) Start(ctx context.Context) {
+	if len(d.endpoints) == 0 {
+		return
+	}
+
+	ctx = d.group.Start(ctx)
+	d.group.Go(func() { d.loop(ctx) })
+}
+
+// Stop stops the deliveries, queued events are kept.
+func (d *Dispatcher) Stop() {
+	d.group.Stop()
+	d.flush()
+}
+
+// Emit queues the event for the endpoints subscribed to it. Sending with a
+// nil dispatcher does nothing. The event is written to the queue directory by
+// the delivery loop, so that emitting in a request doesn't wait for the disk.
+func (d *Dispatcher) Emit(event, device string, data any) {
+	if d == nil {
+		return
+	}
+
+	d.mu.Lock()
+	if next := d.next; next != nil {
+		d.mu.Unlock()
+		next.Emit(event, device, data)
+		return
+	}
+	defer d.mu.Unlock()
+
+	p := Payload{ID: newID(), Event: event, Device: device, Time: time.Now().UTC(), Data: data}
+	payload, err := json.Marshal(p)
+	if err != nil {
+		log.Error().Err(err).Str("event", event).Msg("failed to encode webhook payload")
+		return
+	}
+
+	// Events of clients, which can be sent by anyone, keep the rest of the
+	// queue for the other events.
+	limit := maxQueue
+	if event == EventAuthFailed || event == EventRequestDenied {
+		limit = maxQueue / 2
+	}
+
+	now := time.Now()
+	for _, url := range d.order {
+		if !d.endpoints[url].accepts(event) {
+			continue
+		}
+		if len(d.queue) >= limit {
+			log.Warn().Str("event", event).Str("url", url).Msg("webhook queue is full, event dropped")
+			continue
+		}
+
+		d.queue = append(d.queue, &delivery{ID: newID(), URL: url, Event: event, EventID: p.ID, Payload: payload, Next: now})
+	}
+	d.notify()
+}
+
+// EmitLimited is Emit for events that repeat, such as an unreachable device
+// or a client guessing passwords. The event is dropped if it was emitted for
+// the device and key within the interval.
+func (d *Dispatcher) EmitLimited(event, device, key string, data any, interval time.Duration) {
+	if d == nil {
+		return
+	}
+
+	d.mu.Lock()
+	if next := d.next; next != nil {
+		d.mu.Unlock()
+		next.EmitLimited(event, device, key, data, interval)
+		return
+	}
+	now := time.Now()
+	key = event + "/" + device + "/" + key
+	if until, ok := d.limited[key]; ok && now.Before(until) {
+		d.mu.Unlock()
+		return
+	}
+	if len(d.limited) >= maxLimited {
+		for k, until := range d.limited {
+			if !now.Before(until) {
+				delete(d.limited, k)
+			}
+		}
+	}
+	d.limited[key] = now.Add(interval)
+	d.mu.Unlock()
+
+	d.Emit(event, device, data)
+}
+
+func (d *Dispatcher) loop(ctx context.Context) {
+	for ctx.Err() == nil {
+		d.flush()
+		dl, wait := d.due()
+		if dl != nil {
+			d.deliver(ctx, dl)
+			continue
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-d.wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// due returns the next delivery to send, or how long to wait for it.
+func (d *Dispatcher) due() (*delivery, time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var first *delivery
+	for _, dl := range d.queue {
+		if first == nil || dl.Next.Before(first.Next) {
+			first = dl
+		}
+	}
+	if first == nil {
+		return nil, idleWait
+	}
+	if wait := time.Until(first.Next); wait > 0 {
+		return nil, wait
+	}
+	return first, 0
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, dl *delivery) {
+	l := log.With().Str("event", dl.Event).Str("url", dl.URL).Logger()
+
+	e, ok := d.endpoints[dl.URL]
+	if !ok {
+		l.Warn().Msg("webhook endpoint was removed, event dropped")
+		d.done(dl)
+		return
+	}
+
+	err := d.post(ctx, dl, e.secret)
+	switch {
+	case err == nil:
+		d.done(dl)
+		return
+	case ctx.Err() != nil:
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	dl.Attempts++
+	if dl.Attempts >= d.maxAttempts {
+		l.Error().Err(err).Int("attempts", dl.Attempts).Msg("webhook delivery failed, event dropped")
+		d.drop(dl)
+		return
+	}
+
+	dl.Next = time.Now().Add(d.retryDelay(dl.Attempts))
+	d.save(dl)
+	l.Warn().Err(err).Int("attempts", dl.Attempts).Time("next", dl.Next).Msg("webhook delivery failed, will retry")
+}
+
+func (d *Dispatcher) post(ctx context.Context, dl *delivery, secret string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, dl.URL, bytes.NewReader(dl.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, dl.Event)
+	req.Header.Set(IDHeader, dl.EventID)
+	if secret != "" {
+		req.Header.Set(SignatureHeader, Sign(secret, dl.Payload))
+	}
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("webhook responded with status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// retryDelay doubles the backoff with every attempt, up to maxBackoff.
+func (d *Dispatcher) retryDelay(attempts int) time.Duration {
+	delay := d.backoff
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxBackoff)
+}
+
+func (d *Dispatcher) done(dl *delivery) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.drop(dl)
+}
+
+// drop removes the delivery from the queue, the lock must be held.
+func (d *Dispatcher) drop(dl *delivery) {
+	for i, q := range d.queue {
+		if q == dl {
+			d.queue = append(d.queue[:i], d.queue[i+1:]...)
+			break
+		}
+	}
+	d.remove(dl)
+}
+
+func (d *Dispatcher) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (d *Dispatcher) load() error {
+	if d.dir == "" {
+		return nil
+	}
+
+	entries, err := os.ReadDir(d.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(d.dir, entry.Name()))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		dl := delivery{saved: true}
+		if err := json.Unmarshal(data, &dl); err != nil {
+			log.Warn().Err(err).Str("file", entry.Name()).Msg("skipping invalid webhook queue file")
+			continue
+		}
+		d.queue = append(d.queue, &dl)
+	}
+	return nil
+}
+
+// flush writes the deliveries queued by Emit to the queue directory.
+func (d *Dispatcher) flush() {
+	if d.dir == "" {
+		return
+	}
+
+	d.mu.Lock()
+	var pending []*delivery
+	for _, dl := range d.queue {
+		if !dl.saved {
+			dl.saved = true
+			pending = append(pending, dl)
+		}
+	}
+	d.mu.Unlock()
+
+	// Deliveries are only changed by the delivery loop, which is either
+	// this goroutine or stopped.
+	for _, dl := range pending {
+		d.save(dl)
+	}
+}
+
+// save writes the delivery to the queue directory, if any. It is written to
+// a temporary file first, so that a crash doesn't leave a partial one.
+func (d *Dispatcher) save(dl *delivery) {
+	if d.dir == "" {
+		return
+	}
+
+	err := func() error {
+		if err := os.MkdirAll(d.dir, 0o700); err != nil {
+			return err
+		}
+		data, err := json.Marshal(dl)
+		if err != nil {
+			return err
+		}
+
+		path := d.path(dl)
+		if err := os.WriteFile(path+".tmp", data, 0o600); err != nil {
+			return err
+		}
+		return os.Rename(path+".tmp", path)
+	}()
+	if err != nil {
+		log.Error().Err(err).Str("event", dl.Event).Msg("failed to save webhook queue file")
+	}
+}
+
+func (d *Dispatcher) remove(dl *delivery) {
+	if d.dir == "" {
+		return
+	}
+	if err := os.Remove(d.path(dl)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Error().Err(err).Str("event", dl.Event).Msg("failed to remove webhook queue file")
+	}
+}
+
+func (d *Dispatcher) path(dl *delivery) string {
+	return filepath.Join(d.dir, dl.ID+".json")
+}
+
+// Sign returns the signature header of the payload: "sha256=" followed by the
+// hex HMAC-SHA256 of the payload keyed with the secret.
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
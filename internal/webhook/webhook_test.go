@@ -0,0 +1,154 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mazzz1y/router-auth-gw/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type request struct {
+	header http.Header
+	body   []byte
+}
+
+func receiver(t *testing.T, failures int32) (*httptest.Server, <-chan request) {
+	var calls atomic.Int32
+	received := make(chan request, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		received <- request{header: r.Header, body: body}
+	}))
+	t.Cleanup(server.Close)
+	return server, received
+}
+
+func wait(t *testing.T, received <-chan request) request {
+	select {
+	case r := <-received:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook not received")
+		return request{}
+	}
+}
+
+func TestDispatcher(t *testing.T) {
+	server, received := receiver(t, 2)
+	filtered, filteredReceived := receiver(t, 0)
+
+	d, err := NewDispatcher(config.WebhooksConfig{Endpoints: []config.WebhookConfig{
+		{URL: server.URL, Secret: "secret"},
+		{URL: filtered.URL, Events: []string{EventDeviceUnreachable}},
+	}})
+	require.NoError(t, err)
+	d.backoff = time.Millisecond
+	d.Start(context.Background())
+	defer d.Stop()
+
+	d.Emit(EventAuthFailed, "router", map[string]string{"from": "192.0.2.1"})
+
+	r := wait(t, received)
+	assert.Equal(t, Sign("secret", r.body), r.header.Get(SignatureHeader))
+	assert.Equal(t, EventAuthFailed, r.header.Get(EventHeader))
+
+	var p Payload
+	require.NoError(t, json.Unmarshal(r.body, &p))
+	assert.Equal(t, r.header.Get(IDHeader), p.ID)
+	assert.Equal(t, EventAuthFailed, p.Event)
+	assert.Equal(t, "router", p.Device)
+	assert.Equal(t, map[string]any{"from": "192.0.2.1"}, p.Data)
+
+	d.EmitLimited(EventDeviceUnreachable, "router", "", nil, time.Hour)
+	d.EmitLimited(EventDeviceUnreachable, "router", "", nil, time.Hour)
+	assert.Equal(t, EventDeviceUnreachable, wait(t, filteredReceived).header.Get(EventHeader))
+	assert.Equal(t, EventDeviceUnreachable, wait(t, received).header.Get(EventHeader))
+	assert.Empty(t, filteredReceived)
+}
+
+func TestDispatcherQueue(t *testing.T) {
+	server, received := receiver(t, 0)
+	cfg := config.WebhooksConfig{QueueDir: t.TempDir(), Endpoints: []config.WebhookConfig{{URL: server.URL}}}
+
+	d, err := NewDispatcher(cfg)
+	require.NoError(t, err)
+	d.Emit(EventRequestDenied, "router", nil)
+	d.Stop()
+
+	entries, err := os.ReadDir(cfg.QueueDir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	restarted, err := NewDispatcher(cfg)
+	require.NoError(t, err)
+	restarted.Start(context.Background())
+	defer restarted.Stop()
+
+	assert.Equal(t, EventRequestDenied, wait(t, received).header.Get(EventHeader))
+	assert.Eventually(t, func() bool {
+		entries, err := os.ReadDir(cfg.QueueDir)
+		return err == nil && len(entries) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestDispatcherGivesUp(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	d, err := NewDispatcher(config.WebhooksConfig{MaxAttempts: 3, Endpoints: []config.WebhookConfig{{URL: server.URL}}})
+	require.NoError(t, err)
+	d.backoff = time.Millisecond
+	d.Start(context.Background())
+	defer d.Stop()
+
+	d.Emit(EventAuthFailed, "", nil)
+	assert.Eventually(t, func() bool {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return len(d.queue) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(3), calls.Load())
+
+	assert.Equal(t, 5*time.Second, (&Dispatcher{backoff: minBackoff}).retryDelay(1))
+	assert.Equal(t, 20*time.Second, (&Dispatcher{backoff: minBackoff}).retryDelay(3))
+	assert.Equal(t, maxBackoff, (&Dispatcher{backoff: minBackoff}).retryDelay(20))
+}
+
+func TestDispatcherInherit(t *testing.T) {
+	server, received := receiver(t, 0)
+	cfg := config.WebhooksConfig{Endpoints: []config.WebhookConfig{{URL: server.URL}}}
+
+	prev, err := NewDispatcher(cfg)
+	require.NoError(t, err)
+	prev.Emit(EventAuthFailed, "", nil)
+
+	d, err := NewDispatcher(cfg)
+	require.NoError(t, err)
+	d.Inherit(prev)
+	prev.Emit(EventRequestDenied, "", nil)
+
+	d.Start(context.Background())
+	defer d.Stop()
+
+	events := []string{wait(t, received).header.Get(EventHeader), wait(t, received).header.Get(EventHeader)}
+	assert.ElementsMatch(t, []string{EventAuthFailed, EventRequestDenied}, events)
+}
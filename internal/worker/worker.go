@@ -0,0 +1,76 @@
+// Package worker runs the background goroutines of the gateway components.
+package worker
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Group is a set of goroutines that are canceled and waited for together.
+// The zero value is ready to use.
+type Group struct {
+	mu      sync.Mutex
+	cancel  context.CancelFunc
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+// Start returns a context of ctx that is canceled by Stop.
+func (g *Group) Start(ctx context.Context) context.Context {
+	ctx, cancel := context.WithCancel(ctx)
+
+	g.mu.Lock()
+	g.cancel = cancel
+	g.mu.Unlock()
+
+	return ctx
+}
+
+// Go runs f in a goroutine of the group. Nothing is started once Stop was
+// called, Go reports whether f runs.
+func (g *Group) Go(f func()) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.stopped {
+		return false
+	}
+
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		f()
+	}()
+	return true
+}
+
+// Stop cancels the context of the group and waits for its goroutines. It
+// reports whether the group was started and not already stopped.
+func (g *Group) Stop() bool {
+	g.mu.Lock()
+	cancel := g.cancel
+	g.cancel = nil
+	g.stopped = true
+	g.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+	g.wg.Wait()
+	return cancel != nil
+}
+
+// Every calls f now and then every interval, until the context is canceled.
+func Every(ctx context.Context, interval time.Duration, f func(context.Context)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		f(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
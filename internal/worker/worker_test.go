@@ -0,0 +1,45 @@
+package worker
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroup(t *testing.T) {
+	t.Run("Stop cancels and waits", func(t *testing.T) {
+		var g Group
+		ctx := g.Start(context.Background())
+
+		var done atomic.Bool
+		assert.True(t, g.Go(func() {
+			<-ctx.Done()
+			done.Store(true)
+		}))
+
+		assert.True(t, g.Stop())
+		assert.True(t, done.Load())
+		assert.False(t, g.Stop())
+	})
+
+	t.Run("Nothing runs after Stop", func(t *testing.T) {
+		var g Group
+		assert.False(t, g.Stop())
+		assert.False(t, g.Go(func() { t.Error("unexpected run") }))
+	})
+}
+
+func TestEvery(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var calls atomic.Int32
+	Every(ctx, time.Millisecond, func(context.Context) {
+		if calls.Add(1) == 3 {
+			cancel()
+		}
+	})
+	assert.GreaterOrEqual(t, calls.Load(), int32(3))
+}
@@ -0,0 +1,81 @@
+package glinet
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// ClientInfo is a LAN client from "clients.get_list".
+type ClientInfo struct {
+	MAC    string `json:"mac"`
+	IP     string `json:"ip"`
+	Name   string `json:"name"`
+	Iface  string `json:"iface"`
+	Online bool   `json:"online"`
+}
+
+// CableStatus is the result of "cable.get_status".
+type CableStatus struct {
+	Up   bool `json:"up"`
+	IPv4 struct {
+		IP      string `json:"ip"`
+		Gateway string `json:"gateway"`
+	} `json:"ipv4"`
+	Uptime int64 `json:"uptime"`
+}
+
+// SystemStatus is the result of "system.get_status". Memory is in bytes.
+type SystemStatus struct {
+	Client []struct {
+		CableTotal    int `json:"cable_total"`
+		WirelessTotal int `json:"wireless_total"`
+	} `json:"client"`
+	System struct {
+		LoadAverage     []float64 `json:"load_average"`
+		MemoryTotal     int64     `json:"memory_total"`
+		MemoryFree      int64     `json:"memory_free"`
+		MemoryBuffCache int64     `json:"memory_buff_cache"`
+		Uptime          int64     `json:"uptime"`
+	} `json:"system"`
+}
+
+// Clients returns the LAN clients, including offline ones.
+func (kc *Client) Clients(ctx context.Context) ([]ClientInfo, error) {
+	var res struct {
+		Clients []ClientInfo `json:"clients"`
+	}
+	err := kc.Call(ctx, "clients", "get_list", nil, &res)
+	return res.Clients, err
+}
+
+// CableStatus returns the status of the ethernet WAN.
+func (kc *Client) CableStatus(ctx context.Context) (CableStatus, error) {
+	var res CableStatus
+	err := kc.Call(ctx, "cable", "get_status", nil, &res)
+	return res, err
+}
+
+// SystemStatus returns the load, memory, uptime and client counts of the
+// device.
+func (kc *Client) SystemStatus(ctx context.Context) (SystemStatus, error) {
+	var res SystemStatus
+	err := kc.Call(ctx, "system", "get_status", nil, &res)
+	return res, err
+}
+
+// Backup returns the device configuration from "backup.get_config".
+func (kc *Client) Backup(ctx context.Context) (json.RawMessage, error) {
+	var res json.RawMessage
+	err := kc.Call(ctx, "backup", "get_config", nil, &res)
+	return res, err
+}
+
+// Reboot restarts the device.
+func (kc *Client) Reboot(ctx context.Context) error {
+	return kc.Call(ctx, "system", "reboot", nil, nil)
+}
+
+// Wake sends a Wake-on-LAN packet to the host.
+func (kc *Client) Wake(ctx context.Context, mac string) error {
+	return kc.Call(ctx, "lan", "wake_on_lan", map[string]string{"mac": mac}, nil)
+}
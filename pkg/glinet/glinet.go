@@ -17,6 +17,16 @@ import (
 	"strings"
 )
 
+// SystemInfo is the result of the "system.get_info" call.
+type SystemInfo struct {
+	Model           string `json:"model"`
+	Mac             string `json:"mac"`
+	FirmwareVersion string `json:"firmware_version"`
+}
+
+// ErrAuthFailed is returned when the device rejects the credentials.
+var ErrAuthFailed = errors.New("auth failed")
+
 type Client struct {
 	URL       string
 	RPCUrl    string
@@ -25,6 +35,8 @@ type Client struct {
 	Password  string
 	Client    *http.Client
 	SessionID string
+	// OnAuth, if set, is called with the result of every login.
+	OnAuth func(err error)
 }
 
 func NewClient(baseUrl, proxyURL, username, password string) *Client {
@@ -72,7 +84,76 @@ func (kc *Client) Websocket() (*websocket.Conn, error) {
 	return websocket.DialConfig(c)
 }
 
+// Login authenticates a new session, even if there is one already.
+func (kc *Client) Login(ctx context.Context) error {
+	return kc.auth(ctx)
+}
+
+// Call invokes a method of a ubus object over JSON-RPC and decodes the
+// result into out.
+func (kc *Client) Call(ctx context.Context, object, method string, params any, out any) error {
+	if params == nil {
+		params = map[string]any{}
+	}
+
+	res, err := kc.Request(ctx, "POST", "/rpc", buildCallPayload(kc.SessionID, object, method, params))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	var response struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return fmt.Errorf("failed to decode %s.%s response: %w", object, method, err)
+	}
+	if response.Error != nil {
+		return fmt.Errorf("%s.%s failed: %s", object, method, response.Error.Message)
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(response.Result, out)
+}
+
+// SystemInfo returns the device model and firmware version.
+func (kc *Client) SystemInfo(ctx context.Context) (SystemInfo, error) {
+	var info SystemInfo
+	err := kc.Call(ctx, "system", "get_info", nil, &info)
+	return info, err
+}
+
+// Logout closes the device session if there is one.
+func (kc *Client) Logout(ctx context.Context) error {
+	if kc.SessionID == "" {
+		return nil
+	}
+
+	res, err := kc.request(ctx, "POST", kc.RPCUrl, buildLogoutPayload(kc.SessionID))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	kc.SessionID = ""
+	return nil
+}
+
 func (kc *Client) auth(ctx context.Context) error {
+	err := kc.login(ctx)
+	if kc.OnAuth != nil {
+		kc.OnAuth(err)
+	}
+	return err
+}
+
+func (kc *Client) login(ctx context.Context) error {
 	salt, nonce, err := kc.getSaltAndNonce(ctx)
 	if err != nil {
 		return err
@@ -86,7 +167,7 @@ func (kc *Client) auth(ctx context.Context) error {
 	}
 	defer res.Body.Close()
 	if isAccessDenied(res) {
-		return errors.New("auth failed")
+		return ErrAuthFailed
 	}
 
 	return kc.extractSid(res)
@@ -104,7 +185,7 @@ func (kc *Client) request(ctx context.Context, method, url, body string) (*http.
 
 	resp, err := kc.Client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to make request: %v", err)
+		return nil, fmt.Errorf("failed to make request: %w", err)
 	}
 
 	return resp, nil
@@ -185,6 +266,30 @@ func buildChallengePayload(user string) string {
 	return string(payloadBytes)
 }
 
+func buildCallPayload(sid, object, method string, params any) string {
+	callPayload := map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      1,
+		"method":  "call",
+		"params":  []interface{}{sid, object, method, params},
+	}
+	payloadBytes, _ := json.Marshal(callPayload)
+	return string(payloadBytes)
+}
+
+func buildLogoutPayload(sid string) string {
+	logoutPayload := map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      1,
+		"method":  "logout",
+		"params": map[string]string{
+			"sid": sid,
+		},
+	}
+	payloadBytes, _ := json.Marshal(logoutPayload)
+	return string(payloadBytes)
+}
+
 func buildAuthPayload(user, pass, salt, nonce string) string {
 	passwd := password.MD5.Crypt([]byte(pass), []byte(salt), nil)
 	loginData := fmt.Sprintf("%s:%s:%s", user, passwd, nonce)
@@ -54,6 +54,23 @@ func handleRPCRequest(w http.ResponseWriter, r *http.Request) {
 		} else {
 			errorRes(w)
 		}
+	case "logout":
+		params := requestBody["params"].(map[string]interface{})
+		if params["sid"] != mockSession {
+			errorRes(w)
+		} else {
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`{"id":1,"jsonrpc":"2.0","result":{}}`))
+		}
+	case "call":
+		params := requestBody["params"].([]interface{})
+		if params[0] != mockSession {
+			errorRes(w)
+		} else if params[1] == "system" && params[2] == "get_info" {
+			w.Write([]byte(`{"id":1,"jsonrpc":"2.0","result":{"model":"mt3000","firmware_version":"4.5.0"}}`))
+		} else {
+			w.Write([]byte(`{"id":1,"jsonrpc":"2.0","error":{"code":-32000,"message":"Object not found"}}`))
+		}
 	case "someMethod":
 		if _, err := r.Cookie(cookieName); err != nil {
 			errorRes(w)
@@ -105,3 +122,40 @@ func TestRequest(t *testing.T) {
 
 	assert.Equal(t, http.StatusOK, response.StatusCode)
 }
+
+func TestLogout(t *testing.T) {
+	server := mockServer()
+	defer server.Close()
+
+	ctx := context.Background()
+	c := NewClient(server.URL, "", mockUser, mockPass)
+
+	t.Run("Without session", func(t *testing.T) {
+		assert.NoError(t, c.Logout(ctx))
+	})
+
+	t.Run("With session", func(t *testing.T) {
+		assert.NoError(t, c.auth(ctx))
+		assert.NoError(t, c.Logout(ctx))
+		assert.Equal(t, "", c.SessionID)
+	})
+}
+
+func TestCall(t *testing.T) {
+	server := mockServer()
+	defer server.Close()
+
+	ctx := context.Background()
+	c := NewClient(server.URL, "", mockUser, mockPass)
+
+	t.Run("System info", func(t *testing.T) {
+		info, err := c.SystemInfo(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, SystemInfo{Model: "mt3000", FirmwareVersion: "4.5.0"}, info)
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		err := c.Call(ctx, "missing", "method", nil, nil)
+		assert.EqualError(t, err, "missing.method failed: Object not found")
+	})
+}
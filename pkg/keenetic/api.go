@@ -0,0 +1,164 @@
+package keenetic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+// Host is a LAN host from "show ip hotspot".
+type Host struct {
+	MAC       string `json:"mac"`
+	IP        string `json:"ip"`
+	Hostname  string `json:"hostname"`
+	Name      string `json:"name"`
+	Active    bool   `json:"active"`
+	Interface struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"interface"`
+}
+
+// Interface is an interface from "show interface".
+type Interface struct {
+	ID          string `json:"id"`
+	Type        string `json:"type"`
+	Description string `json:"description"`
+	Link        string `json:"link"`
+	Connected   string `json:"connected"`
+	Address     string `json:"address"`
+	Uptime      int64  `json:"uptime"`
+	DefaultGW   bool   `json:"defaultgw"`
+	Global      bool   `json:"global"`
+}
+
+// System is the result of "show system". Memory is in kilobytes.
+type System struct {
+	CPULoad    float64     `json:"cpuload"`
+	MemTotal   int64       `json:"memtotal"`
+	MemFree    int64       `json:"memfree"`
+	MemBuffers int64       `json:"membuffers"`
+	MemCache   int64       `json:"memcache"`
+	Uptime     json.Number `json:"uptime"`
+}
+
+// InterfaceStat is the result of "show interface stat".
+type InterfaceStat struct {
+	RxBytes int64 `json:"rxbytes"`
+	TxBytes int64 `json:"txbytes"`
+}
+
+// Station is a Wi-Fi client from "show associations".
+type Station struct {
+	MAC string `json:"mac"`
+	AP  string `json:"ap"`
+}
+
+// Hosts returns the hosts known to the hotspot, including inactive ones.
+func (kc *Client) Hosts(ctx context.Context) ([]Host, error) {
+	var res struct {
+		Host []Host `json:"host"`
+	}
+	if err := kc.get(ctx, "/rci/show/ip/hotspot", &res); err != nil {
+		return nil, err
+	}
+	return res.Host, nil
+}
+
+// Interfaces returns all interfaces by their ids.
+func (kc *Client) Interfaces(ctx context.Context) (map[string]Interface, error) {
+	var res map[string]Interface
+	if err := kc.get(ctx, "/rci/show/interface", &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// System returns the CPU load, memory and uptime of the device.
+func (kc *Client) System(ctx context.Context) (System, error) {
+	var res System
+	err := kc.get(ctx, "/rci/show/system", &res)
+	return res, err
+}
+
+// InterfaceStat returns the traffic counters of an interface.
+func (kc *Client) InterfaceStat(ctx context.Context, id string) (InterfaceStat, error) {
+	var res InterfaceStat
+	err := kc.get(ctx, "/rci/show/interface/stat?name="+url.QueryEscape(id), &res)
+	return res, err
+}
+
+// Associations returns the Wi-Fi clients of all access points.
+func (kc *Client) Associations(ctx context.Context) ([]Station, error) {
+	var res struct {
+		Station []Station `json:"station"`
+	}
+	if err := kc.get(ctx, "/rci/show/associations", &res); err != nil {
+		return nil, err
+	}
+	return res.Station, nil
+}
+
+// RunningConfig returns the lines of "show running-config".
+func (kc *Client) RunningConfig(ctx context.Context) ([]string, error) {
+	var res struct {
+		Message []string `json:"message"`
+	}
+	if err := kc.get(ctx, "/rci/show/running-config", &res); err != nil {
+		return nil, err
+	}
+	return res.Message, nil
+}
+
+// Reboot restarts the device.
+func (kc *Client) Reboot(ctx context.Context) error {
+	return kc.exec(ctx, "/rci/system/reboot", map[string]any{})
+}
+
+// Wake sends a Wake-on-LAN packet to the host.
+func (kc *Client) Wake(ctx context.Context, mac string) error {
+	return kc.exec(ctx, "/rci/ip/hotspot/wake", map[string]string{"mac": mac})
+}
+
+func (kc *Client) get(ctx context.Context, endpoint string, out any) error {
+	data, err := kc.rci(ctx, "GET", endpoint, "")
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", endpoint, err)
+	}
+	return nil
+}
+
+// exec runs a command and returns the first error status of the response.
+func (kc *Client) exec(ctx context.Context, endpoint string, body any) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	data, err := kc.rci(ctx, "POST", endpoint, string(payload))
+	if err != nil {
+		return err
+	}
+
+	var res struct {
+		Status []struct {
+			Status  string `json:"status"`
+			Message string `json:"message"`
+		} `json:"status"`
+	}
+	if json.Unmarshal(data, &res) != nil {
+		return nil
+	}
+	for _, st := range res.Status {
+		if st.Status == "error" {
+			return fmt.Errorf("%s: %s", endpoint, st.Message)
+		}
+	}
+
+	return nil
+}
@@ -9,17 +9,32 @@ import (
 	"errors"
 	"fmt"
 	"golang.org/x/net/websocket"
+	"io"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
 	"strings"
 )
 
+// Version is the response of "show version".
+type Version struct {
+	Model   string `json:"model"`
+	Device  string `json:"device"`
+	HwID    string `json:"hw_id"`
+	Release string `json:"release"`
+	Title   string `json:"title"`
+}
+
+// ErrAuthFailed is returned when the device rejects the credentials.
+var ErrAuthFailed = errors.New("auth failed")
+
 type Client struct {
 	URL      string
 	Username string
 	Password string
 	Client   *http.Client
+	// OnAuth, if set, is called with the result of every login.
+	OnAuth func(err error)
 }
 
 func NewClient(baseUrl, proxyURL, username, password string) *Client {
@@ -66,7 +81,76 @@ func (kc *Client) Websocket() (*websocket.Conn, error) {
 	return nil, errors.New("websocket not supported")
 }
 
+// Login authenticates a new session, even if there is one already.
+func (kc *Client) Login(ctx context.Context) error {
+	return kc.auth(ctx)
+}
+
+// Version returns the device model and firmware version.
+func (kc *Client) Version(ctx context.Context) (Version, error) {
+	var v Version
+	err := kc.get(ctx, "/rci/show/version", &v)
+	return v, err
+}
+
+// Parse runs a CLI command through the RCI "parse" interface and returns
+// the raw response.
+func (kc *Client) Parse(ctx context.Context, command string) (json.RawMessage, error) {
+	payload, _ := json.Marshal(map[string]string{"parse": command})
+	return kc.rci(ctx, "POST", "/rci/", string(payload))
+}
+
+func (kc *Client) rci(ctx context.Context, method, endpoint, body string) (json.RawMessage, error) {
+	res, err := kc.Request(ctx, method, endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+
+	return data, nil
+}
+
+// Logout closes the device session if there is one.
+func (kc *Client) Logout(ctx context.Context) error {
+	u, err := url.Parse(kc.URL)
+	if err != nil {
+		return err
+	}
+	if len(kc.Client.Jar.Cookies(u)) == 0 {
+		return nil
+	}
+
+	res, err := kc.request(ctx, "DELETE", kc.URL+"/auth", "")
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("logout failed with status code: %d", res.StatusCode)
+	}
+
+	return nil
+}
+
 func (kc *Client) auth(ctx context.Context) error {
+	err := kc.login(ctx)
+	if kc.OnAuth != nil {
+		kc.OnAuth(err)
+	}
+	return err
+}
+
+func (kc *Client) login(ctx context.Context) error {
 	challenge, realm, err := kc.getChallenge(ctx)
 	if err != nil {
 		return err
@@ -81,7 +165,7 @@ func (kc *Client) auth(ctx context.Context) error {
 	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK {
-		return errors.New("auth failed")
+		return ErrAuthFailed
 	}
 
 	return nil
@@ -119,7 +203,7 @@ func (kc *Client) request(ctx context.Context, method, url, body string) (*http.
 
 	resp, err := kc.Client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to make request: %v", err)
+		return nil, fmt.Errorf("failed to make request: %w", err)
 	}
 
 	return resp, nil
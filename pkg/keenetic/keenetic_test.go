@@ -25,6 +25,10 @@ func mockServer() *httptest.Server {
 			handleAuthRequest(w, r)
 		case "/test-endpoint":
 			handleTestEndpoint(w, r)
+		case "/rci/show/version":
+			handleVersion(w, r)
+		case "/rci/":
+			handleRCI(w, r)
 		default:
 			http.NotFound(w, r)
 		}
@@ -47,6 +51,12 @@ func handleAuthRequest(w http.ResponseWriter, r *http.Request) {
 		} else {
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		}
+	case http.MethodDelete:
+		if _, err := r.Cookie(cookieName); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+		} else {
+			w.WriteHeader(http.StatusOK)
+		}
 	default:
 		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
 	}
@@ -62,6 +72,25 @@ func handleTestEndpoint(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func handleVersion(w http.ResponseWriter, r *http.Request) {
+	if _, err := r.Cookie(cookieName); err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	w.Write([]byte(`{"release":"4.01.C.7.0-0","title":"4.1.7","model":"Giga","hw_id":"KN-1011"}`))
+}
+
+func handleRCI(w http.ResponseWriter, r *http.Request) {
+	if _, err := r.Cookie(cookieName); err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var payload map[string]string
+	_ = json.NewDecoder(r.Body).Decode(&payload)
+	w.Write([]byte(`{"parse":"` + payload["parse"] + `"}`))
+}
+
 func TestAuth(t *testing.T) {
 	server := mockServer()
 	defer server.Close()
@@ -94,3 +123,53 @@ func TestRequest(t *testing.T) {
 
 	assert.Equal(t, http.StatusOK, response.StatusCode)
 }
+
+func TestLogout(t *testing.T) {
+	server := mockServer()
+	defer server.Close()
+
+	ctx := context.Background()
+	c := NewClient(server.URL, "", mockUser, mockPass)
+
+	t.Run("Without session", func(t *testing.T) {
+		assert.NoError(t, c.Logout(ctx))
+	})
+
+	t.Run("With session", func(t *testing.T) {
+		assert.NoError(t, c.auth(ctx))
+		assert.NoError(t, c.Logout(ctx))
+	})
+}
+
+func TestVersion(t *testing.T) {
+	server := mockServer()
+	defer server.Close()
+
+	ctx := context.Background()
+
+	t.Run("Success", func(t *testing.T) {
+		c := NewClient(server.URL, "", mockUser, mockPass)
+		v, err := c.Version(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, Version{Model: "Giga", HwID: "KN-1011", Release: "4.01.C.7.0-0", Title: "4.1.7"}, v)
+	})
+
+	t.Run("Failed login", func(t *testing.T) {
+		c := NewClient(server.URL, "", mockUser, "wrong password")
+		assert.Error(t, c.Login(ctx))
+		_, err := c.Version(ctx)
+		assert.Error(t, err)
+	})
+}
+
+func TestParse(t *testing.T) {
+	server := mockServer()
+	defer server.Close()
+
+	ctx := context.Background()
+	c := NewClient(server.URL, "", mockUser, mockPass)
+
+	res, err := c.Parse(ctx, "show version")
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"parse":"show version"}`, string(res))
+}
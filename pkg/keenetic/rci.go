@@ -0,0 +1,171 @@
+package keenetic
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const rciPrefix = "/rci"
+
+// Policy restricts the RCI commands that can be executed through a POST body.
+// Rules are space-separated command paths (e.g. "ip hotspot wake") and match
+// any command starting with the same words. Deny rules take precedence, and
+// when Allow is not empty every command has to match at least one allow rule.
+type Policy struct {
+	Allow []string
+	Deny  []string
+}
+
+type CommandError struct {
+	Command string
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("rci command not allowed: %s", e.Command)
+}
+
+func (p Policy) IsEmpty() bool {
+	return len(p.Allow) == 0 && len(p.Deny) == 0
+}
+
+// Check evaluates every command of the request against the policy and returns
+// a *CommandError for the first denied one. Requests outside /rci are ignored.
+func (p Policy) Check(path string, body []byte) error {
+	if !IsRCIPath(path) {
+		return nil
+	}
+
+	commands, err := Commands(path, body)
+	if err != nil {
+		return err
+	}
+
+	for _, cmd := range commands {
+		if !p.allowed(cmd) {
+			return &CommandError{Command: strings.Join(cmd, " ")}
+		}
+	}
+
+	return nil
+}
+
+func (p Policy) allowed(cmd []string) bool {
+	for _, rule := range p.Deny {
+		if matchRule(rule, cmd) {
+			return false
+		}
+	}
+
+	if len(p.Allow) == 0 {
+		return true
+	}
+
+	for _, rule := range p.Allow {
+		if matchRule(rule, cmd) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsRCIPath reports whether the path is under /rci, in any case, as the
+// device doesn't distinguish them.
+func IsRCIPath(path string) bool {
+	path = strings.ToLower(path)
+	return path == rciPrefix || strings.HasPrefix(path, rciPrefix+"/")
+}
+
+// Commands returns the command paths carried by an RCI request. The URL path
+// after /rci is the common prefix, and each leaf of the JSON body (or of every
+// element of a batched array) is a separate command. Arguments are kept as the
+// trailing words, and "parse" commands are expanded into their CLI words: a
+// string under a "parse" key or path segment, or each string of an array there.
+func Commands(path string, body []byte) ([][]string, error) {
+	if IsRCIPath(path) {
+		path = path[len(rciPrefix):]
+	}
+	prefix := splitPath(path)
+
+	if len(strings.TrimSpace(string(body))) == 0 {
+		return [][]string{prefix}, nil
+	}
+
+	var payload interface{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("failed to parse rci body: %w", err)
+	}
+
+	var commands [][]string
+	collectCommands(prefix, payload, &commands)
+	return commands, nil
+}
+
+func collectCommands(prefix []string, v interface{}, out *[][]string) {
+	switch val := v.(type) {
+	case string:
+		if n := len(prefix); n > 0 && strings.EqualFold(prefix[n-1], "parse") {
+			*out = append(*out, appendWords(prefix[:n-1], strings.Fields(val)...))
+			return
+		}
+		*out = append(*out, prefix)
+	case map[string]interface{}:
+		if len(val) == 0 {
+			*out = append(*out, prefix)
+			return
+		}
+
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			collectCommands(appendWords(prefix, k), val[k], out)
+		}
+	case []interface{}:
+		if len(val) == 0 {
+			*out = append(*out, prefix)
+			return
+		}
+		for _, item := range val {
+			collectCommands(prefix, item, out)
+		}
+	default:
+		*out = append(*out, prefix)
+	}
+}
+
+func matchRule(rule string, cmd []string) bool {
+	words := strings.Fields(rule)
+	if len(words) == 0 || len(words) > len(cmd) {
+		return false
+	}
+
+	for i, w := range words {
+		if !strings.EqualFold(w, cmd[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func splitPath(path string) []string {
+	var words []string
+	for _, w := range strings.Split(path, "/") {
+		if w != "" {
+			words = append(words, w)
+		}
+	}
+	return words
+}
+
+func appendWords(prefix []string, words ...string) []string {
+	res := make([]string, 0, len(prefix)+len(words))
+	res = append(res, prefix...)
+	return append(res, words...)
+}
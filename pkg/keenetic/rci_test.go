@@ -0,0 +1,134 @@
+package keenetic
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommands(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		body     string
+		expected [][]string
+	}{
+		{
+			name:     "Path only",
+			path:     "/rci/system/reboot",
+			expected: [][]string{{"system", "reboot"}},
+		},
+		{
+			name:     "Path with arguments",
+			path:     "/rci/ip/hotspot/wake",
+			body:     `{"mac":"00:11:22:33:44:55"}`,
+			expected: [][]string{{"ip", "hotspot", "wake", "mac"}},
+		},
+		{
+			name:     "Nested body",
+			path:     "/rci/",
+			body:     `{"ip":{"hotspot":{"wake":{"mac":"00:11:22:33:44:55"}}}}`,
+			expected: [][]string{{"ip", "hotspot", "wake", "mac"}},
+		},
+		{
+			name: "Batched array",
+			path: "/rci/",
+			body: `[{"show":{"version":{}}},{"system":{"reboot":{}}}]`,
+			expected: [][]string{
+				{"show", "version"},
+				{"system", "reboot"},
+			},
+		},
+		{
+			name:     "Parse command",
+			path:     "/rci/",
+			body:     `[{"parse":"system reboot"}]`,
+			expected: [][]string{{"system", "reboot"}},
+		},
+		{
+			name:     "Parse path with string body",
+			path:     "/rci/parse",
+			body:     `"system reboot"`,
+			expected: [][]string{{"system", "reboot"}},
+		},
+		{
+			name:     "Parse path with array body",
+			path:     "/rci/parse",
+			body:     `["show version","system reboot"]`,
+			expected: [][]string{{"show", "version"}, {"system", "reboot"}},
+		},
+		{
+			name:     "Parse array",
+			path:     "/rci/",
+			body:     `{"parse":["show version","system reboot"]}`,
+			expected: [][]string{{"show", "version"}, {"system", "reboot"}},
+		},
+		{
+			name:     "Mixed case parse",
+			path:     "/RCI/",
+			body:     `{"Parse":"system reboot"}`,
+			expected: [][]string{{"system", "reboot"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			commands, err := Commands(test.path, []byte(test.body))
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, commands)
+		})
+	}
+
+	t.Run("Invalid body", func(t *testing.T) {
+		_, err := Commands("/rci/", []byte(`{"show":`))
+		assert.Error(t, err)
+	})
+}
+
+func TestPolicyCheck(t *testing.T) {
+	policy := Policy{
+		Allow: []string{"ip hotspot wake", "show"},
+		Deny:  []string{"show running-config"},
+	}
+
+	t.Run("Allowed command", func(t *testing.T) {
+		assert.NoError(t, policy.Check("/rci/ip/hotspot/wake", []byte(`{"mac":"00:11:22:33:44:55"}`)))
+	})
+
+	t.Run("Allowed batch", func(t *testing.T) {
+		assert.NoError(t, policy.Check("/rci/", []byte(`[{"show":{"version":{}}},{"ip":{"hotspot":{"wake":{"mac":"x"}}}}]`)))
+	})
+
+	t.Run("Denied command in batch", func(t *testing.T) {
+		err := policy.Check("/rci/", []byte(`[{"show":{"version":{}}},{"system":{"reboot":{}}}]`))
+		assert.Equal(t, &CommandError{Command: "system reboot"}, err)
+	})
+
+	t.Run("Deny rule wins", func(t *testing.T) {
+		err := policy.Check("/rci/", []byte(`{"show":{"running-config":{}}}`))
+		assert.Equal(t, &CommandError{Command: "show running-config"}, err)
+	})
+
+	t.Run("Denied parse command", func(t *testing.T) {
+		err := policy.Check("/rci/", []byte(`{"parse":"system reboot"}`))
+		assert.Equal(t, &CommandError{Command: "system reboot"}, err)
+
+		err = policy.Check("/rci/parse", []byte(`"system reboot"`))
+		assert.Equal(t, &CommandError{Command: "system reboot"}, err)
+
+		err = policy.Check("/rci/", []byte(`{"parse":["show version","system reboot"]}`))
+		assert.Equal(t, &CommandError{Command: "system reboot"}, err)
+	})
+
+	t.Run("Mixed case", func(t *testing.T) {
+		err := policy.Check("/RCI/System", []byte(`{"Reboot":{}}`))
+		assert.Equal(t, &CommandError{Command: "System Reboot"}, err)
+
+		err = policy.Check("/rci/", []byte(`{"SHOW":{"Running-Config":{}}}`))
+		assert.Equal(t, &CommandError{Command: "SHOW Running-Config"}, err)
+	})
+
+	t.Run("Non RCI path", func(t *testing.T) {
+		assert.NoError(t, policy.Check("/auth", []byte(`{"login":"admin"}`)))
+	})
+}